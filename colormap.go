@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Colormap maps numbers to colors. A continuous colormap blends evenly spaced
+// color stops; a categorical one is a palette of distinct colors for values
+// that are labels rather than amounts.
+type Colormap struct {
+	Name        string
+	Categorical bool
+	stops       []color.RGBA
+}
+
+// hexColors parses colors written as #rrggbb, for the colormap tables
+func hexColors(hexes ...string) []color.RGBA {
+	colors := make([]color.RGBA, len(hexes))
+	for i, hex := range hexes {
+		c, ok := parseHexColor(hex)
+		if !ok {
+			panic("invalid color " + hex)
+		}
+		colors[i] = c
+	}
+	return colors
+}
+
+// Colormaps are the available colormaps, the default first. Viridis and
+// plasma are perceptually uniform, coolwarm diverges from blue through grey
+// to red, and category10 and pastel are categorical palettes.
+var Colormaps = []*Colormap{
+	{Name: "viridis", stops: hexColors(
+		"#440154", "#482475", "#414487", "#355f8d", "#2a788e", "#21918c",
+		"#22a884", "#44bf70", "#7ad151", "#bddf26", "#fde725")},
+	{Name: "plasma", stops: hexColors(
+		"#0d0887", "#41049d", "#6a00a8", "#8f0da4", "#b12a90", "#cc4778",
+		"#e16462", "#f2844b", "#fca636", "#fcce25", "#f0f921")},
+	{Name: "coolwarm", stops: hexColors(
+		"#3b4cc0", "#6282ea", "#8db0fe", "#b8d0f9", "#dddddd", "#f5c4ac",
+		"#f49a7b", "#de604d", "#b40426")},
+	{Name: "category10", Categorical: true, stops: hexColors(
+		"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+		"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf")},
+	{Name: "pastel", Categorical: true, stops: hexColors(
+		"#8dd3c7", "#ffffb3", "#bebada", "#fb8072", "#80b1d3", "#fdb462",
+		"#b3de69", "#fccde5", "#d9d9d9", "#bc80bd", "#ccebc5", "#ffed6f")},
+}
+
+// ColormapNames returns the names of the available colormaps
+func ColormapNames() []string {
+	names := make([]string, len(Colormaps))
+	for i, c := range Colormaps {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// ColormapByName returns the colormap with the given name, in any case
+func ColormapByName(name string) (*Colormap, error) {
+	for _, c := range Colormaps {
+		if strings.EqualFold(c.Name, name) {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown colormap %q: expected one of %s", name, strings.Join(ColormapNames(), ", "))
+}
+
+// At returns the color at t, from 0 at the start of the colormap to 1 at its
+// end. Values outside the range are clamped. Continuous colormaps blend their
+// stops; categorical ones pick the nearest color.
+func (c *Colormap) At(t float64) color.RGBA {
+	if math.IsNaN(t) {
+		t = 0
+	}
+	t = math.Max(0, math.Min(1, t))
+	position := t * float64(len(c.stops)-1)
+	if c.Categorical {
+		return c.stops[int(math.Round(position))]
+	}
+
+	i := int(position)
+	if i >= len(c.stops)-1 {
+		return c.stops[len(c.stops)-1]
+	}
+	f := position - float64(i)
+	a, b := c.stops[i], c.stops[i+1]
+	blend := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + f*(float64(y)-float64(x))))
+	}
+	return color.RGBA{blend(a.R, b.R), blend(a.G, b.G), blend(a.B, b.B), 255}
+}
+
+// Category returns the color of the category with index k of n. Categorical
+// colormaps give each category the next color of the palette, repeating once
+// it runs out; continuous ones spread the categories evenly along the map.
+func (c *Colormap) Category(k, n int) color.RGBA {
+	if c.Categorical {
+		return c.stops[k%len(c.stops)]
+	}
+	if n < 2 {
+		return c.At(0)
+	}
+	return c.At(float64(k) / float64(n-1))
+}
+
+// ColorScale maps values to the colors of a colormap. Numbers are mapped over
+// the range from Min to Max; categories are numbered in the order they were
+// added and colored as described for Colormap.Category.
+type ColorScale struct {
+	Colormap *Colormap
+	Min, Max float64
+
+	// Categories are the names of the categories, or nil for numbers
+	Categories []string
+	categories map[string]int
+}
+
+// NewColorScale creates a scale for numbers over an empty range
+func NewColorScale(colormap *Colormap) *ColorScale {
+	return &ColorScale{Colormap: colormap, Min: math.NaN(), Max: math.NaN()}
+}
+
+// Include widens the range of the scale to cover value
+func (s *ColorScale) Include(value float64) {
+	if !(value >= s.Min) {
+		s.Min = value
+	}
+	if !(value <= s.Max) {
+		s.Max = value
+	}
+}
+
+// AddCategory adds a category to the scale unless it already has it, and
+// returns its index
+func (s *ColorScale) AddCategory(name string) int {
+	if s.categories == nil {
+		s.categories = make(map[string]int)
+	}
+	if k, ok := s.categories[name]; ok {
+		return k
+	}
+	s.categories[name] = len(s.Categories)
+	s.Categories = append(s.Categories, name)
+	return len(s.Categories) - 1
+}
+
+// Categorical reports whether the scale maps categories rather than numbers
+func (s *ColorScale) Categorical() bool {
+	return s.Categories != nil
+}
+
+// Color returns the color of a number. Numbers outside the range get the
+// color of the nearest end, and all numbers get the middle color when the
+// range is empty.
+func (s *ColorScale) Color(value float64) color.RGBA {
+	if !(s.Max > s.Min) {
+		return s.Colormap.At(0.5)
+	}
+	return s.Colormap.At((value - s.Min) / (s.Max - s.Min))
+}
+
+// CategoryColor returns the color of a category, adding it if it's new
+func (s *ColorScale) CategoryColor(name string) color.RGBA {
+	return s.Colormap.Category(s.AddCategory(name), len(s.Categories))
+}
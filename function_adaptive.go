@@ -0,0 +1,308 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// adaptiveMaxDepth is the number of times a grid cell may be halved
+const adaptiveMaxDepth = 8
+
+// adaptiveCell is a cell of the adaptive sampling quadtree. Its bounds are
+// on an integer lattice whose spacing is the grid step / 2^adaptiveMaxDepth.
+type adaptiveCell struct {
+	ix0, iy0, ix1, iy1 int
+	depth              int
+	err                float64
+	split              bool
+}
+
+// adaptiveQueue orders cells by decreasing error, so the worst sampled
+// cells are subdivided first
+type adaptiveQueue struct {
+	cells []*adaptiveCell
+}
+
+func (q *adaptiveQueue) Len() int           { return len(q.cells) }
+func (q *adaptiveQueue) Less(i, j int) bool { return q.cells[i].err > q.cells[j].err }
+func (q *adaptiveQueue) Swap(i, j int)      { q.cells[i], q.cells[j] = q.cells[j], q.cells[i] }
+func (q *adaptiveQueue) Push(x any)         { q.cells = append(q.cells, x.(*adaptiveCell)) }
+func (q *adaptiveQueue) Pop() any {
+	cell := q.cells[len(q.cells)-1]
+	q.cells = q.cells[:len(q.cells)-1]
+	return cell
+}
+
+// adaptiveSample is the function value at a lattice point
+type adaptiveSample struct {
+	z     float64
+	valid bool
+}
+
+// GenerateAdaptivePointsFromFunction is like GeneratePointsFromFunction, but
+// starts from the grid given by step and recursively subdivides cells where
+// the surface bends more than tolerance allows. A cell's error is the largest
+// second difference at its centre and edge midpoints, that is, how far the
+// function there is from the average of the cell corners. Cells that are
+// only partly defined are refined too, to trace the edges of the domain.
+// Subdivision stops when the worst cell is within tolerance or the surface
+// would exceed maxPoints points.
+//
+// Each cell becomes one face whose boundary also passes through the corners
+// of any smaller neighbouring cells, so the mesh has no cracks. As on the
+// uniform grid, points get exact normals when the function is differentiable,
+// and the report covers every sample taken, including those used only to
+// measure cell errors.
+func GenerateAdaptivePointsFromFunction(space *Space3D, function string, params []Param, xMin, xMax, yMin, yMax, step, tolerance float64, maxPoints int) (*EvaluationReport, error) {
+	return GenerateAdaptivePointsFromFunctionContext(context.Background(), space, function, params, xMin, xMax, yMin, yMax, step, tolerance, maxPoints, nil)
+}
+
+// GenerateAdaptivePointsFromFunctionContext is like
+// GenerateAdaptivePointsFromFunction, but stops early with the context's
+// error when ctx is cancelled, leaving space unchanged. Progress, if not nil,
+// is called with the number of points placed out of maxPoints, or out of the
+// points of the base grid when there is no limit.
+func GenerateAdaptivePointsFromFunctionContext(ctx context.Context, space *Space3D, function string, params []Param, xMin, xMax, yMin, yMax, step, tolerance float64, maxPoints int, progress ProgressFunc) (*EvaluationReport, error) {
+	if err := checkGrid(xMin, xMax, yMin, yMax, step); err != nil {
+		return nil, err
+	}
+	if tolerance <= 0 {
+		return nil, fmt.Errorf("tolerance must be positive")
+	}
+
+	eval, err := NewFunctionEvaluator(function, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := 1 << adaptiveMaxDepth
+	unit := step / float64(scale)
+	position := func(ix, iy int) (float64, float64) {
+		return xMin + float64(ix)*unit, yMin + float64(iy)*unit
+	}
+
+	// Evaluate each lattice point once
+	report := newEvaluationReport()
+	samples := make(map[[2]int]adaptiveSample)
+	sample := func(ix, iy int) adaptiveSample {
+		key := [2]int{ix, iy}
+		if s, ok := samples[key]; ok {
+			return s
+		}
+		x, y := position(ix, iy)
+		z, err := checkValue(eval.Evaluate(x, y))
+		report.record(x, y, err)
+		s := adaptiveSample{z: z, valid: err == nil}
+		samples[key] = s
+		return s
+	}
+
+	// cellError measures how poorly the cell corners describe the function
+	cellError := func(c *adaptiveCell) float64 {
+		mx, my := (c.ix0+c.ix1)/2, (c.iy0+c.iy1)/2
+		cornerSamples := [4]adaptiveSample{
+			sample(c.ix0, c.iy0), sample(c.ix1, c.iy0),
+			sample(c.ix1, c.iy1), sample(c.ix0, c.iy1),
+		}
+		mids := [4]adaptiveSample{
+			sample(mx, c.iy0), sample(c.ix1, my),
+			sample(mx, c.iy1), sample(c.ix0, my),
+		}
+		centre := sample(mx, my)
+
+		valid := 0
+		for _, s := range append(cornerSamples[:], append(mids[:], centre)...) {
+			if s.valid {
+				valid++
+			}
+		}
+		if valid == 0 {
+			return 0
+		}
+		if valid < 9 {
+			return math.Inf(1)
+		}
+
+		worst := math.Abs(centre.z - (cornerSamples[0].z+cornerSamples[1].z+cornerSamples[2].z+cornerSamples[3].z)/4)
+		for i, mid := range mids {
+			a, b := cornerSamples[i], cornerSamples[(i+1)%4]
+			worst = math.Max(worst, math.Abs(mid.z-(a.z+b.z)/2))
+		}
+		return worst
+	}
+
+	// Base grid, sized as in GeneratePointsFromFunction
+	rows, cols := gridSize(xMin, xMax, yMin, yMax, step)
+	total := maxPoints
+	if total <= 0 {
+		total = rows * cols
+	}
+
+	var cells []*adaptiveCell
+	queue := &adaptiveQueue{}
+	corners := make(map[[2]int]bool)
+	for i := 0; i+1 < rows; i++ {
+		for j := 0; j+1 < cols; j++ {
+			c := &adaptiveCell{ix0: i * scale, iy0: j * scale, ix1: (i + 1) * scale, iy1: (j + 1) * scale}
+			c.err = cellError(c)
+			cells = append(cells, c)
+			if c.err > tolerance {
+				queue.Push(c)
+			}
+			for _, p := range [][2]int{{c.ix0, c.iy0}, {c.ix1, c.iy0}, {c.ix1, c.iy1}, {c.ix0, c.iy1}} {
+				corners[p] = true
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(min(len(corners), total), total)
+		}
+	}
+	heap.Init(queue)
+
+	// Split the worst cells while the point budget allows
+	for queue.Len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c := heap.Pop(queue).(*adaptiveCell)
+		if c.depth >= adaptiveMaxDepth {
+			continue
+		}
+
+		mx, my := (c.ix0+c.ix1)/2, (c.iy0+c.iy1)/2
+		added := 0
+		newCorners := [][2]int{{mx, c.iy0}, {c.ix1, my}, {mx, c.iy1}, {c.ix0, my}, {mx, my}}
+		for _, p := range newCorners {
+			if !corners[p] {
+				added++
+			}
+		}
+		if maxPoints > 0 && len(corners)+added > maxPoints {
+			break
+		}
+		for _, p := range newCorners {
+			corners[p] = true
+		}
+
+		c.split = true
+		for _, child := range []*adaptiveCell{
+			{ix0: c.ix0, iy0: c.iy0, ix1: mx, iy1: my},
+			{ix0: mx, iy0: c.iy0, ix1: c.ix1, iy1: my},
+			{ix0: mx, iy0: my, ix1: c.ix1, iy1: c.iy1},
+			{ix0: c.ix0, iy0: my, ix1: mx, iy1: c.iy1},
+		} {
+			child.depth = c.depth + 1
+			child.err = cellError(child)
+			cells = append(cells, child)
+			if child.err > tolerance {
+				heap.Push(queue, child)
+			}
+		}
+		if progress != nil && maxPoints > 0 {
+			progress(len(corners), total)
+		}
+	}
+	if progress != nil {
+		progress(total, total)
+	}
+
+	// Index the cell corners along each lattice line, so each cell can
+	// find the corners of smaller neighbours lying on its edges
+	alongX := make(map[int][]int) // x coordinates of corners on each horizontal line
+	alongY := make(map[int][]int) // y coordinates of corners on each vertical line
+	for p := range corners {
+		alongX[p[1]] = append(alongX[p[1]], p[0])
+		alongY[p[0]] = append(alongY[p[0]], p[1])
+	}
+	for _, xs := range alongX {
+		sort.Ints(xs)
+	}
+	for _, ys := range alongY {
+		sort.Ints(ys)
+	}
+	between := func(sorted []int, from, to int) []int {
+		lo := sort.SearchInts(sorted, from)
+		hi := sort.SearchInts(sorted, to)
+		return sorted[lo:hi]
+	}
+
+	// Add the defined corners as points
+	index := make(map[[2]int]int)
+	keys := make([][2]int, 0, len(corners))
+	for p := range corners {
+		keys = append(keys, p)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	var points []Point3D
+	for _, p := range keys {
+		s := sample(p[0], p[1])
+		if !s.valid {
+			continue
+		}
+		x, y := position(p[0], p[1])
+		index[p] = len(points)
+		points = append(points, NewPoint3D(x, y, s.z))
+	}
+
+	// Each leaf cell becomes a face running counter-clockwise around it
+	var faces []Face
+	for _, c := range cells {
+		if c.split {
+			continue
+		}
+
+		var boundary [][2]int
+		for _, x := range between(alongX[c.iy0], c.ix0, c.ix1) {
+			boundary = append(boundary, [2]int{x, c.iy0})
+		}
+		for _, y := range between(alongY[c.ix1], c.iy0, c.iy1) {
+			boundary = append(boundary, [2]int{c.ix1, y})
+		}
+		xs := between(alongX[c.iy1], c.ix0+1, c.ix1+1)
+		for i := len(xs) - 1; i >= 0; i-- {
+			boundary = append(boundary, [2]int{xs[i], c.iy1})
+		}
+		ys := between(alongY[c.ix0], c.iy0+1, c.iy1+1)
+		for i := len(ys) - 1; i >= 0; i-- {
+			boundary = append(boundary, [2]int{c.ix0, ys[i]})
+		}
+
+		face := make(Face, 0, len(boundary))
+		for _, p := range boundary {
+			i, ok := index[p]
+			if !ok {
+				face = nil
+				break
+			}
+			face = append(face, i)
+		}
+		if face != nil {
+			faces = append(faces, face)
+		}
+	}
+
+	start := len(space.Points)
+	space.AddMesh(points, faces)
+
+	// Exact normals, when the function can be differentiated
+	if gradient, err := NewGradient(function, params...); err == nil {
+		for i, p := range points {
+			if normal, err := gradient.Normal(p.X, p.Y); err == nil {
+				space.SetNormal(start+i, normal)
+			}
+		}
+	}
+
+	return report, nil
+}
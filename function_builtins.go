@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"math"
+	"sync"
+)
+
+// BuiltinFunc implements a function callable from function expressions.
+// It receives the evaluated arguments, which are reused once it returns, and
+// returns an error when they are outside the function's domain.
+type BuiltinFunc func(args []float64) (float64, error)
+
+// Variadic is the arity of functions that accept one or more arguments
+const Variadic = -1
+
+// builtin is a registered function and the number of arguments it takes.
+// Built-ins also have a rule for differentiating calls to them, and most
+// have a complex implementation.
+type builtin struct {
+	arity     int
+	fn        BuiltinFunc
+	complexFn ComplexFunc
+	deriv     derivativeRule
+}
+
+var (
+	registryMu sync.RWMutex
+	functions  = map[string]builtin{}
+	constants  = map[string]float64{}
+)
+
+// RegisterFunction makes fn callable from function expressions under the given
+// name. arity is the exact number of arguments, or Variadic for functions taking
+// one or more. Registering an existing name replaces the previous function.
+func RegisterFunction(name string, arity int, fn BuiltinFunc) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid function name: %q", name)
+	}
+	if name == "if" {
+		return fmt.Errorf("function name %q is reserved", name)
+	}
+	if arity < Variadic {
+		return fmt.Errorf("invalid arity %d for function %s", arity, name)
+	}
+	if fn == nil {
+		return fmt.Errorf("function %s has no implementation", name)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	functions[name] = builtin{arity: arity, fn: fn}
+	return nil
+}
+
+// RegisterConstant makes a named constant usable in function expressions.
+// Variables of an expression take precedence over constants of the same name.
+func RegisterConstant(name string, value float64) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid constant name: %q", name)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	constants[name] = value
+	return nil
+}
+
+// lookupFunction returns the registered function with the given name
+func lookupFunction(name string) (builtin, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	b, ok := functions[name]
+	return b, ok
+}
+
+// lookupConstant returns the registered constant with the given name
+func lookupConstant(name string) (float64, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	value, ok := constants[name]
+	return value, ok
+}
+
+// isIdentifier reports whether name can be written as an identifier in an expression
+func isIdentifier(name string) bool {
+	tokens, err := tokenizeExpression(name)
+	return err == nil && len(tokens) == 2 && tokens[0].lit == name && tokens[0].tok == token.IDENT
+}
+
+// unary adapts a one-argument math function that is defined everywhere
+func unary(fn func(float64) float64) BuiltinFunc {
+	return func(args []float64) (float64, error) {
+		return fn(args[0]), nil
+	}
+}
+
+// binary adapts a two-argument math function that is defined everywhere
+func binary(fn func(float64, float64) float64) BuiltinFunc {
+	return func(args []float64) (float64, error) {
+		return fn(args[0], args[1]), nil
+	}
+}
+
+// domain adapts a one-argument math function that is only defined where
+// valid returns true, reporting message otherwise
+func domain(fn func(float64) float64, valid func(float64) bool, message string) BuiltinFunc {
+	return func(args []float64) (float64, error) {
+		if !valid(args[0]) {
+			return 0, fmt.Errorf("%s", message)
+		}
+		return fn(args[0]), nil
+	}
+}
+
+func init() {
+	builtins := map[string]struct {
+		arity int
+		fn    BuiltinFunc
+	}{
+		// Trigonometric
+		"sin":   {1, unary(math.Sin)},
+		"cos":   {1, unary(math.Cos)},
+		"tan":   {1, unary(math.Tan)},
+		"asin":  {1, domain(math.Asin, func(a float64) bool { return a >= -1 && a <= 1 }, "arcsine of number outside [-1, 1]")},
+		"acos":  {1, domain(math.Acos, func(a float64) bool { return a >= -1 && a <= 1 }, "arccosine of number outside [-1, 1]")},
+		"atan":  {1, unary(math.Atan)},
+		"atan2": {2, binary(math.Atan2)},
+
+		// Hyperbolic
+		"sinh":  {1, unary(math.Sinh)},
+		"cosh":  {1, unary(math.Cosh)},
+		"tanh":  {1, unary(math.Tanh)},
+		"asinh": {1, unary(math.Asinh)},
+		"acosh": {1, domain(math.Acosh, func(a float64) bool { return a >= 1 }, "inverse hyperbolic cosine of number less than 1")},
+		"atanh": {1, domain(math.Atanh, func(a float64) bool { return a > -1 && a < 1 }, "inverse hyperbolic tangent of number outside (-1, 1)")},
+
+		// Powers, roots and logarithms
+		"sqrt":  {1, domain(math.Sqrt, func(a float64) bool { return a >= 0 }, "square root of negative number")},
+		"cbrt":  {1, unary(math.Cbrt)},
+		"exp":   {1, unary(math.Exp)},
+		"log":   {1, domain(math.Log, func(a float64) bool { return a > 0 }, "logarithm of non-positive number")},
+		"log2":  {1, domain(math.Log2, func(a float64) bool { return a > 0 }, "logarithm of non-positive number")},
+		"log10": {1, domain(math.Log10, func(a float64) bool { return a > 0 }, "logarithm of non-positive number")},
+		"pow": {2, func(args []float64) (float64, error) {
+			return power(args[0], args[1])
+		}},
+		"hypot": {2, binary(math.Hypot)},
+
+		// Rounding and sign
+		"abs":   {1, unary(math.Abs)},
+		"floor": {1, unary(math.Floor)},
+		"ceil":  {1, unary(math.Ceil)},
+		"round": {1, unary(math.Round)},
+		"trunc": {1, unary(math.Trunc)},
+		"sign": {1, func(args []float64) (float64, error) {
+			switch {
+			case args[0] > 0:
+				return 1, nil
+			case args[0] < 0:
+				return -1, nil
+			default:
+				return 0, nil
+			}
+		}},
+
+		// Selection and interpolation
+		"min": {Variadic, func(args []float64) (float64, error) {
+			result := args[0]
+			for _, a := range args[1:] {
+				result = math.Min(result, a)
+			}
+			return result, nil
+		}},
+		"max": {Variadic, func(args []float64) (float64, error) {
+			result := args[0]
+			for _, a := range args[1:] {
+				result = math.Max(result, a)
+			}
+			return result, nil
+		}},
+		"clamp": {3, func(args []float64) (float64, error) {
+			value, lo, hi := args[0], args[1], args[2]
+			if lo > hi {
+				return 0, fmt.Errorf("clamp with lower bound greater than upper bound")
+			}
+			return math.Max(lo, math.Min(hi, value)), nil
+		}},
+		"lerp": {3, func(args []float64) (float64, error) {
+			a, b, t := args[0], args[1], args[2]
+			return a + (b-a)*t, nil
+		}},
+
+		// Parts of complex numbers, as they apply to real ones
+		"re":   {1, unary(func(a float64) float64 { return a })},
+		"im":   {1, unary(func(float64) float64 { return 0 })},
+		"arg":  {1, unary(func(a float64) float64 { return math.Atan2(0, a) })},
+		"conj": {1, unary(func(a float64) float64 { return a })},
+	}
+
+	for name, b := range builtins {
+		if err := RegisterFunction(name, b.arity, b.fn); err != nil {
+			panic(err)
+		}
+	}
+
+	// Registering a function under a built-in's name drops its derivative
+	// rule and complex implementation
+	for name := range builtins {
+		b := functions[name]
+		b.deriv = builtinDerivatives[name]
+		b.complexFn = complexBuiltins[name]
+		functions[name] = b
+	}
+
+	for name, value := range map[string]float64{
+		"pi":  math.Pi,
+		"e":   math.E,
+		"tau": 2 * math.Pi,
+	} {
+		if err := RegisterConstant(name, value); err != nil {
+			panic(err)
+		}
+	}
+}
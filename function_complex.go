@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"image/color"
+	"math"
+	"math/cmplx"
+)
+
+// ComplexFunc implements a built-in function over complex numbers
+type ComplexFunc func(args []complex128) (complex128, error)
+
+// NewComplexFunctionEvaluator creates an evaluator for an expression in the
+// complex variable z. i is the imaginary unit, so z^2 + 2*i*z is a valid
+// expression. Parameters are real.
+func NewComplexFunctionEvaluator(function string, params ...Param) (*FunctionEvaluator, error) {
+	return compileEvaluator(function, []string{"z"}, params, true)
+}
+
+// EvaluateComplex calculates the result of a complex function with values
+// bound to the evaluator's variables in declaration order
+func (f *FunctionEvaluator) EvaluateComplex(values ...complex128) (complex128, error) {
+	if len(values) != len(f.vars) {
+		return 0, fmt.Errorf("expected %d variable values, got %d", len(f.vars), len(values))
+	}
+	return f.root.evalComplex(values)
+}
+
+// complexUnary adapts a one-argument complex math function
+func complexUnary(fn func(complex128) complex128) ComplexFunc {
+	return func(args []complex128) (complex128, error) {
+		return fn(args[0]), nil
+	}
+}
+
+// complexPower raises base to exponent, rejecting zero to a negative power
+func complexPower(base, exponent complex128) (complex128, error) {
+	if base == 0 && real(exponent) < 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return cmplx.Pow(base, exponent), nil
+}
+
+// complexBuiltins are the complex implementations of built-in functions.
+// Functions without one, such as floor, can't be used in complex expressions.
+var complexBuiltins = map[string]ComplexFunc{
+	// Trigonometric
+	"sin":  complexUnary(cmplx.Sin),
+	"cos":  complexUnary(cmplx.Cos),
+	"tan":  complexUnary(cmplx.Tan),
+	"asin": complexUnary(cmplx.Asin),
+	"acos": complexUnary(cmplx.Acos),
+	"atan": complexUnary(cmplx.Atan),
+
+	// Hyperbolic
+	"sinh":  complexUnary(cmplx.Sinh),
+	"cosh":  complexUnary(cmplx.Cosh),
+	"tanh":  complexUnary(cmplx.Tanh),
+	"asinh": complexUnary(cmplx.Asinh),
+	"acosh": complexUnary(cmplx.Acosh),
+	"atanh": complexUnary(cmplx.Atanh),
+
+	// Powers, roots and logarithms, taking principal values
+	"sqrt": complexUnary(cmplx.Sqrt),
+	"exp":  complexUnary(cmplx.Exp),
+	"log": func(args []complex128) (complex128, error) {
+		if args[0] == 0 {
+			return 0, fmt.Errorf("logarithm of zero")
+		}
+		return cmplx.Log(args[0]), nil
+	},
+	"log2": func(args []complex128) (complex128, error) {
+		if args[0] == 0 {
+			return 0, fmt.Errorf("logarithm of zero")
+		}
+		return cmplx.Log(args[0]) / math.Ln2, nil
+	},
+	"log10": func(args []complex128) (complex128, error) {
+		if args[0] == 0 {
+			return 0, fmt.Errorf("logarithm of zero")
+		}
+		return cmplx.Log10(args[0]), nil
+	},
+	"pow": func(args []complex128) (complex128, error) {
+		return complexPower(args[0], args[1])
+	},
+
+	// Parts of complex numbers
+	"abs":  complexUnary(func(z complex128) complex128 { return complex(cmplx.Abs(z), 0) }),
+	"re":   complexUnary(func(z complex128) complex128 { return complex(real(z), 0) }),
+	"im":   complexUnary(func(z complex128) complex128 { return complex(imag(z), 0) }),
+	"arg":  complexUnary(func(z complex128) complex128 { return complex(cmplx.Phase(z), 0) }),
+	"conj": complexUnary(cmplx.Conj),
+}
+
+// imaginaryNode is the imaginary unit i of complex expressions
+type imaginaryNode struct{}
+
+func (n *imaginaryNode) eval([]float64) (float64, error) {
+	return 0, fmt.Errorf("imaginary unit in a real expression")
+}
+
+func (n *imaginaryNode) evalComplex([]complex128) (complex128, error) {
+	return 1i, nil
+}
+
+func (n *imaginaryNode) derive(int) (exprNode, error) {
+	return numberOf(0), nil
+}
+
+func (n *imaginaryNode) String() string {
+	return "i"
+}
+
+func (n *numberNode) evalComplex([]complex128) (complex128, error) {
+	return complex(n.value, 0), nil
+}
+
+func (n *variableNode) evalComplex(vars []complex128) (complex128, error) {
+	return vars[n.index], nil
+}
+
+func (n *paramNode) evalComplex([]complex128) (complex128, error) {
+	return complex(n.values[n.index], 0), nil
+}
+
+func (n *unaryNode) evalComplex(vars []complex128) (complex128, error) {
+	operand, err := n.x.evalComplex(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case token.SUB:
+		return -operand, nil
+	case token.NOT:
+		return complex(boolValue(operand == 0), 0), nil
+	default:
+		return operand, nil
+	}
+}
+
+func (n *binaryNode) evalComplex(vars []complex128) (complex128, error) {
+	left, err := n.x.evalComplex(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	// Logical operators short-circuit so the right operand may be undefined
+	switch {
+	case n.op == token.LAND && left == 0:
+		return 0, nil
+	case n.op == token.LOR && left != 0:
+		return 1, nil
+	}
+
+	right, err := n.y.evalComplex(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case token.ADD:
+		return left + right, nil
+	case token.SUB:
+		return left - right, nil
+	case token.MUL:
+		return left * right, nil
+	case token.QUO:
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	case token.XOR:
+		return complexPower(left, right)
+	case token.EQL:
+		return complex(boolValue(left == right), 0), nil
+	case token.NEQ:
+		return complex(boolValue(left != right), 0), nil
+	case token.LAND, token.LOR:
+		return complex(boolValue(right != 0), 0), nil
+	}
+
+	// Ordering and modulo are only defined on the real line
+	if imag(left) != 0 || imag(right) != 0 {
+		return 0, fmt.Errorf("operator %v is only defined for real numbers", n.op)
+	}
+	value, err := (&binaryNode{op: n.op, x: numberOf(real(left)), y: numberOf(real(right))}).eval(nil)
+	return complex(value, 0), err
+}
+
+func (n *ifNode) evalComplex(vars []complex128) (complex128, error) {
+	cond, err := n.cond.evalComplex(vars)
+	if err != nil {
+		return 0, err
+	}
+	if cond != 0 {
+		return n.then.evalComplex(vars)
+	}
+	return n.otherwise.evalComplex(vars)
+}
+
+func (n *callNode) evalComplex(vars []complex128) (complex128, error) {
+	if n.complexFn == nil {
+		return 0, fmt.Errorf("function %s is not defined for complex numbers", n.name)
+	}
+
+	args := make([]complex128, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.evalComplex(vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = value
+	}
+	return n.complexFn(args)
+}
+
+// GeneratePointsFromComplexFunction plots a function of the complex variable
+// z = x + iy on the same grid as GeneratePointsFromFunction. The height of each
+// point is |f(z)| and its color encodes arg f(z) as a hue, so the phase winds
+// once through the colors around each simple zero or pole.
+func GeneratePointsFromComplexFunction(space *Space3D, function string, params []Param, xMin, xMax, yMin, yMax, step float64) (*EvaluationReport, error) {
+	return GeneratePointsFromComplexFunctionContext(context.Background(), space, function, params, xMin, xMax, yMin, yMax, step, nil)
+}
+
+// GeneratePointsFromComplexFunctionContext is like GeneratePointsFromComplexFunction,
+// with cancellation and progress as in GeneratePointsFromFunctionContext
+func GeneratePointsFromComplexFunctionContext(ctx context.Context, space *Space3D, function string, params []Param, xMin, xMax, yMin, yMax, step float64, progress ProgressFunc) (*EvaluationReport, error) {
+	if err := checkGrid(xMin, xMax, yMin, yMax, step); err != nil {
+		return nil, err
+	}
+
+	eval, err := NewComplexFunctionEvaluator(function, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, cols := gridSize(xMin, xMax, yMin, yMax, step)
+	phases := make([]float64, rows*cols)
+	report, indices, err := sampleGrid(ctx, space, xMin, xMax, yMin, yMax, step, func(i int, x, y float64) (float64, error) {
+		w, err := eval.EvaluateComplex(complex(x, y))
+		phases[i] = cmplx.Phase(w)
+		return cmplx.Abs(w), err
+	}, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, index := range indices {
+		if index >= 0 {
+			space.SetColor(index, phaseColor(phases[i]))
+		}
+	}
+
+	return report, nil
+}
+
+// phaseColor maps an angle in radians to a fully saturated hue: red at 0,
+// then through yellow, green, cyan, blue and magenta back to red
+func phaseColor(phase float64) color.RGBA {
+	hue := math.Mod(phase/(2*math.Pi)+1, 1) * 6
+	sector := int(hue) % 6
+	f := hue - math.Floor(hue)
+
+	channel := func(v float64) uint8 { return uint8(math.Round(255 * v)) }
+	up, down := channel(f), channel(1-f)
+	switch sector {
+	case 0:
+		return color.RGBA{255, up, 0, 255}
+	case 1:
+		return color.RGBA{down, 255, 0, 255}
+	case 2:
+		return color.RGBA{0, 255, up, 255}
+	case 3:
+		return color.RGBA{0, down, 255, 255}
+	case 4:
+		return color.RGBA{up, 0, 255, 255}
+	default:
+		return color.RGBA{255, 0, down, 255}
+	}
+}
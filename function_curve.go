@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitExpressions splits a comma separated list of n expressions, such as
+// "cos(t),sin(t),t/3". Commas inside function call parentheses don't split.
+func SplitExpressions(s string, n int) ([]string, error) {
+	var exprs []string
+	depth := 0
+	start := 0
+
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				exprs = append(exprs, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	exprs = append(exprs, strings.TrimSpace(s[start:]))
+
+	if len(exprs) != n {
+		return nil, fmt.Errorf("expected %d comma separated expressions, got %d", n, len(exprs))
+	}
+	for i, expr := range exprs {
+		if expr == "" {
+			return nil, fmt.Errorf("expression %d is empty", i+1)
+		}
+	}
+
+	return exprs, nil
+}
+
+// GeneratePointsFromCurve samples the parametric curve (x(t), y(t), z(t)) at
+// evenly spaced t values from tMin to tMax and adds it to the given Space3D
+// instance as points connected in order. Where the curve can't be evaluated or
+// isn't finite it is broken into separate polylines, and the returned report
+// says at which t and why.
+func GeneratePointsFromCurve(space *Space3D, xExpr, yExpr, zExpr string, params []Param, tMin, tMax float64, samples int) (*EvaluationReport, error) {
+	if samples < 2 {
+		return nil, fmt.Errorf("a curve needs at least 2 samples")
+	}
+
+	// Prepare one evaluator per coordinate
+	var evals [3]*FunctionEvaluator
+	for i, expr := range []string{xExpr, yExpr, zExpr} {
+		eval, err := newEvaluator(expr, []string{"t"}, params)
+		if err != nil {
+			return nil, fmt.Errorf("%c(t): %w", "xyz"[i], err)
+		}
+		evals[i] = eval
+	}
+
+	report := newParametricReport("t")
+	var segment []Point3D
+	for i := 0; i < samples; i++ {
+		t := tMin + (tMax-tMin)*float64(i)/float64(samples-1)
+
+		var coords [3]float64
+		var err error
+		for j, eval := range evals {
+			if coords[j], err = checkValue(eval.EvaluateVars(t)); err != nil {
+				break
+			}
+		}
+		report.record(t, 0, err)
+
+		if err != nil {
+			// End the current piece of the curve at the gap
+			if len(segment) > 0 {
+				space.AddPolyline(segment)
+				segment = nil
+			}
+			continue
+		}
+
+		segment = append(segment, NewPoint3D(coords[0], coords[1], coords[2]))
+	}
+
+	if len(segment) > 0 {
+		space.AddPolyline(segment)
+	}
+
+	return report, nil
+}
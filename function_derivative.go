@@ -0,0 +1,612 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// derivativeRule differentiates a call to a built-in function by the chain
+// rule, given its arguments and the derivatives of its arguments
+type derivativeRule func(args, dargs []exprNode) exprNode
+
+// Derivative returns an evaluator for the partial derivative of the function
+// with respect to the named variable. The derivative is found symbolically
+// and simplified, and its Expression is the simplified text. It shares the
+// function's parameters, so SetParam on either evaluator affects both.
+func (f *FunctionEvaluator) Derivative(variable string) (*FunctionEvaluator, error) {
+	index := -1
+	for i, name := range f.vars {
+		if name == variable {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("unknown variable: %s", variable)
+	}
+
+	root, err := simplify(f.root).derive(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FunctionEvaluator{
+		expression:  root.String(),
+		vars:        f.vars,
+		paramNames:  f.paramNames,
+		paramValues: f.paramValues,
+		root:        root,
+	}, nil
+}
+
+// Gradient evaluates the partial derivatives of a function z = f(x, y)
+type Gradient struct {
+	DX, DY *FunctionEvaluator
+}
+
+// NewGradient differentiates a function of x and y with respect to both variables
+func NewGradient(function string, params ...Param) (*Gradient, error) {
+	eval, err := NewFunctionEvaluator(function, params...)
+	if err != nil {
+		return nil, err
+	}
+	dx, err := eval.Derivative("x")
+	if err != nil {
+		return nil, err
+	}
+	dy, err := eval.Derivative("y")
+	if err != nil {
+		return nil, err
+	}
+	return &Gradient{DX: dx, DY: dy}, nil
+}
+
+// Evaluate returns ∂f/∂x and ∂f/∂y at (x, y)
+func (g *Gradient) Evaluate(x, y float64) (float64, float64, error) {
+	dx, err := g.DX.Evaluate(x, y)
+	if err != nil {
+		return 0, 0, err
+	}
+	dy, err := g.DY.Evaluate(x, y)
+	if err != nil {
+		return 0, 0, err
+	}
+	return dx, dy, nil
+}
+
+// Magnitude returns the length of the gradient at (x, y), the steepest slope
+// of the surface there
+func (g *Gradient) Magnitude(x, y float64) (float64, error) {
+	dx, dy, err := g.Evaluate(x, y)
+	if err != nil {
+		return 0, err
+	}
+	return math.Hypot(dx, dy), nil
+}
+
+// Normal returns the upward unit normal of the surface z = f(x, y) at (x, y)
+func (g *Gradient) Normal(x, y float64) (Point3D, error) {
+	dx, dy, err := g.Evaluate(x, y)
+	if err != nil {
+		return Point3D{}, err
+	}
+	length := math.Sqrt(dx*dx + dy*dy + 1)
+	return NewPoint3D(-dx/length, -dy/length, 1/length), nil
+}
+
+func (n *numberNode) derive(int) (exprNode, error) {
+	return numberOf(0), nil
+}
+
+func (n *variableNode) derive(index int) (exprNode, error) {
+	if n.index == index {
+		return numberOf(1), nil
+	}
+	return numberOf(0), nil
+}
+
+func (n *paramNode) derive(int) (exprNode, error) {
+	return numberOf(0), nil
+}
+
+func (n *unaryNode) derive(index int) (exprNode, error) {
+	if n.op == token.NOT {
+		// Truth values are piecewise constant
+		return numberOf(0), nil
+	}
+
+	dx, err := n.x.derive(index)
+	if err != nil {
+		return nil, err
+	}
+	return simplifyUnary(n.op, dx), nil
+}
+
+func (n *binaryNode) derive(index int) (exprNode, error) {
+	switch n.op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+		// Comparisons and logical operators are piecewise constant
+		return numberOf(0), nil
+	}
+
+	dx, err := n.x.derive(index)
+	if err != nil {
+		return nil, err
+	}
+	dy, err := n.y.derive(index)
+	if err != nil {
+		return nil, err
+	}
+	x, y := n.x, n.y
+
+	switch n.op {
+	case token.ADD, token.SUB:
+		return simplifyBinary(n.op, dx, dy), nil
+	case token.MUL:
+		return sumOf(productOf(dx, y), productOf(x, dy)), nil
+	case token.QUO:
+		return quotientOf(differenceOf(productOf(dx, y), productOf(x, dy)), powerOf(y, numberOf(2))), nil
+	case token.REM:
+		// x % y is x - y*trunc(x/y), where trunc is piecewise constant
+		return differenceOf(dx, productOf(dy, callOf("trunc", quotientOf(x, y)))), nil
+	case token.XOR:
+		return powerDerivative(x, y, dx, dy), nil
+	default:
+		return nil, fmt.Errorf("unsupported binary operator: %v", n.op)
+	}
+}
+
+// powerDerivative differentiates x^y. A constant exponent uses the power rule,
+// which unlike the general rule holds for negative x.
+func powerDerivative(x, y, dx, dy exprNode) exprNode {
+	if isNumber(dy, 0) {
+		return productOf(productOf(y, powerOf(x, differenceOf(y, numberOf(1)))), dx)
+	}
+	return productOf(powerOf(x, y), sumOf(productOf(dy, callOf("log", x)), quotientOf(productOf(y, dx), x)))
+}
+
+func (n *ifNode) derive(index int) (exprNode, error) {
+	then, err := n.then.derive(index)
+	if err != nil {
+		return nil, err
+	}
+	otherwise, err := n.otherwise.derive(index)
+	if err != nil {
+		return nil, err
+	}
+	return simplifyIf(n.cond, then, otherwise), nil
+}
+
+func (n *callNode) derive(index int) (exprNode, error) {
+	if n.deriv == nil {
+		return nil, fmt.Errorf("cannot differentiate function %s", n.name)
+	}
+
+	dargs := make([]exprNode, len(n.args))
+	for i, arg := range n.args {
+		d, err := arg.derive(index)
+		if err != nil {
+			return nil, err
+		}
+		dargs[i] = d
+	}
+	return n.deriv(n.args, dargs), nil
+}
+
+// chain builds a derivative rule for a one-argument function from its
+// derivative in terms of the argument
+func chain(outer func(x exprNode) exprNode) derivativeRule {
+	return func(args, dargs []exprNode) exprNode {
+		if isNumber(dargs[0], 0) {
+			return numberOf(0)
+		}
+		return productOf(outer(args[0]), dargs[0])
+	}
+}
+
+// piecewiseConstant is the derivative rule of step functions like floor
+func piecewiseConstant([]exprNode, []exprNode) exprNode {
+	return numberOf(0)
+}
+
+// extremumDerivative differentiates min or max, following the derivative of
+// whichever argument is selected. beats is the comparison by which an
+// argument replaces the extremum of the arguments before it.
+func extremumDerivative(name string, beats token.Token) derivativeRule {
+	return func(args, dargs []exprNode) exprNode {
+		value, d := args[0], dargs[0]
+		for i := 1; i < len(args); i++ {
+			d = simplifyIf(simplifyBinary(beats, args[i], value), dargs[i], d)
+			value = callOf(name, value, args[i])
+		}
+		return d
+	}
+}
+
+// builtinDerivatives are the derivative rules of the built-in functions.
+// A function registered later under the same name has no rule, so it can't
+// be differentiated.
+var builtinDerivatives = map[string]derivativeRule{
+	// Trigonometric
+	"sin": chain(func(x exprNode) exprNode { return callOf("cos", x) }),
+	"cos": chain(func(x exprNode) exprNode { return negationOf(callOf("sin", x)) }),
+	"tan": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), powerOf(callOf("cos", x), numberOf(2)))
+	}),
+	"asin": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), callOf("sqrt", differenceOf(numberOf(1), powerOf(x, numberOf(2)))))
+	}),
+	"acos": chain(func(x exprNode) exprNode {
+		return negationOf(quotientOf(numberOf(1), callOf("sqrt", differenceOf(numberOf(1), powerOf(x, numberOf(2))))))
+	}),
+	"atan": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), sumOf(numberOf(1), powerOf(x, numberOf(2))))
+	}),
+	"atan2": func(args, dargs []exprNode) exprNode {
+		y, x := args[0], args[1]
+		return quotientOf(
+			differenceOf(productOf(x, dargs[0]), productOf(y, dargs[1])),
+			sumOf(powerOf(x, numberOf(2)), powerOf(y, numberOf(2))),
+		)
+	},
+
+	// Hyperbolic
+	"sinh": chain(func(x exprNode) exprNode { return callOf("cosh", x) }),
+	"cosh": chain(func(x exprNode) exprNode { return callOf("sinh", x) }),
+	"tanh": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), powerOf(callOf("cosh", x), numberOf(2)))
+	}),
+	"asinh": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), callOf("sqrt", sumOf(powerOf(x, numberOf(2)), numberOf(1))))
+	}),
+	"acosh": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), callOf("sqrt", differenceOf(powerOf(x, numberOf(2)), numberOf(1))))
+	}),
+	"atanh": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), differenceOf(numberOf(1), powerOf(x, numberOf(2))))
+	}),
+
+	// Powers, roots and logarithms
+	"sqrt": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), productOf(numberOf(2), callOf("sqrt", x)))
+	}),
+	"cbrt": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), productOf(numberOf(3), powerOf(callOf("cbrt", x), numberOf(2))))
+	}),
+	"exp": chain(func(x exprNode) exprNode { return callOf("exp", x) }),
+	"log": chain(func(x exprNode) exprNode { return quotientOf(numberOf(1), x) }),
+	"log2": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), productOf(x, numberOf(math.Ln2)))
+	}),
+	"log10": chain(func(x exprNode) exprNode {
+		return quotientOf(numberOf(1), productOf(x, numberOf(math.Ln10)))
+	}),
+	"pow": func(args, dargs []exprNode) exprNode {
+		return powerDerivative(args[0], args[1], dargs[0], dargs[1])
+	},
+	"hypot": func(args, dargs []exprNode) exprNode {
+		return quotientOf(
+			sumOf(productOf(args[0], dargs[0]), productOf(args[1], dargs[1])),
+			callOf("hypot", args[0], args[1]),
+		)
+	},
+
+	// Rounding and sign
+	"abs":   chain(func(x exprNode) exprNode { return callOf("sign", x) }),
+	"floor": piecewiseConstant,
+	"ceil":  piecewiseConstant,
+	"round": piecewiseConstant,
+	"trunc": piecewiseConstant,
+	"sign":  piecewiseConstant,
+
+	// Complex parts, which are trivial for real arguments
+	"re":   func(args, dargs []exprNode) exprNode { return dargs[0] },
+	"im":   piecewiseConstant,
+	"arg":  piecewiseConstant,
+	"conj": func(args, dargs []exprNode) exprNode { return dargs[0] },
+
+	// Selection and interpolation
+	"min": extremumDerivative("min", token.LSS),
+	"max": extremumDerivative("max", token.GTR),
+	"clamp": func(args, dargs []exprNode) exprNode {
+		value, lo, hi := args[0], args[1], args[2]
+		return simplifyIf(simplifyBinary(token.LSS, value, lo), dargs[1],
+			simplifyIf(simplifyBinary(token.GTR, value, hi), dargs[2], dargs[0]))
+	},
+	"lerp": func(args, dargs []exprNode) exprNode {
+		a, b, t := args[0], args[1], args[2]
+		return sumOf(
+			sumOf(dargs[0], productOf(differenceOf(dargs[1], dargs[0]), t)),
+			productOf(differenceOf(b, a), dargs[2]),
+		)
+	},
+}
+
+// The constructors below build expression nodes, simplifying the result
+// where the operands allow. Derivatives are assembled from them, so terms
+// multiplied by zero and similar clutter never make it into the tree.
+
+// numberOf returns a number node
+func numberOf(value float64) exprNode {
+	return &numberNode{value: value}
+}
+
+// isNumber reports whether n is a number node with the given value
+func isNumber(n exprNode, value float64) bool {
+	number, ok := n.(*numberNode)
+	return ok && number.value == value
+}
+
+// fold evaluates a node whose operands are all numbers, reporting false if
+// the result is undefined or not finite and so should stay symbolic
+func fold(n exprNode) (exprNode, bool) {
+	value, err := n.eval(nil)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return n, false
+	}
+	return numberOf(value), true
+}
+
+func sumOf(x, y exprNode) exprNode        { return simplifyBinary(token.ADD, x, y) }
+func differenceOf(x, y exprNode) exprNode { return simplifyBinary(token.SUB, x, y) }
+func productOf(x, y exprNode) exprNode    { return simplifyBinary(token.MUL, x, y) }
+func quotientOf(x, y exprNode) exprNode   { return simplifyBinary(token.QUO, x, y) }
+func powerOf(x, y exprNode) exprNode      { return simplifyBinary(token.XOR, x, y) }
+func negationOf(x exprNode) exprNode      { return simplifyUnary(token.SUB, x) }
+
+// callOf returns a call to a registered built-in function
+func callOf(name string, args ...exprNode) exprNode {
+	b, _ := lookupFunction(name)
+	return simplifyCall(&callNode{name: name, fn: b.fn, complexFn: b.complexFn, deriv: b.deriv, args: args})
+}
+
+// simplifyCall evaluates a call whose arguments are all numbers
+func simplifyCall(n *callNode) exprNode {
+	for _, arg := range n.args {
+		if _, ok := arg.(*numberNode); !ok {
+			return n
+		}
+	}
+	result, _ := fold(n)
+	return result
+}
+
+// simplify rebuilds an expression tree through the simplifying constructors,
+// folding constant subexpressions such as the -1 in x^-1
+func simplify(n exprNode) exprNode {
+	switch n := n.(type) {
+	case *unaryNode:
+		return simplifyUnary(n.op, simplify(n.x))
+	case *binaryNode:
+		return simplifyBinary(n.op, simplify(n.x), simplify(n.y))
+	case *ifNode:
+		return simplifyIf(simplify(n.cond), simplify(n.then), simplify(n.otherwise))
+	case *callNode:
+		args := make([]exprNode, len(n.args))
+		for i, arg := range n.args {
+			args[i] = simplify(arg)
+		}
+		return simplifyCall(&callNode{name: n.name, fn: n.fn, complexFn: n.complexFn, deriv: n.deriv, args: args})
+	default:
+		return n
+	}
+}
+
+// simplifyUnary returns the unary operation op x, simplified
+func simplifyUnary(op token.Token, x exprNode) exprNode {
+	if op == token.ADD {
+		return x
+	}
+	if _, ok := x.(*numberNode); ok {
+		result, _ := fold(&unaryNode{op: op, x: x})
+		return result
+	}
+	if inner, ok := x.(*unaryNode); ok && op == token.SUB && inner.op == token.SUB {
+		return inner.x
+	}
+	return &unaryNode{op: op, x: x}
+}
+
+// simplifyBinary returns the binary operation x op y, simplified
+func simplifyBinary(op token.Token, x, y exprNode) exprNode {
+	n := &binaryNode{op: op, x: x, y: y}
+
+	_, xNumber := x.(*numberNode)
+	_, yNumber := y.(*numberNode)
+	if xNumber && yNumber {
+		if result, ok := fold(n); ok {
+			return result
+		}
+	}
+
+	negated := func(e exprNode) (exprNode, bool) {
+		if u, ok := e.(*unaryNode); ok && u.op == token.SUB {
+			return u.x, true
+		}
+		return nil, false
+	}
+
+	switch op {
+	case token.ADD:
+		switch {
+		case isNumber(x, 0):
+			return y
+		case isNumber(y, 0):
+			return x
+		}
+		if inner, ok := negated(y); ok {
+			return differenceOf(x, inner)
+		}
+		if number, ok := y.(*numberNode); ok && number.value < 0 {
+			return differenceOf(x, numberOf(-number.value))
+		}
+
+	case token.SUB:
+		switch {
+		case isNumber(y, 0):
+			return x
+		case isNumber(x, 0):
+			return negationOf(y)
+		}
+		if inner, ok := negated(y); ok {
+			return sumOf(x, inner)
+		}
+		if number, ok := y.(*numberNode); ok && number.value < 0 {
+			return sumOf(x, numberOf(-number.value))
+		}
+
+	case token.MUL:
+		// Keep numbers on the left, so they can be combined
+		if yNumber && !xNumber {
+			x, y = y, x
+			xNumber, yNumber = true, false
+		}
+		switch {
+		case isNumber(x, 0):
+			return numberOf(0)
+		case isNumber(x, 1):
+			return y
+		case isNumber(x, -1):
+			return negationOf(y)
+		}
+		if inner, ok := negated(x); ok {
+			return negationOf(productOf(inner, y))
+		}
+		if inner, ok := negated(y); ok {
+			return negationOf(productOf(x, inner))
+		}
+		if right, ok := y.(*binaryNode); ok && xNumber && right.op == token.MUL {
+			if _, ok := right.x.(*numberNode); ok {
+				return productOf(productOf(x, right.x), right.y)
+			}
+		}
+		// 1/a * b is b/a, as the chain rule often produces
+		if left, ok := x.(*binaryNode); ok && left.op == token.QUO && isNumber(left.x, 1) {
+			return quotientOf(y, left.y)
+		}
+		if right, ok := y.(*binaryNode); ok && right.op == token.QUO && isNumber(right.x, 1) {
+			return quotientOf(x, right.y)
+		}
+		return &binaryNode{op: op, x: x, y: y}
+
+	case token.QUO:
+		switch {
+		case isNumber(x, 0):
+			return numberOf(0)
+		case isNumber(y, 1):
+			return x
+		}
+		if inner, ok := negated(x); ok {
+			return negationOf(quotientOf(inner, y))
+		}
+
+	case token.XOR:
+		switch {
+		case isNumber(y, 0):
+			return numberOf(1)
+		case isNumber(y, 1):
+			return x
+		case isNumber(x, 1):
+			return numberOf(1)
+		}
+	}
+
+	return n
+}
+
+// simplifyIf returns if(cond, then, otherwise), simplified
+func simplifyIf(cond, then, otherwise exprNode) exprNode {
+	if c, ok := cond.(*numberNode); ok {
+		if c.value != 0 {
+			return then
+		}
+		return otherwise
+	}
+	if a, ok := then.(*numberNode); ok {
+		if b, ok := otherwise.(*numberNode); ok && a.value == b.value {
+			return then
+		}
+	}
+	return &ifNode{cond: cond, then: then, otherwise: otherwise}
+}
+
+// Expression trees print in the function language, with parentheses only
+// where precedence requires them. These levels extend binaryLevels.
+var (
+	unaryLevel   = len(binaryLevels) + 1
+	powerLevel   = len(binaryLevels) + 2
+	primaryLevel = len(binaryLevels) + 3
+)
+
+// precedence returns the precedence level of the operation at the root of n
+func precedence(n exprNode) int {
+	switch n := n.(type) {
+	case *numberNode:
+		if n.value < 0 {
+			return unaryLevel
+		}
+	case *unaryNode:
+		return unaryLevel
+	case *binaryNode:
+		if n.op == token.XOR {
+			return powerLevel
+		}
+		for level, ops := range binaryLevels {
+			for _, op := range ops {
+				if op == n.op {
+					return level + 1
+				}
+			}
+		}
+	}
+	return primaryLevel
+}
+
+// operand prints a child of an operation, in parentheses if its precedence is below min
+func operand(n exprNode, min int) string {
+	if precedence(n) < min {
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+func (n *numberNode) String() string {
+	return strconv.FormatFloat(n.value, 'g', -1, 64)
+}
+
+func (n *variableNode) String() string {
+	return n.name
+}
+
+func (n *paramNode) String() string {
+	return n.name
+}
+
+func (n *unaryNode) String() string {
+	// Parenthesize nested unary operators too, so "--" never appears
+	return n.op.String() + operand(n.x, powerLevel)
+}
+
+func (n *binaryNode) String() string {
+	if n.op == token.XOR {
+		// The base is a primary expression, the exponent may carry a sign
+		return operand(n.x, primaryLevel) + "^" + operand(n.y, unaryLevel)
+	}
+
+	level := precedence(n)
+	return operand(n.x, level) + " " + n.op.String() + " " + operand(n.y, level+1)
+}
+
+func (n *ifNode) String() string {
+	return "if(" + n.cond.String() + ", " + n.then.String() + ", " + n.otherwise.String() + ")"
+}
+
+func (n *callNode) String() string {
+	args := make([]string, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.String()
+	}
+	return n.name + "(" + strings.Join(args, ", ") + ")"
+}
@@ -1,174 +1,495 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"go/ast"
-	"go/parser"
 	"go/token"
 	"math"
-	"strings"
+	"strconv"
+	"sync"
 )
 
-// FunctionEvaluator handles parsing and evaluating mathematical functions
+// FunctionEvaluator handles parsing and evaluating mathematical functions.
+// The expression is parsed once into an expression tree, so evaluating it
+// for many points only walks the tree. Evaluation doesn't change the
+// evaluator, so it may run on several goroutines at once while no
+// parameters are being set.
 type FunctionEvaluator struct {
-	expression string
+	expression  string
+	vars        []string
+	paramNames  []string
+	paramValues []float64
+	root        exprNode
+
+	// isComplex is set for expressions over complex numbers, where i is
+	// the imaginary unit
+	isComplex bool
+}
+
+// NewFunctionEvaluator creates a new function evaluator for an expression in x and y.
+// Any params are free parameters the expression may reference by name.
+func NewFunctionEvaluator(function string, params ...Param) (*FunctionEvaluator, error) {
+	return newEvaluator(function, []string{"x", "y"}, params)
+}
+
+// newEvaluator parses an expression whose free variables are the given names.
+// The values passed to EvaluateVars are bound to the variables in the same order.
+func newEvaluator(function string, vars []string, params []Param) (*FunctionEvaluator, error) {
+	return compileEvaluator(function, vars, params, false)
+}
+
+// compileEvaluator parses and compiles an expression, over the complex numbers
+// if isComplex is set
+func compileEvaluator(function string, vars []string, params []Param, isComplex bool) (*FunctionEvaluator, error) {
+	tree, err := parseExpression(function)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expression: %v", err)
+	}
+
+	f := &FunctionEvaluator{
+		expression:  function,
+		vars:        vars,
+		paramNames:  make([]string, len(params)),
+		paramValues: make([]float64, len(params)),
+		isComplex:   isComplex,
+	}
+
+	for i, param := range params {
+		for _, name := range vars {
+			if param.Name == name {
+				return nil, fmt.Errorf("parameter %s conflicts with variable %s", param.Name, name)
+			}
+		}
+		f.paramNames[i] = param.Name
+		f.paramValues[i] = param.Value
+	}
+
+	root, err := f.compile(tree)
+	if err != nil {
+		return nil, err
+	}
+	f.root = root
+
+	return f, nil
+}
+
+// EvaluateConstant evaluates an expression without variables, such as 2*pi
+func EvaluateConstant(expr string) (float64, error) {
+	eval, err := newEvaluator(expr, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	return eval.EvaluateVars()
+}
+
+// Expression returns the source text of the function
+func (f *FunctionEvaluator) Expression() string {
+	return f.expression
 }
 
-// NewFunctionEvaluator creates a new function evaluator
-func NewFunctionEvaluator(function string) *FunctionEvaluator {
-	return &FunctionEvaluator{
-		expression: function,
+// SetParam changes the value of a free parameter for subsequent evaluations
+func (f *FunctionEvaluator) SetParam(name string, value float64) error {
+	for i, paramName := range f.paramNames {
+		if paramName == name {
+			f.paramValues[i] = value
+			return nil
+		}
 	}
+	return fmt.Errorf("unknown parameter: %s", name)
 }
 
 // Evaluate calculates the result of the function for given x, y values
 func (f *FunctionEvaluator) Evaluate(x, y float64) (float64, error) {
-	// Replace variables with values
-	expr := strings.ReplaceAll(f.expression, "x", fmt.Sprintf("%f", x))
-	expr = strings.ReplaceAll(expr, "y", fmt.Sprintf("%f", y))
-	
-	// Parse the expression
-	tree, err := parser.ParseExpr(expr)
+	return f.root.eval([]float64{x, y})
+}
+
+// EvaluateVars calculates the result of the function with values bound to
+// the evaluator's variables in declaration order
+func (f *FunctionEvaluator) EvaluateVars(values ...float64) (float64, error) {
+	if len(values) != len(f.vars) {
+		return 0, fmt.Errorf("expected %d variable values, got %d", len(f.vars), len(values))
+	}
+	return f.root.eval(values)
+}
+
+// exprNode is a node of a compiled expression tree. Besides evaluating, a
+// node can evaluate over complex numbers, differentiate itself with respect
+// to the variable at index and print itself in the function language.
+type exprNode interface {
+	eval(vars []float64) (float64, error)
+	evalComplex(vars []complex128) (complex128, error)
+	derive(index int) (exprNode, error)
+	String() string
+}
+
+// numberNode is a numeric literal
+type numberNode struct {
+	value float64
+}
+
+func (n *numberNode) eval([]float64) (float64, error) {
+	return n.value, nil
+}
+
+// variableNode looks up a bound variable by its index
+type variableNode struct {
+	name  string
+	index int
+}
+
+func (n *variableNode) eval(vars []float64) (float64, error) {
+	return vars[n.index], nil
+}
+
+// paramNode looks up a free parameter in the evaluator's parameter values,
+// so SetParam takes effect without recompiling
+type paramNode struct {
+	name   string
+	values []float64
+	index  int
+}
+
+func (n *paramNode) eval([]float64) (float64, error) {
+	return n.values[n.index], nil
+}
+
+// boolValue converts a truth value to 1 or 0
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unaryNode applies a unary operator to its operand
+type unaryNode struct {
+	op token.Token
+	x  exprNode
+}
+
+func (n *unaryNode) eval(vars []float64) (float64, error) {
+	operand, err := n.x.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case token.SUB:
+		return -operand, nil
+	case token.NOT:
+		return boolValue(operand == 0), nil
+	default:
+		return operand, nil
+	}
+}
+
+// binaryNode applies a binary operator to its operands
+type binaryNode struct {
+	op   token.Token
+	x, y exprNode
+}
+
+func (n *binaryNode) eval(vars []float64) (float64, error) {
+	left, err := n.x.eval(vars)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse expression: %v", err)
+		return 0, err
 	}
-	
-	// Evaluate the expression
-	result, err := f.evaluateExpression(tree)
+
+	// Logical operators short-circuit so the right operand may be undefined
+	switch {
+	case n.op == token.LAND && left == 0:
+		return 0, nil
+	case n.op == token.LOR && left != 0:
+		return 1, nil
+	}
+
+	right, err := n.y.eval(vars)
 	if err != nil {
 		return 0, err
 	}
-	
+
+	switch n.op {
+	case token.ADD:
+		return left + right, nil
+	case token.SUB:
+		return left - right, nil
+	case token.MUL:
+		return left * right, nil
+	case token.QUO:
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	case token.REM:
+		if right == 0 {
+			return 0, fmt.Errorf("modulo by zero")
+		}
+		return math.Mod(left, right), nil
+	case token.XOR:
+		return power(left, right)
+	case token.EQL:
+		return boolValue(left == right), nil
+	case token.NEQ:
+		return boolValue(left != right), nil
+	case token.LSS:
+		return boolValue(left < right), nil
+	case token.LEQ:
+		return boolValue(left <= right), nil
+	case token.GTR:
+		return boolValue(left > right), nil
+	case token.GEQ:
+		return boolValue(left >= right), nil
+	case token.LAND, token.LOR:
+		return boolValue(right != 0), nil
+	default:
+		return 0, fmt.Errorf("unsupported binary operator: %v", n.op)
+	}
+}
+
+// power raises base to exponent, rejecting results that are not real numbers
+func power(base, exponent float64) (float64, error) {
+	if base == 0 && exponent < 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	result := math.Pow(base, exponent)
+	if math.IsNaN(result) {
+		return 0, fmt.Errorf("negative number raised to a fractional power")
+	}
 	return result, nil
 }
 
-// evaluateExpression recursively evaluates an AST expression
-func (f *FunctionEvaluator) evaluateExpression(expr ast.Expr) (float64, error) {
+// ifNode evaluates only the branch selected by its condition, so the other
+// branch may be undefined for the current values
+type ifNode struct {
+	cond, then, otherwise exprNode
+}
+
+func (n *ifNode) eval(vars []float64) (float64, error) {
+	cond, err := n.cond.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	if cond != 0 {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+// callNode applies a registered function to its arguments
+type callNode struct {
+	name      string
+	fn        BuiltinFunc
+	complexFn ComplexFunc
+	deriv     derivativeRule
+	args      []exprNode
+}
+
+// argBuffers holds argument slices for reuse by calls. Evaluators are used
+// from several goroutines at once, so a call can't keep its own.
+var argBuffers = sync.Pool{New: func() any { return new([]float64) }}
+
+func (n *callNode) eval(vars []float64) (float64, error) {
+	buf := argBuffers.Get().(*[]float64)
+	defer argBuffers.Put(buf)
+	if cap(*buf) < len(n.args) {
+		*buf = make([]float64, len(n.args))
+	}
+	args := (*buf)[:len(n.args)]
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = value
+	}
+	return n.fn(args)
+}
+
+// compile recursively converts a parsed AST expression into an expression tree
+func (f *FunctionEvaluator) compile(expr ast.Expr) (exprNode, error) {
 	switch e := expr.(type) {
 	case *ast.BasicLit:
 		// Handle numeric literals
 		if e.Kind == token.FLOAT || e.Kind == token.INT {
-			var value float64
-			_, err := fmt.Sscanf(e.Value, "%f", &value)
+			value, err := strconv.ParseFloat(e.Value, 64)
 			if err != nil {
-				return 0, fmt.Errorf("failed to parse number: %v", err)
+				return nil, fmt.Errorf("failed to parse number: %v", err)
 			}
-			return value, nil
+			return &numberNode{value: value}, nil
 		}
-		return 0, fmt.Errorf("unsupported literal type: %v", e.Kind)
-	
-	case *ast.BinaryExpr:
-		// Handle binary operations (+, -, *, /, etc.)
-		left, err := f.evaluateExpression(e.X)
-		if err != nil {
-			return 0, err
+		return nil, fmt.Errorf("unsupported literal type: %v", e.Kind)
+
+	case *ast.Ident:
+		// Handle variables, then parameters, then the imaginary unit of
+		// complex expressions, then named constants
+		for i, name := range f.vars {
+			if e.Name == name {
+				return &variableNode{name: name, index: i}, nil
+			}
 		}
-		
-		right, err := f.evaluateExpression(e.Y)
-		if err != nil {
-			return 0, err
+		for i, name := range f.paramNames {
+			if e.Name == name {
+				return &paramNode{name: name, values: f.paramValues, index: i}, nil
+			}
 		}
-		
+		if f.isComplex && e.Name == "i" {
+			return &imaginaryNode{}, nil
+		}
+		if value, ok := lookupConstant(e.Name); ok {
+			return &numberNode{value: value}, nil
+		}
+		return nil, fmt.Errorf("unknown variable: %s", e.Name)
+
+	case *ast.BinaryExpr:
+		// Handle binary operations (+, -, *, /, %, ^, comparisons, && and ||)
 		switch e.Op {
-		case token.ADD:
-			return left + right, nil
-		case token.SUB:
-			return left - right, nil
-		case token.MUL:
-			return left * right, nil
-		case token.QUO:
-			if right == 0 {
-				return 0, fmt.Errorf("division by zero")
-			}
-			return left / right, nil
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM, token.XOR,
+			token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ,
+			token.LAND, token.LOR:
 		default:
-			return 0, fmt.Errorf("unsupported binary operator: %v", e.Op)
+			return nil, fmt.Errorf("unsupported binary operator: %v", e.Op)
+		}
+
+		left, err := f.compile(e.X)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := f.compile(e.Y)
+		if err != nil {
+			return nil, err
 		}
-	
+
+		return &binaryNode{op: e.Op, x: left, y: right}, nil
+
 	case *ast.ParenExpr:
 		// Handle parentheses
-		return f.evaluateExpression(e.X)
-	
+		return f.compile(e.X)
+
 	case *ast.CallExpr:
 		// Handle function calls (sin, cos, etc.)
 		fun, ok := e.Fun.(*ast.Ident)
 		if !ok {
-			return 0, fmt.Errorf("unsupported function call")
+			return nil, fmt.Errorf("unsupported function call")
 		}
-		
-		if len(e.Args) != 1 {
-			return 0, fmt.Errorf("function %s requires exactly one argument", fun.Name)
+
+		if fun.Name == "if" {
+			return f.compileIf(e)
 		}
-		
-		arg, err := f.evaluateExpression(e.Args[0])
-		if err != nil {
-			return 0, err
+
+		b, ok := lookupFunction(fun.Name)
+		if !ok {
+			return nil, fmt.Errorf("unsupported function: %s", fun.Name)
 		}
-		
-		switch fun.Name {
-		case "sin":
-			return math.Sin(arg), nil
-		case "cos":
-			return math.Cos(arg), nil
-		case "tan":
-			return math.Tan(arg), nil
-		case "sqrt":
-			if arg < 0 {
-				return 0, fmt.Errorf("square root of negative number")
-			}
-			return math.Sqrt(arg), nil
-		case "exp":
-			return math.Exp(arg), nil
-		case "log":
-			if arg <= 0 {
-				return 0, fmt.Errorf("logarithm of non-positive number")
+
+		if f.isComplex && b.complexFn == nil {
+			return nil, fmt.Errorf("function %s is not defined for complex numbers", fun.Name)
+		}
+
+		switch {
+		case b.arity == Variadic && len(e.Args) == 0:
+			return nil, fmt.Errorf("function %s requires at least one argument", fun.Name)
+		case b.arity != Variadic && len(e.Args) != b.arity:
+			return nil, fmt.Errorf("function %s requires exactly %d argument(s), got %d", fun.Name, b.arity, len(e.Args))
+		}
+
+		args := make([]exprNode, len(e.Args))
+		for i, arg := range e.Args {
+			node, err := f.compile(arg)
+			if err != nil {
+				return nil, err
 			}
-			return math.Log(arg), nil
-		default:
-			return 0, fmt.Errorf("unsupported function: %s", fun.Name)
+			args[i] = node
 		}
-	
+
+		return &callNode{name: fun.Name, fn: b.fn, complexFn: b.complexFn, deriv: b.deriv, args: args}, nil
+
 	case *ast.UnaryExpr:
-		// Handle unary operations (-x, +x)
-		operand, err := f.evaluateExpression(e.X)
-		if err != nil {
-			return 0, err
+		// Handle unary operations (-x, +x, !x)
+		if e.Op != token.SUB && e.Op != token.ADD && e.Op != token.NOT {
+			return nil, fmt.Errorf("unsupported unary operator: %v", e.Op)
 		}
-		
-		switch e.Op {
-		case token.SUB:
-			return -operand, nil
-		case token.ADD:
-			return operand, nil
-		default:
-			return 0, fmt.Errorf("unsupported unary operator: %v", e.Op)
+
+		operand, err := f.compile(e.X)
+		if err != nil {
+			return nil, err
 		}
-	
+
+		return &unaryNode{op: e.Op, x: operand}, nil
+
 	default:
-		return 0, fmt.Errorf("unsupported expression type: %T", expr)
+		return nil, fmt.Errorf("unsupported expression type: %T", expr)
+	}
+}
+
+// compileIf compiles the if(cond, a, b) built-in, which is also the target
+// of the ternary operator cond ? a : b
+func (f *FunctionEvaluator) compileIf(e *ast.CallExpr) (exprNode, error) {
+	if len(e.Args) != 3 {
+		return nil, fmt.Errorf("function if requires exactly three arguments")
 	}
+
+	args := make([]exprNode, len(e.Args))
+	for i, arg := range e.Args {
+		node, err := f.compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = node
+	}
+
+	return &ifNode{cond: args[0], then: args[1], otherwise: args[2]}, nil
 }
 
 // GeneratePointsFromFunction creates a set of 3D points based on the provided function
-// and adds them to the given Space3D instance
-func GeneratePointsFromFunction(space *Space3D, function string, xMin, xMax, yMin, yMax, step float64) error {
+// and adds them to the given Space3D instance as a grid connected by quad faces.
+// params gives the values of any free parameters the function references.
+// Points get exact surface normals when the function can be differentiated.
+// Points where the function can't be evaluated or isn't finite are left out,
+// and the returned report says which and why.
+func GeneratePointsFromFunction(space *Space3D, function string, params []Param, xMin, xMax, yMin, yMax, step float64) (*EvaluationReport, error) {
+	return GeneratePointsFromFunctionContext(context.Background(), space, function, params, xMin, xMax, yMin, yMax, step, nil)
+}
+
+// GeneratePointsFromFunctionContext is like GeneratePointsFromFunction, but
+// stops early with the context's error when ctx is cancelled, leaving space
+// unchanged. Rows of the grid are evaluated concurrently, and progress, if
+// not nil, is called as rows complete.
+func GeneratePointsFromFunctionContext(ctx context.Context, space *Space3D, function string, params []Param, xMin, xMax, yMin, yMax, step float64, progress ProgressFunc) (*EvaluationReport, error) {
+	if err := checkGrid(xMin, xMax, yMin, yMax, step); err != nil {
+		return nil, err
+	}
+
 	// Prepare function for evaluation
-	eval := NewFunctionEvaluator(function)
-	
-	// Generate grid of points
-	for x := xMin; x <= xMax; x += step {
-		for y := yMin; y <= yMax; y += step {
-			// Evaluate function to get z value
-			z, err := eval.Evaluate(x, y)
-			if err != nil {
-				// Skip points where evaluation fails
-				continue
+	eval, err := NewFunctionEvaluator(function, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Normals are optional, so a function without derivatives still plots
+	gradient, _ := NewGradient(function, params...)
+
+	rows, cols := gridSize(xMin, xMax, yMin, yMax, step)
+	normals := make([]Point3D, rows*cols)
+	report, indices, err := sampleGrid(ctx, space, xMin, xMax, yMin, yMax, step, func(i int, x, y float64) (float64, error) {
+		z, err := eval.Evaluate(x, y)
+		if err == nil && gradient != nil {
+			if normal, err := gradient.Normal(x, y); err == nil {
+				normals[i] = normal
 			}
-			
-			// Add point to the space
-			space.AddPoint(NewPoint3D(x, z, y)) // Note: Using z as the y-coordinate for better visualization
+		}
+		return z, err
+	}, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, index := range indices {
+		if index >= 0 && normals[i] != (Point3D{}) {
+			space.SetNormal(index, normals[i])
 		}
 	}
-	
-	return nil
-}
\ No newline at end of file
+
+	return report, nil
+}
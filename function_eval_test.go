@@ -0,0 +1,1037 @@
+package main
+
+import (
+	"context"
+	"image/color"
+	"math"
+	"math/cmplx"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFunctionEvaluator(t *testing.T) {
+	tests := []struct {
+		expr     string
+		x, y     float64
+		expected float64
+	}{
+		{"x + y", 1, 2, 3},
+		{"-x * (y - 1)", 2, 3, -4},
+		{"exp(x)", 0.5, 0, math.Exp(0.5)},
+		{"sin(x) * cos(y)", 1, 2, math.Sin(1) * math.Cos(2)},
+		{"sqrt(x*x + y*y)", 3, 4, 5},
+		{"1e1 / 4", 0, 0, 2.5},
+		{"2*x^2", 3, 0, 18},
+		{"x**y", 2, 3, 8},
+		{"-x^2", 3, 0, -9},
+		{"2^3^2", 0, 0, 512},
+		{"2^-x", 1, 0, 0.5},
+		{"x % 1", 2.25, 0, 0.25},
+		{"x < y && y <= 2 || !x", 1, 2, 1},
+		{"x == y", 1, 2, 0},
+		{"x > 0 ? x : -x", -4, 0, 4},
+		{"if(x*x+y*y < 1, sqrt(1-x*x-y*y), 0)", 2, 0, 0},
+		{"if(x*x+y*y < 1, sqrt(1-x*x-y*y), 0)", 0, 0, 1},
+		{"x != 0 && 1/x > 0", 0, 0, 0},
+		{"atan2(y, x)", 1, 1, math.Pi / 4},
+		{"pow(x, 3) + hypot(3, 4)", 2, 0, 13},
+		{"min(x, y, -1) + max(x, y)", 1, 2, 1},
+		{"clamp(x, 0, 1) + lerp(0, 10, y)", 3, 0.5, 6},
+		{"abs(x) + floor(y) + ceil(y) + sign(-x)", -2, 1.5, 6},
+		{"cosh(x)^2 - sinh(x)^2", 0.7, 0, 1},
+		{"sin(pi/2) + log(e) + tau", 0, 0, 2 + 2*math.Pi},
+	}
+
+	for _, tt := range tests {
+		eval, err := NewFunctionEvaluator(tt.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected parse error: %v", tt.expr, err)
+			continue
+		}
+
+		result, err := eval.Evaluate(tt.x, tt.y)
+		if err != nil {
+			t.Errorf("%q: unexpected evaluation error: %v", tt.expr, err)
+			continue
+		}
+
+		if math.Abs(result-tt.expected) > 1e-10 {
+			t.Errorf("%q at (%v, %v): expected %v, got %v", tt.expr, tt.x, tt.y, tt.expected, result)
+		}
+	}
+}
+
+func TestFunctionEvaluatorParseErrors(t *testing.T) {
+	for _, expr := range []string{"sin(x", "foo(x)", "x + z", "sin(x, y)", "\"x\"", "x ? 1", "if(x, 1)", "x $ y", "(x", "atan2(x)", "min()"} {
+		if _, err := NewFunctionEvaluator(expr); err == nil {
+			t.Errorf("%q: expected parse error", expr)
+		}
+	}
+
+	// Characters outside ASCII are read whole, and positions count characters
+	for expr, message := range map[string]string{
+		"θ × x":    `unexpected character '×' at position 3`,
+		"θ + ٣":    `unexpected character '٣' at position 5`,
+		"θ + \xff": "invalid UTF-8 at position 5",
+	} {
+		if _, err := NewFunctionEvaluator(expr, Param{"θ", 2}); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%q: expected error %q, got %v", expr, message, err)
+		}
+	}
+	eval, err := NewFunctionEvaluator("θ * x", Param{"θ", 2})
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if result, _ := eval.Evaluate(3, 0); result != 6 {
+		t.Errorf("Expected 6, got %v", result)
+	}
+}
+
+func TestFunctionEvaluatorDomainErrors(t *testing.T) {
+	for _, expr := range []string{"1 / x", "sqrt(x - 1)", "log(x)", "x % 0", "x^-1", "(x - 1)^0.5", "asin(x + 2)", "acosh(x)"} {
+		eval, err := NewFunctionEvaluator(expr)
+		if err != nil {
+			t.Fatalf("%q: unexpected parse error: %v", expr, err)
+		}
+		if _, err := eval.Evaluate(0, 0); err == nil {
+			t.Errorf("%q: expected evaluation error at x=0", expr)
+		}
+	}
+}
+
+func TestGeneratePointsFromFunction(t *testing.T) {
+	space := NewSpace3D()
+
+	if _, err := GeneratePointsFromFunction(space, "x + y", nil, 0, 1, 0, 1, 0.5); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(space.Points) != 9 || len(space.Faces) != 4 {
+		t.Errorf("Expected 9 points and 4 faces, got %d and %d", len(space.Points), len(space.Faces))
+	}
+
+	// Points are stored in the function's own coordinates
+	if p := space.Points[5]; p != NewPoint3D(0.5, 1, 1.5) {
+		t.Errorf("Expected point (0.5, 1, 1.5), got %v", p)
+	}
+
+	// Points where the function is undefined are left out with their faces
+	space = NewSpace3D()
+	report, err := GeneratePointsFromFunction(space, "1 / (x - 0.5)", nil, 0, 1, 0, 1, 0.5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 6 || len(space.Faces) != 0 {
+		t.Errorf("Expected 6 points and no faces, got %d and %d", len(space.Points), len(space.Faces))
+	}
+
+	// and reported by kind, with the region they lie in
+	if report.Samples != 9 || report.Failed() != 3 || report.Failures["division by zero"] != 3 {
+		t.Errorf("Expected 3 of 9 samples failing with division by zero, got %d of %d: %v", report.Failed(), report.Samples, report.Failures)
+	}
+	if report.XMin != 0.5 || report.XMax != 0.5 || report.YMin != 0 || report.YMax != 1 {
+		t.Errorf("Expected failures in x=[0.5, 0.5], y=[0, 1], got x=[%v, %v], y=[%v, %v]", report.XMin, report.XMax, report.YMin, report.YMax)
+	}
+
+	// Results that aren't finite count as failures too
+	report, _ = GeneratePointsFromFunction(NewSpace3D(), "sqrt(x) + (y > 0.5 ? exp(1000) : 0)", nil, -1, 1, 0, 1, 0.5)
+	if report.Failures["square root of negative number"] != 6 || report.Failures["result is not finite"] != 3 {
+		t.Errorf("Expected 6 square root and 3 overflow failures, got %v", report.Failures)
+	}
+
+	// Only the first gaps are kept, while all failures are counted
+	report, _ = GeneratePointsFromFunction(NewSpace3D(), "1 / (x - x)", nil, 0, 1, 0, 1, 0.005)
+	if report.Failed() != 201*201 || len(report.Gaps) != reportMaxGaps {
+		t.Errorf("Expected %d failures and %d gaps, got %d and %d", 201*201, reportMaxGaps, report.Failed(), len(report.Gaps))
+	}
+
+	if _, err := GeneratePointsFromFunction(NewSpace3D(), "x +", nil, 0, 1, 0, 1, 0.5); err == nil {
+		t.Errorf("Expected parse error for invalid function")
+	}
+}
+
+// registerTestFunction registers a function for the rest of a test, putting
+// back whatever the name referred to before when the test ends
+func registerTestFunction(t *testing.T, name string, arity int, fn BuiltinFunc) error {
+	t.Helper()
+	registryMu.RLock()
+	previous, existed := functions[name]
+	registryMu.RUnlock()
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		if existed {
+			functions[name] = previous
+		} else {
+			delete(functions, name)
+		}
+	})
+	return RegisterFunction(name, arity, fn)
+}
+
+func TestRegisterFunction(t *testing.T) {
+	err := registerTestFunction(t, "double", 1, func(args []float64) (float64, error) {
+		return 2 * args[0], nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	eval, err := NewFunctionEvaluator("double(x) + y")
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if result, _ := eval.Evaluate(3, 1); result != 7 {
+		t.Errorf("Expected 7, got %v", result)
+	}
+
+	for _, name := range []string{"", "if", "2x", "a b"} {
+		if err := registerTestFunction(t, name, 1, func(args []float64) (float64, error) { return 0, nil }); err == nil {
+			t.Errorf("%q: expected invalid name error", name)
+		}
+	}
+}
+
+func TestNestedCallsConcurrently(t *testing.T) {
+	eval, err := NewFunctionEvaluator("max(pow(x, 2), min(y, pow(y, 3)), atan2(x, y))")
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+
+	// Arguments of nested calls, and of calls on other goroutines, are kept apart
+	results := make([]float64, 8)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				results[i], _ = eval.Evaluate(float64(i), -2)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		x := float64(i)
+		if expected := math.Max(x*x, math.Atan2(x, -2)); result != expected {
+			t.Errorf("x = %v: expected %v, got %v", x, expected, result)
+		}
+	}
+}
+
+func TestFunctionParams(t *testing.T) {
+	params, err := ParseParams("a=2, b=0.5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(params) != 2 || params[0] != (Param{"a", 2}) || params[1] != (Param{"b", 0.5}) {
+		t.Fatalf("Params not parsed correctly, got %v", params)
+	}
+
+	eval, err := NewFunctionEvaluator("a*sin(b*x) + y", params...)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if result, _ := eval.Evaluate(math.Pi, 1); math.Abs(result-3) > 1e-10 {
+		t.Errorf("Expected 3, got %v", result)
+	}
+
+	if err := eval.SetParam("a", -1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result, _ := eval.Evaluate(math.Pi, 1); math.Abs(result) > 1e-10 {
+		t.Errorf("Expected 0 after SetParam, got %v", result)
+	}
+	if err := eval.SetParam("c", 1); err == nil {
+		t.Errorf("Expected error setting unknown parameter")
+	}
+
+	for _, s := range []string{"a", "a=x", "1a=1", "a=1,a=2"} {
+		if _, err := ParseParams(s); err == nil {
+			t.Errorf("%q: expected parse error", s)
+		}
+	}
+
+	if _, err := NewFunctionEvaluator("x", Param{"x", 1}); err == nil {
+		t.Errorf("Expected error for parameter named like a variable")
+	}
+}
+
+func TestFreeParams(t *testing.T) {
+	names, err := FreeParams("a*sin(b*x) + pi*a + y", "x", "y")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Expected [a b], got %v", names)
+	}
+}
+
+func TestSplitExpressions(t *testing.T) {
+	exprs, err := SplitExpressions("cos(t), atan2(t, 1),t/3", 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exprs[0] != "cos(t)" || exprs[1] != "atan2(t, 1)" || exprs[2] != "t/3" {
+		t.Errorf("Expressions not split correctly, got %q", exprs)
+	}
+
+	for _, s := range []string{"cos(t),sin(t)", "cos(t),,t"} {
+		if _, err := SplitExpressions(s, 3); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestGeneratePointsFromCurve(t *testing.T) {
+	space := NewSpace3D()
+
+	if _, err := GeneratePointsFromCurve(space, "cos(t)", "sin(t)", "a*t", []Param{{"a", 2}}, 0, math.Pi, 5); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(space.Points) != 5 || len(space.Polylines) != 1 || len(space.Polylines[0]) != 5 {
+		t.Fatalf("Expected one polyline of 5 points, got %d points and %v", len(space.Points), space.Polylines)
+	}
+
+	last := space.Points[4]
+	if math.Abs(last.X+1) > 1e-10 || math.Abs(last.Y) > 1e-10 || math.Abs(last.Z-2*math.Pi) > 1e-10 {
+		t.Errorf("Last point incorrect, got %v", last)
+	}
+
+	// The curve is broken where it can't be evaluated
+	space = NewSpace3D()
+	report, err := GeneratePointsFromCurve(space, "t", "sqrt(1 - t*t)", "0", nil, -2, 2, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Polylines) != 1 || len(space.Points) != 5 {
+		t.Errorf("Expected 5 points in one polyline, got %d points and %v", len(space.Points), space.Polylines)
+	}
+	if report.Samples != 9 || report.Failed() != 4 || report.XMin != -2 || report.XMax != 2 {
+		t.Errorf("Expected 4 of 9 samples failing in t=[-2, 2], got %s", report)
+	}
+
+	// Samples that aren't finite break the curve too
+	space = NewSpace3D()
+	report, err = GeneratePointsFromCurve(space, "t", "exp(t*t)", "0", nil, -30, 30, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 7 || report.Failures["result is not finite"] != 2 {
+		t.Errorf("Expected 7 points and 2 infinite samples, got %d points and %s", len(space.Points), report)
+	}
+	for _, p := range space.Points {
+		if math.IsInf(p.Y, 0) {
+			t.Errorf("Unexpected infinite point %v", p)
+		}
+	}
+
+	if _, err := GeneratePointsFromCurve(NewSpace3D(), "x", "t", "t", nil, 0, 1, 10); err == nil {
+		t.Errorf("Expected error for curve in x")
+	}
+}
+
+func TestGeneratePointsFromSurface(t *testing.T) {
+	space := NewSpace3D()
+
+	_, err := GeneratePointsFromSurface(space, "r*cos(u)*sin(v)", "r*sin(u)*sin(v)", "r*cos(v)", []Param{{"r", 2}}, 0, 2*math.Pi, 0, math.Pi, 9, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(space.Points) != 45 || len(space.Faces) != 32 {
+		t.Fatalf("Expected 45 points and 32 faces, got %d and %d", len(space.Points), len(space.Faces))
+	}
+
+	for _, p := range space.Points {
+		if r := p.DistanceTo(NewPoint3D(0, 0, 0)); math.Abs(r-2) > 1e-10 {
+			t.Errorf("Point %v not on sphere of radius 2", p)
+		}
+	}
+
+	// A sample that isn't finite is left out with the faces touching it
+	space = NewSpace3D()
+	report, err := GeneratePointsFromSurface(space, "u", "v", "exp(u*v)", nil, 0, 30, 0, 30, 3, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 8 || len(space.Faces) != 3 {
+		t.Errorf("Expected 8 points and 3 faces, got %d and %d", len(space.Points), len(space.Faces))
+	}
+	if report.Failed() != 1 || report.Gaps[0] != NewPoint3D(30, 30, 0) || !strings.Contains(report.String(), "u=[30.00, 30.00], v=[30.00, 30.00]") {
+		t.Errorf("Expected the sample at u = v = 30 to fail, got %s", report)
+	}
+
+	if _, err := GeneratePointsFromSurface(NewSpace3D(), "u", "v", "t", nil, 0, 1, 0, 1, 5, 5); err == nil {
+		t.Errorf("Expected error for surface in t")
+	}
+}
+
+func TestEvaluateConstant(t *testing.T) {
+	value, err := EvaluateConstant("2*pi")
+	if err != nil || math.Abs(value-2*math.Pi) > 1e-10 {
+		t.Errorf("Expected 2*pi, got %v (%v)", value, err)
+	}
+	if _, err := EvaluateConstant("2*x"); err == nil {
+		t.Errorf("Expected error for expression with a variable")
+	}
+}
+
+func TestGeneratePointsFromImplicit(t *testing.T) {
+	for _, function := range []string{
+		"x^2 + y^2 + z^2 - r^2",
+		"(sqrt(x^2 + y^2) - r)^2 + z^2 - 0.2",
+	} {
+		space := NewSpace3D()
+
+		err := GeneratePointsFromImplicit(space, function, []Param{{"r", 1}}, -1.7, 1.7, -1.7, 1.7, -1.7, 1.7, 19, 0)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", function, err)
+		}
+
+		if len(space.Faces) == 0 {
+			t.Fatalf("%q: expected a mesh, got no faces", function)
+		}
+
+		// The surface is closed, so every edge is used once in each direction
+		edges := make(map[[2]int]int)
+		for _, face := range space.Faces {
+			if len(face) != 3 {
+				t.Fatalf("%q: expected triangles, got face %v", function, face)
+			}
+			for i := range face {
+				edges[[2]int{face[i], face[(i+1)%3]}]++
+			}
+		}
+		for edge, count := range edges {
+			if count != 1 || edges[[2]int{edge[1], edge[0]}] != 1 {
+				t.Fatalf("%q: mesh is not closed and consistently oriented at edge %v", function, edge)
+			}
+		}
+	}
+
+	// Normals of the sphere point away from the centre, towards increasing f
+	space := NewSpace3D()
+	if err := GeneratePointsFromImplicit(space, "x^2 + y^2 + z^2 - 1", nil, -1.5, 1.5, -1.5, 1.5, -1.5, 1.5, 16, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, face := range space.Faces {
+		a, b, c := space.Points[face[0]], space.Points[face[1]], space.Points[face[2]]
+		nx := (b.Y-a.Y)*(c.Z-a.Z) - (b.Z-a.Z)*(c.Y-a.Y)
+		ny := (b.Z-a.Z)*(c.X-a.X) - (b.X-a.X)*(c.Z-a.Z)
+		nz := (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+		if nx*(a.X+b.X+c.X)+ny*(a.Y+b.Y+c.Y)+nz*(a.Z+b.Z+c.Z) < 0 {
+			t.Errorf("Face %v points inwards", face)
+		}
+	}
+	for _, p := range space.Points {
+		if r := p.DistanceTo(NewPoint3D(0, 0, 0)); math.Abs(r-1) > 0.05 {
+			t.Errorf("Vertex %v not near the unit sphere", p)
+		}
+	}
+
+	for _, resolution := range []int{1, maxImplicitResolution + 1} {
+		if err := GeneratePointsFromImplicit(NewSpace3D(), "x", nil, -1, 1, -1, 1, -1, 1, resolution, 0); err == nil {
+			t.Errorf("Resolution %d: expected an error", resolution)
+		}
+	}
+}
+
+func TestMarchingCubesCases(t *testing.T) {
+	// Each case's triangles use exactly the edges between inside and outside corners
+	for c := 0; c < 256; c++ {
+		used := make(map[int]bool)
+		for _, tri := range marchingCubesCases[c] {
+			for _, e := range tri {
+				used[e] = true
+			}
+		}
+
+		for e, corners := range cubeEdges {
+			crossed := (c>>corners[0])&1 != (c>>corners[1])&1
+			if used[e] != crossed {
+				t.Errorf("Case %d: edge %d crossed=%v but used=%v", c, e, crossed, used[e])
+			}
+		}
+	}
+}
+
+func TestGenerateAdaptivePointsFromFunction(t *testing.T) {
+	// A flat function needs no refinement
+	space := NewSpace3D()
+	if _, err := GenerateAdaptivePointsFromFunction(space, "x + y", nil, 0, 2, 0, 2, 1, 0.01, 1000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 9 || len(space.Faces) != 4 {
+		t.Errorf("Expected 9 points and 4 faces for a plane, got %d and %d", len(space.Points), len(space.Faces))
+	}
+
+	// A sharp peak is refined near the peak, within the point budget
+	space = NewSpace3D()
+	if _, err := GenerateAdaptivePointsFromFunction(space, "exp(-20*(x*x + y*y))", nil, -2, 2, -2, 2, 0.5, 0.01, 500); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) <= 81 || len(space.Points) > 500 {
+		t.Errorf("Expected between 81 and 500 points, got %d", len(space.Points))
+	}
+
+	// The faces tile the domain without cracks: interior edges are shared
+	// by two faces and the boundary edges run around the square
+	edges := make(map[[2]int]int)
+	for _, face := range space.Faces {
+		for i := range face {
+			edges[[2]int{face[i], face[(i+1)%len(face)]}]++
+		}
+	}
+	for edge := range edges {
+		if edges[[2]int{edge[1], edge[0]}] == 1 {
+			continue
+		}
+		a, b := space.Points[edge[0]], space.Points[edge[1]]
+		onBoundary := func(p Point3D) bool { return math.Abs(p.X) == 2 || math.Abs(p.Y) == 2 }
+		if !onBoundary(a) || !onBoundary(b) {
+			t.Fatalf("Edge from %v to %v has no neighbouring face", a, b)
+		}
+	}
+
+	if _, err := GenerateAdaptivePointsFromFunction(NewSpace3D(), "x", nil, 0, 1, 0, 1, 0.5, 0, 100); err == nil {
+		t.Errorf("Expected error for zero tolerance")
+	}
+}
+
+func TestDerivative(t *testing.T) {
+	exprs := []string{
+		"x^2 + 3*x*y",
+		"sin(x) * cos(y)",
+		"a * x^3 - x^-1",
+		"exp(-(x*x + y*y))",
+		"sqrt(x*x + y*y)",
+		"log(x) / x + log2(x) + log10(x)",
+		"x^y",
+		"2^x + pow(x, y)",
+		"tan(x) + atan(x) + asin(x/3) + acos(x/3)",
+		"sinh(x) + cosh(x) + tanh(x) + asinh(x) + atanh(x/3)",
+		"cbrt(x) + hypot(x, y) + atan2(y, x)",
+		"abs(x - 3) + floor(x) + x % 1.5",
+		"min(x, y, 1) + max(x*x, y) + clamp(x*y, 0, 1) + lerp(x, y, x)",
+		"x > 1 ? x^2 : -x",
+	}
+
+	for _, expr := range exprs {
+		eval, err := NewFunctionEvaluator(expr, Param{Name: "a", Value: 2})
+		if err != nil {
+			t.Fatalf("%q: unexpected parse error: %v", expr, err)
+		}
+
+		for i, variable := range []string{"x", "y"} {
+			d, err := eval.Derivative(variable)
+			if err != nil {
+				t.Fatalf("%q: unexpected error differentiating by %s: %v", expr, variable, err)
+			}
+
+			// The printed derivative parses back to the same function
+			reparsed, err := NewFunctionEvaluator(d.Expression(), Param{Name: "a", Value: 2})
+			if err != nil {
+				t.Fatalf("%q: derivative %q doesn't parse: %v", expr, d.Expression(), err)
+			}
+
+			// Compare with a central difference away from any kinks
+			x, y := 1.3, 0.7
+			h := 1e-6
+			at := func(offset float64) float64 {
+				values := []float64{x, y}
+				values[i] += offset
+				value, err := eval.Evaluate(values[0], values[1])
+				if err != nil {
+					t.Fatalf("%q: unexpected evaluation error: %v", expr, err)
+				}
+				return value
+			}
+			expected := (at(h) - at(-h)) / (2 * h)
+
+			for _, e := range []*FunctionEvaluator{d, reparsed} {
+				result, err := e.Evaluate(x, y)
+				if err != nil {
+					t.Fatalf("%q: unexpected evaluation error in %q: %v", expr, e.Expression(), err)
+				}
+				if math.Abs(result-expected) > 1e-5*math.Max(1, math.Abs(expected)) {
+					t.Errorf("d(%s)/d%s = %s: expected %v, got %v", expr, variable, e.Expression(), expected, result)
+				}
+			}
+		}
+	}
+}
+
+func TestDerivativeSimplification(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{"x^2 + y^2", "2 * x"},
+		{"sin(x) * cos(y)", "cos(x) * cos(y)"},
+		{"3*x - y + 7", "3"},
+		{"a * x^3", "a * (3 * x^2)"},
+		{"1/x", "-1 / x^2"},
+		{"x > 0 ? x^2 : y", "if(x > 0, 2 * x, 0)"},
+	}
+
+	for _, tt := range tests {
+		eval, err := NewFunctionEvaluator(tt.expr, Param{Name: "a", Value: 1})
+		if err != nil {
+			t.Fatalf("%q: unexpected parse error: %v", tt.expr, err)
+		}
+		d, err := eval.Derivative("x")
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.expr, err)
+		}
+		if d.Expression() != tt.expected {
+			t.Errorf("d(%s)/dx: expected %q, got %q", tt.expr, tt.expected, d.Expression())
+		}
+	}
+
+	// Parameters are shared with the derivative
+	eval, _ := NewFunctionEvaluator("a*x", Param{Name: "a", Value: 1})
+	d, _ := eval.Derivative("x")
+	eval.SetParam("a", 5)
+	if result, _ := d.Evaluate(0, 0); result != 5 {
+		t.Errorf("Expected derivative 5 after changing a, got %v", result)
+	}
+
+	if _, err := eval.Derivative("z"); err == nil {
+		t.Errorf("Expected error for unknown variable")
+	}
+
+	// Registered functions have no derivative rule
+	if err := registerTestFunction(t, "triple", 1, func(args []float64) (float64, error) { return 3 * args[0], nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eval, _ = NewFunctionEvaluator("triple(x)")
+	if _, err := eval.Derivative("x"); err == nil {
+		t.Errorf("Expected error differentiating a registered function")
+	}
+}
+
+func TestGradient(t *testing.T) {
+	g, err := NewGradient("x^2 + 2*y")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dx, dy, err := g.Evaluate(1, 5)
+	if err != nil || dx != 2 || dy != 2 {
+		t.Errorf("Expected gradient (2, 2), got (%v, %v), %v", dx, dy, err)
+	}
+	if m, _ := g.Magnitude(1, 5); math.Abs(m-math.Sqrt(8)) > 1e-12 {
+		t.Errorf("Expected magnitude %v, got %v", math.Sqrt(8), m)
+	}
+
+	normal, _ := g.Normal(1, 5)
+	if math.Abs(normal.X+2/3.0) > 1e-12 || math.Abs(normal.Y+2/3.0) > 1e-12 || math.Abs(normal.Z-1/3.0) > 1e-12 {
+		t.Errorf("Expected normal (-2/3, -2/3, 1/3), got %v", normal)
+	}
+
+	// Generated surfaces carry the exact normals of their points
+	space := NewSpace3D()
+	if _, err := GeneratePointsFromFunction(space, "x^2 + 2*y", nil, 0, 1, 0, 1, 0.5); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, p := range space.Points {
+		n, ok := space.Normal(i)
+		expected, _ := g.Normal(p.X, p.Y)
+		if !ok || math.Abs(n.X-expected.X) > 1e-12 || math.Abs(n.Y-expected.Y) > 1e-12 {
+			t.Errorf("Point %v: expected normal %v, got %v", p, expected, n)
+		}
+	}
+}
+
+func TestComplexFunctionEvaluator(t *testing.T) {
+	tests := []struct {
+		expr     string
+		z        complex128
+		expected complex128
+	}{
+		{"z^2 + 1", 1i, 0},
+		{"1/z", 2i, -0.5i},
+		{"exp(i*pi)", 0, -1},
+		{"(z - i)*(z + i)", 2, 5},
+		{"abs(z) + re(z) + im(z)", 3 + 4i, 12},
+		{"conj(z) * z", 1 + 1i, 2},
+		{"arg(z)", -1, complex(math.Pi, 0)},
+		{"sqrt(z)", -4, 2i},
+		{"a*z", 1i, 2i},
+		{"z == i ? 1 : 0", 1i, 1},
+	}
+
+	for _, tt := range tests {
+		eval, err := NewComplexFunctionEvaluator(tt.expr, Param{Name: "a", Value: 2})
+		if err != nil {
+			t.Errorf("%q: unexpected parse error: %v", tt.expr, err)
+			continue
+		}
+
+		result, err := eval.EvaluateComplex(tt.z)
+		if err != nil {
+			t.Errorf("%q: unexpected evaluation error: %v", tt.expr, err)
+			continue
+		}
+		if cmplx.Abs(result-tt.expected) > 1e-10 {
+			t.Errorf("%q at %v: expected %v, got %v", tt.expr, tt.z, tt.expected, result)
+		}
+	}
+
+	// Poles and operations without a complex meaning fail
+	for _, tt := range []struct {
+		expr string
+		z    complex128
+	}{
+		{"1/z", 0},
+		{"log(z)", 0},
+		{"z < 1", 1i},
+	} {
+		eval, err := NewComplexFunctionEvaluator(tt.expr)
+		if err != nil {
+			t.Fatalf("%q: unexpected parse error: %v", tt.expr, err)
+		}
+		if _, err := eval.EvaluateComplex(tt.z); err == nil {
+			t.Errorf("%q: expected error at %v", tt.expr, tt.z)
+		}
+	}
+	if _, err := NewComplexFunctionEvaluator("floor(z)"); err == nil {
+		t.Errorf("Expected error for function without a complex implementation")
+	}
+
+	// i is only the imaginary unit in complex expressions
+	if names, _ := FreeParams("i*z + b", "z", "i"); len(names) != 1 || names[0] != "b" {
+		t.Errorf("Expected free parameter b, got %v", names)
+	}
+	if _, err := NewFunctionEvaluator("i*x"); err == nil {
+		t.Errorf("Expected error for i in a real expression")
+	}
+}
+
+func TestGeneratePointsFromComplexFunction(t *testing.T) {
+	space := NewSpace3D()
+	report, err := GeneratePointsFromComplexFunction(space, "1/z", nil, -1, 1, -1, 1, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The pole at the origin is left out
+	if len(space.Points) != 8 || report.Failed() != 1 {
+		t.Fatalf("Expected 8 points and 1 failure, got %d and %d", len(space.Points), report.Failed())
+	}
+
+	for i, p := range space.Points {
+		if expected := 1 / math.Hypot(p.X, p.Y); math.Abs(p.Z-expected) > 1e-12 {
+			t.Errorf("Point %v: expected height %v", p, expected)
+		}
+
+		// 1/z is real and positive at z = 1, real and negative at z = -1
+		c, ok := space.Color(i)
+		switch {
+		case !ok:
+			t.Errorf("Point %v has no color", p)
+		case p.X == 1 && p.Y == 0 && c != (color.RGBA{255, 0, 0, 255}):
+			t.Errorf("Expected red at z = 1, got %v", c)
+		case p.X == -1 && p.Y == 0 && c != (color.RGBA{0, 255, 255, 255}):
+			t.Errorf("Expected cyan at z = -1, got %v", c)
+		}
+	}
+}
+
+func TestGeneratePointsFromFunctionContext(t *testing.T) {
+	// Points come out in grid order however the rows are scheduled
+	space := NewSpace3D()
+	var calls, last int
+	report, err := GeneratePointsFromFunctionContext(context.Background(), space, "sqrt(x)*y", nil, -1, 2, -1, 1, 0.25, func(done, total int) {
+		calls++
+		if done != last+1 || total != 13 {
+			t.Errorf("Unexpected progress %d of %d after %d", done, total, last)
+		}
+		last = done
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 13 || last != 13 {
+		t.Errorf("Expected progress for 13 rows, got %d calls ending at %d", calls, last)
+	}
+	if report.Samples != 13*9 || report.Failed() != 4*9 {
+		t.Errorf("Expected 4 of 13 rows to fail, got %s", report)
+	}
+
+	expected := 0
+	for i := 0; i < 13; i++ {
+		x := -1 + float64(i)*0.25
+		for j := 0; j < 9; j++ {
+			y := -1 + float64(j)*0.25
+			if x < 0 {
+				continue
+			}
+			p := space.Points[expected]
+			if p.X != x || p.Y != y || math.Abs(p.Z-math.Sqrt(x)*y) > 1e-12 {
+				t.Fatalf("Point %d: expected (%v, %v), got %v", expected, x, y, p)
+			}
+			expected++
+		}
+	}
+	if len(space.Points) != expected {
+		t.Errorf("Expected %d points, got %d", expected, len(space.Points))
+	}
+
+	// A cancelled context stops sampling and leaves the space alone
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	space = NewSpace3D()
+	if _, err := GeneratePointsFromFunctionContext(ctx, space, "x*y", nil, -3, 3, -3, 3, 0.01, nil); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if len(space.Points) != 0 || len(space.Faces) != 0 {
+		t.Errorf("Expected no points after cancelling, got %d", len(space.Points))
+	}
+}
+
+func TestGenerateAdaptivePointsFromFunctionContext(t *testing.T) {
+	// Progress counts points placed out of the budget and ends full
+	space := NewSpace3D()
+	var last int
+	_, err := GenerateAdaptivePointsFromFunctionContext(context.Background(), space, "sin(3*x)*cos(3*y)", nil, -1, 1, -1, 1, 0.5, 0.001, 200, func(done, total int) {
+		if done < last || done > total || total != 200 {
+			t.Errorf("Unexpected progress %d of %d after %d", done, total, last)
+		}
+		last = done
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last != 200 {
+		t.Errorf("Expected progress to end at 200, got %d", last)
+	}
+	if len(space.Points) == 0 || len(space.Points) > 200 {
+		t.Errorf("Expected up to 200 points, got %d", len(space.Points))
+	}
+
+	// A cancelled context stops sampling and leaves the space alone
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	space = NewSpace3D()
+	if _, err := GenerateAdaptivePointsFromFunctionContext(ctx, space, "x*y", nil, -3, 3, -3, 3, 0.1, 0.01, 0, nil); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if len(space.Points) != 0 || len(space.Faces) != 0 {
+		t.Errorf("Expected no points after cancelling, got %d", len(space.Points))
+	}
+}
+
+func TestGenerateFunctionGridErrors(t *testing.T) {
+	cases := []struct {
+		name                         string
+		xMin, xMax, yMin, yMax, step float64
+	}{
+		{"reversed x", 2, -2, -2, 2, 0.5},
+		{"reversed y", -2, 2, 2, -2, 0.5},
+		{"zero step", -2, 2, -2, 2, 0},
+		{"negative step", -2, 2, -2, 2, -0.5},
+		{"NaN step", -2, 2, -2, 2, math.NaN()},
+		{"tiny step", -3, 3, -3, 3, 1e-9},
+		{"infinite range", math.Inf(-1), math.Inf(1), -2, 2, 0.5},
+	}
+	for _, c := range cases {
+		space := NewSpace3D()
+		if _, err := GeneratePointsFromFunction(space, "x*y", nil, c.xMin, c.xMax, c.yMin, c.yMax, c.step); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if _, err := GeneratePointsFromComplexFunction(space, "z", nil, c.xMin, c.xMax, c.yMin, c.yMax, c.step); err == nil {
+			t.Errorf("%s: expected an error for the complex function", c.name)
+		}
+		if _, err := GenerateAdaptivePointsFromFunction(space, "x*y", nil, c.xMin, c.xMax, c.yMin, c.yMax, c.step, 0.01, 1000); err == nil {
+			t.Errorf("%s: expected an error for adaptive sampling", c.name)
+		}
+		if len(space.Points) != 0 {
+			t.Errorf("%s: expected no points, got %d", c.name, len(space.Points))
+		}
+	}
+
+	// An empty range still gives a row of points
+	space := NewSpace3D()
+	if _, err := GeneratePointsFromFunction(space, "x*y", nil, 1, 1, -1, 1, 0.5); err != nil || len(space.Points) != 5 {
+		t.Errorf("Expected 5 points for a single row, got %d and %v", len(space.Points), err)
+	}
+}
+
+func TestGenerateVectorField(t *testing.T) {
+	space := NewSpace3D()
+	if _, err := GenerateVectorField(space, "-y", "x", "a", []Param{{"a", 0}}, -1, 1, -1, 1, 0, 1, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 18 {
+		t.Fatalf("Expected 18 points, got %d", len(space.Points))
+	}
+
+	for i, p := range space.Points {
+		v, ok := space.Vector(i)
+		switch {
+		case p.X == 0 && p.Y == 0:
+			if ok {
+				t.Errorf("Point %v: expected no vector on the axis, got %v", p, v)
+			}
+		case !ok || v != NewPoint3D(-p.Y, p.X, 0):
+			t.Errorf("Point %v: expected vector (%v, %v, 0), got %v", p, -p.Y, p.X, v)
+		}
+
+		// Corners have the longest vectors and are red, the axis is blue
+		c, _ := space.Color(i)
+		if math.Abs(p.X) == 1 && math.Abs(p.Y) == 1 && c != (color.RGBA{255, 0, 0, 255}) {
+			t.Errorf("Point %v: expected red, got %v", p, c)
+		}
+		if p.X == 0 && p.Y == 0 && c != (color.RGBA{0, 0, 255, 255}) {
+			t.Errorf("Point %v: expected blue, got %v", p, c)
+		}
+	}
+
+	// Positions where the field is undefined are left out and reported
+	space = NewSpace3D()
+	report, err := GenerateVectorField(space, "1/x", "0", "0", nil, -1, 1, 0, 0, 0, 0, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 2 {
+		t.Errorf("Expected 2 points, got %d", len(space.Points))
+	}
+	if report.Samples != 3 || report.Failed() != 1 || report.Gaps[0] != NewPoint3D(0, 0, 0) {
+		t.Errorf("Expected the sample at the origin to fail, got %s", report)
+	}
+	if !strings.Contains(report.String(), "z=[0.00, 0.00]") {
+		t.Errorf("Expected the report to give the z range, got %s", report)
+	}
+
+	if _, err := GenerateVectorField(NewSpace3D(), "t", "0", "0", nil, -1, 1, -1, 1, -1, 1, 1); err == nil {
+		t.Errorf("Expected error for vector field in t")
+	}
+	for _, bounds := range [][7]float64{
+		{1, -1, -1, 1, -1, 1, 0.5},
+		{-1, 1, -1, 1, 1, -1, 0.5},
+		{-1, 1, -1, 1, -1, 1, 0},
+		{-1, 1, -1, 1, -1, 1, math.NaN()},
+		{-1, 1, -1, 1, -1, 1, 1e-3},
+	} {
+		if _, err := GenerateVectorField(NewSpace3D(), "x", "y", "z", nil, bounds[0], bounds[1], bounds[2], bounds[3], bounds[4], bounds[5], bounds[6]); err == nil {
+			t.Errorf("%v: expected an error", bounds)
+		}
+	}
+}
+
+func TestGenerateStreamlines(t *testing.T) {
+	// Streamlines of a rotation are circles around the z axis
+	space := NewSpace3D()
+	seeds := []Point3D{NewPoint3D(1, 0, 0), NewPoint3D(0, 2, 1), NewPoint3D(5, 0, 0)}
+	err := GenerateStreamlines(space, "-y", "x", "0", nil, seeds, -3, 3, -3, 3, -3, 3, 0.1, 1e-6, 2*math.Pi)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The seed outside the bounds is left out
+	if len(space.Polylines) != 2 {
+		t.Fatalf("Expected 2 streamlines, got %d", len(space.Polylines))
+	}
+	for i, line := range space.Polylines {
+		seed := seeds[i]
+		for _, index := range line {
+			p := space.Points[index]
+			if r := math.Hypot(p.X, p.Y); math.Abs(r-math.Hypot(seed.X, seed.Y)) > 1e-4 || p.Z != seed.Z {
+				t.Fatalf("Streamline %d: point %v is off the circle", i, p)
+			}
+		}
+	}
+
+	// A full turn of the unit circle ends back at its seed
+	last := space.Points[space.Polylines[0][len(space.Polylines[0])-1]]
+	if Distance(last, seeds[0]) > 1e-3 {
+		t.Errorf("Expected the unit circle to close, ended at %v", last)
+	}
+
+	// Lines stop where the field vanishes
+	space = NewSpace3D()
+	if err := GenerateStreamlines(space, "-x", "-y", "-z", nil, GridSeeds(1, 1, 0, 0, 0, 0, 1), -3, 3, -3, 3, -3, 3, 0.1, 1e-6, 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Polylines) != 1 {
+		t.Fatalf("Expected 1 streamline, got %d", len(space.Polylines))
+	}
+	last = space.Points[len(space.Points)-1]
+	if last.X < 0 || last.X > 0.01 {
+		t.Errorf("Expected the streamline to end at the sink, ended at %v", last)
+	}
+
+	if err := GenerateStreamlines(NewSpace3D(), "1", "0", "0", nil, seeds, -3, 3, -3, 3, -3, 3, 0.1, 0, 10); err == nil {
+		t.Errorf("Expected error for zero tolerance")
+	}
+}
+
+func TestODESystem(t *testing.T) {
+	// Decay at rates 1 and a, and growth with t, all solved exactly
+	system, err := NewODESystem("-x", "t", "-a*z", Param{"a", 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exact := NewPoint3D(math.Exp(-1), 0.5, math.Exp(-2))
+
+	tests := []struct {
+		method ODEMethod
+		dt     float64
+		within float64
+	}{
+		{ODEEuler, 0.001, 1e-3},
+		{ODERK4, 0.01, 1e-8},
+		{ODERK45, 0.1, 1e-5},
+	}
+	for _, test := range tests {
+		points, times, err := system.Solve(test.method, NewPoint3D(1, 0, 1), 0, 1, test.dt, 1e-8)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", test.method, err)
+		}
+		if len(points) != len(times) || times[0] != 0 || times[len(times)-1] != 1 {
+			t.Fatalf("%v: expected times from 0 to 1 for each point, got %d points and %d times", test.method, len(points), len(times))
+		}
+		if last := points[len(points)-1]; Distance(last, exact) > test.within {
+			t.Errorf("%v: expected %v, got %v", test.method, exact, last)
+		}
+	}
+
+	// Adaptive steps take far fewer points than fixed steps of the same accuracy
+	points, _, _ := system.Solve(ODERK45, NewPoint3D(1, 0, 1), 0, 1, 0.1, 1e-8)
+	if len(points) > 50 {
+		t.Errorf("Expected few adaptive steps, got %d", len(points))
+	}
+
+	// Solutions that blow up stop with the trajectory so far
+	space := NewSpace3D()
+	err = GeneratePointsFromODE(space, "x^2", "0", "0", nil, NewPoint3D(1, 0, 0), ODERK4, 0, 2, 0.01, 0)
+	if err == nil {
+		t.Errorf("Expected error for solution blowing up at t = 1")
+	}
+	if len(space.Polylines) != 1 || len(space.Points) < 50 || len(space.Points) > 150 {
+		t.Errorf("Expected the trajectory up to about t = 1, got %d points", len(space.Points))
+	}
+
+	// Too many steps stop at the point limit, saying how far they got
+	points, times, err := system.Solve(ODEEuler, NewPoint3D(1, 0, 1), 0, 2, 1e-6, 0)
+	if err == nil || !strings.Contains(err.Error(), "t = 0.99") {
+		t.Errorf("Expected an error at t = 0.99..., got %v", err)
+	}
+	if len(points) != odeMaxPoints || times[len(times)-1] >= 1 {
+		t.Errorf("Expected %d points before t = 1, got %d", odeMaxPoints, len(points))
+	}
+
+	if _, err := ParseODEMethod("RK4"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := ParseODEMethod("leapfrog"); err == nil {
+		t.Errorf("Expected error for unknown method")
+	}
+	if p, err := ParsePoint("1, pi/2, -1"); err != nil || p != NewPoint3D(1, math.Pi/2, -1) {
+		t.Errorf("Expected (1, pi/2, -1), got %v, %v", p, err)
+	}
+}
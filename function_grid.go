@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// ProgressFunc is told how many of the total units of work are done
+type ProgressFunc func(done, total int)
+
+// axisSamples returns the number of samples from min to max for a step,
+// allowing for rounding in the range divided by the step
+func axisSamples(min, max, step float64) int {
+	return int(math.Floor((max-min)/step+1e-9)) + 1
+}
+
+// maxGridSamples is the largest number of samples a grid may have, as each
+// is allocated for up front
+const maxGridSamples = 1 << 24
+
+// checkGrid reports an error unless step is positive, neither range is
+// reversed and the grid has no more than maxGridSamples samples, as a grid
+// can't be sampled otherwise
+func checkGrid(xMin, xMax, yMin, yMax, step float64) error {
+	if !(step > 0) {
+		return fmt.Errorf("step size must be positive")
+	}
+	if !(xMin <= xMax) || !(yMin <= yMax) {
+		return fmt.Errorf("min values must not be greater than max values")
+	}
+	// Counted in floats, which a tiny step can't overflow
+	if samples := ((xMax-xMin)/step + 1) * ((yMax-yMin)/step + 1); !(samples <= maxGridSamples) {
+		return fmt.Errorf("step size %g is too small: the grid would have more than %d samples", step, maxGridSamples)
+	}
+	return nil
+}
+
+// gridSize returns the number of grid rows along x and columns along y for a step
+func gridSize(xMin, xMax, yMin, yMax, step float64) (int, int) {
+	return axisSamples(xMin, xMax, step), axisSamples(yMin, yMax, step)
+}
+
+// sampleGrid evaluates height on a grid over [xMin, xMax] x [yMin, yMax] and
+// adds the samples to the space as a grid connected by quad faces. height is
+// also given the sample's position in the grid, in row-major order, so callers
+// can keep more per-sample data.
+//
+// Rows are evaluated on a pool of workers, one per CPU, so height must be safe
+// for concurrent use. The result doesn't depend on the order rows finish in.
+// progress, if not nil, is called on the calling goroutine each time a row is
+// done. When ctx is cancelled, sampling stops and the context's error is
+// returned without changing the space.
+//
+// sampleGrid returns the report of failed samples and the index in
+// space.Points of each grid sample, or -1 for samples that failed.
+func sampleGrid(ctx context.Context, space *Space3D, xMin, xMax, yMin, yMax, step float64, height func(i int, x, y float64) (float64, error), progress ProgressFunc) (*EvaluationReport, []int, error) {
+	rows, cols := gridSize(xMin, xMax, yMin, yMax, step)
+	heights := make([]float64, rows*cols)
+	errs := make([]error, rows*cols)
+
+	// Hand out rows until they run out or sampling is cancelled
+	todo := make(chan int)
+	go func() {
+		defer close(todo)
+		for i := 0; i < rows; i++ {
+			select {
+			case todo <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > rows {
+		workers = rows
+	}
+	finished := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				x := xMin + float64(i)*step
+				for j := 0; j < cols; j++ {
+					y := yMin + float64(j)*step
+					k := i*cols + j
+					heights[k], errs[k] = checkValue(height(k, x, y))
+				}
+				finished <- i
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	done := 0
+	for range finished {
+		done++
+		if progress != nil {
+			progress(done, rows)
+		}
+	}
+	if done < rows {
+		return nil, nil, ctx.Err()
+	}
+
+	// Collect the grid in order, keeping it so neighbours can be connected
+	report := newEvaluationReport()
+	points := make([]Point3D, 0, rows*cols)
+	valid := make([]bool, 0, rows*cols)
+	indices := make([]int, 0, rows*cols)
+	next := len(space.Points)
+	for i := 0; i < rows; i++ {
+		x := xMin + float64(i)*step
+		for j := 0; j < cols; j++ {
+			y := yMin + float64(j)*step
+			k := i*cols + j
+
+			// Points where evaluation fails are skipped
+			report.record(x, y, errs[k])
+			points = append(points, NewPoint3D(x, y, heights[k]))
+			valid = append(valid, errs[k] == nil)
+
+			// AddGrid adds the valid points in order
+			if errs[k] != nil {
+				indices = append(indices, -1)
+			} else {
+				indices = append(indices, next)
+				next++
+			}
+		}
+	}
+
+	space.AddGrid(rows, cols, points, valid)
+	return report, indices, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Marching cubes cell layout. Corners are numbered
+//
+//	0:(0,0,0) 1:(1,0,0) 2:(1,1,0) 3:(0,1,0)
+//	4:(0,0,1) 5:(1,0,1) 6:(1,1,1) 7:(0,1,1)
+//
+// and edges join the corner pairs listed in cubeEdges.
+var cubeCorners = [8][3]int{
+	{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0},
+	{0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1},
+}
+
+var cubeEdges = [12][2]int{
+	{0, 1}, {1, 2}, {2, 3}, {3, 0},
+	{4, 5}, {5, 6}, {6, 7}, {7, 4},
+	{0, 4}, {1, 5}, {2, 6}, {3, 7},
+}
+
+// cubeFaces lists the corners of each cell face counter-clockwise as seen
+// from outside the cell
+var cubeFaces = [6][4]int{
+	{0, 3, 2, 1}, // z = 0
+	{4, 5, 6, 7}, // z = 1
+	{0, 1, 5, 4}, // y = 0
+	{3, 7, 6, 2}, // y = 1
+	{0, 4, 7, 3}, // x = 0
+	{1, 2, 6, 5}, // x = 1
+}
+
+// marchingCubesCases maps each of the 256 inside/outside corner
+// configurations to triangles, given as triples of cell edges
+var marchingCubesCases = buildMarchingCubesCases()
+
+// buildMarchingCubesCases derives the marching cubes case table. On each cell
+// face the iso-surface crosses the edges between inside and outside corners,
+// and each run of inside corners is cut off by one segment. Chaining the
+// segments of all six faces gives closed loops, which are triangulated as fans.
+// Ambiguous faces always separate their inside corners, and since that choice
+// only depends on the face, neighbouring cells agree and the mesh has no cracks.
+func buildMarchingCubesCases() [256][][3]int {
+	var edgeBetween [8][8]int
+	for e, corners := range cubeEdges {
+		edgeBetween[corners[0]][corners[1]] = e
+		edgeBetween[corners[1]][corners[0]] = e
+	}
+
+	var cases [256][][3]int
+	for c := 0; c < 256; c++ {
+		inside := func(corner int) bool { return c&(1<<corner) != 0 }
+
+		// next maps the edge where a segment starts to the edge where it ends
+		next := make(map[int]int)
+		for _, face := range cubeFaces {
+			for i := 0; i < 4; i++ {
+				prev, cur := face[(i+3)%4], face[i]
+				if inside(prev) || !inside(cur) {
+					continue
+				}
+
+				// cur starts a run of inside corners; find where it ends
+				last := i
+				for inside(face[(last+1)%4]) {
+					last = (last + 1) % 4
+				}
+
+				entering := edgeBetween[prev][cur]
+				leaving := edgeBetween[face[last]][face[(last+1)%4]]
+				next[leaving] = entering
+			}
+		}
+
+		visited := make(map[int]bool)
+		for e := 0; e < 12; e++ {
+			if _, ok := next[e]; !ok || visited[e] {
+				continue
+			}
+
+			var loop []int
+			for cur := e; !visited[cur]; cur = next[cur] {
+				visited[cur] = true
+				loop = append(loop, cur)
+			}
+
+			// Wind the triangles so their normals point towards the outside
+			for i := 1; i+1 < len(loop); i++ {
+				cases[c] = append(cases[c], [3]int{loop[0], loop[i+1], loop[i]})
+			}
+		}
+	}
+
+	return cases
+}
+
+// maxImplicitResolution is the largest resolution of an implicit surface,
+// which keeps its voxel grid within maxGridSamples samples
+const maxImplicitResolution = 256
+
+// GeneratePointsFromImplicit extracts the implicit surface f(x,y,z) = iso with
+// marching cubes and adds it to the given Space3D instance as a triangle mesh.
+// f is sampled at resolution points, up to maxImplicitResolution, along each
+// axis of the given bounds, and the surface normals point towards increasing
+// f. Samples where f can't be evaluated leave the cells around them empty.
+func GeneratePointsFromImplicit(space *Space3D, function string, params []Param, xMin, xMax, yMin, yMax, zMin, zMax float64, resolution int, iso float64) error {
+	if resolution < 2 {
+		return fmt.Errorf("an implicit surface needs a resolution of at least 2")
+	}
+	if resolution > maxImplicitResolution {
+		return fmt.Errorf("an implicit surface can have a resolution of at most %d", maxImplicitResolution)
+	}
+	if xMin >= xMax || yMin >= yMax || zMin >= zMax {
+		return fmt.Errorf("min values must be less than max values")
+	}
+
+	eval, err := newEvaluator(function, []string{"x", "y", "z"}, params)
+	if err != nil {
+		return err
+	}
+
+	n := resolution
+	position := func(i, j, k int) Point3D {
+		return NewPoint3D(
+			xMin+(xMax-xMin)*float64(i)/float64(n-1),
+			yMin+(yMax-yMin)*float64(j)/float64(n-1),
+			zMin+(zMax-zMin)*float64(k)/float64(n-1),
+		)
+	}
+	index := func(i, j, k int) int {
+		return (k*n+j)*n + i
+	}
+
+	// Sample the function on the voxel grid
+	values := make([]float64, n*n*n)
+	for k := 0; k < n; k++ {
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				p := position(i, j, k)
+				value, err := eval.EvaluateVars(p.X, p.Y, p.Z)
+				if err != nil {
+					value = math.NaN()
+				}
+				values[index(i, j, k)] = value - iso
+			}
+		}
+	}
+
+	// Vertices are shared between the cells around each grid edge. They are
+	// cached by the grid point at the lower end of the edge and its axis.
+	var vertices []Point3D
+	vertexCache := make([]int, 3*n*n*n)
+	for i := range vertexCache {
+		vertexCache[i] = -1
+	}
+	edgeVertex := func(i, j, k, edge int) int {
+		a, b := cubeEdges[edge][0], cubeEdges[edge][1]
+		ca, cb := cubeCorners[a], cubeCorners[b]
+		if ca[0]+ca[1]+ca[2] > cb[0]+cb[1]+cb[2] {
+			ca, cb = cb, ca
+		}
+
+		axis := 0
+		for cb[axis] == ca[axis] {
+			axis++
+		}
+		ia, ja, ka := i+ca[0], j+ca[1], k+ca[2]
+		ib, jb, kb := i+cb[0], j+cb[1], k+cb[2]
+
+		key := 3*index(ia, ja, ka) + axis
+		if vertexCache[key] >= 0 {
+			return vertexCache[key]
+		}
+
+		// Interpolate the zero crossing along the edge
+		va, vb := values[index(ia, ja, ka)], values[index(ib, jb, kb)]
+		t := 0.5
+		if va != vb {
+			t = va / (va - vb)
+		}
+		pa, pb := position(ia, ja, ka), position(ib, jb, kb)
+		vertex := NewPoint3D(
+			pa.X+(pb.X-pa.X)*t,
+			pa.Y+(pb.Y-pa.Y)*t,
+			pa.Z+(pb.Z-pa.Z)*t,
+		)
+
+		vertexCache[key] = len(vertices)
+		vertices = append(vertices, vertex)
+		return vertexCache[key]
+	}
+
+	// March through the cells
+	var faces []Face
+	for k := 0; k+1 < n; k++ {
+		for j := 0; j+1 < n; j++ {
+			for i := 0; i+1 < n; i++ {
+				c := 0
+				defined := true
+				for corner, offset := range cubeCorners {
+					value := values[index(i+offset[0], j+offset[1], k+offset[2])]
+					if math.IsNaN(value) {
+						defined = false
+						break
+					}
+					if value < 0 {
+						c |= 1 << corner
+					}
+				}
+				if !defined {
+					continue
+				}
+
+				for _, tri := range marchingCubesCases[c] {
+					faces = append(faces, Face{
+						edgeVertex(i, j, k, tri[0]),
+						edgeVertex(i, j, k, tri[1]),
+						edgeVertex(i, j, k, tri[2]),
+					})
+				}
+			}
+		}
+	}
+
+	space.AddMesh(vertices, faces)
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ODEMethod selects how an ODE system is integrated
+type ODEMethod int
+
+const (
+	// ODEEuler takes fixed forward Euler steps
+	ODEEuler ODEMethod = iota
+	// ODERK4 takes fixed classic fourth order Runge-Kutta steps
+	ODERK4
+	// ODERK45 takes Dormand-Prince 5(4) steps, adapting the step size to
+	// keep the estimated error of each step within a tolerance
+	ODERK45
+)
+
+// odeMethodNames are the names of the methods accepted by ParseODEMethod
+var odeMethodNames = []string{"euler", "rk4", "rk45"}
+
+// ParseODEMethod parses a method name: euler, rk4 or rk45
+func ParseODEMethod(s string) (ODEMethod, error) {
+	for i, name := range odeMethodNames {
+		if strings.EqualFold(s, name) {
+			return ODEMethod(i), nil
+		}
+	}
+	return ODERK4, fmt.Errorf("invalid ODE method %q: expected %s", s, strings.Join(odeMethodNames, ", "))
+}
+
+// String returns the name of the method
+func (m ODEMethod) String() string {
+	return odeMethodNames[m]
+}
+
+// odeMaxPoints bounds the length of a trajectory, so a tolerance too tight
+// for the system can't run away
+const odeMaxPoints = 1000000
+
+// ODESystem is a system of three first order ODEs dx/dt, dy/dt, dz/dt, each
+// given by an expression in x, y, z and t
+type ODESystem struct {
+	equations [3]*FunctionEvaluator
+}
+
+// NewODESystem creates an ODE system with the given right-hand sides
+func NewODESystem(dx, dy, dz string, params ...Param) (*ODESystem, error) {
+	system := &ODESystem{}
+	for i, expr := range []string{dx, dy, dz} {
+		eval, err := newEvaluator(expr, []string{"x", "y", "z", "t"}, params)
+		if err != nil {
+			return nil, fmt.Errorf("d%c/dt: %w", "xyz"[i], err)
+		}
+		system.equations[i] = eval
+	}
+	return system, nil
+}
+
+// Derivative returns the rates of change of x, y and z at the state p and time t
+func (s *ODESystem) Derivative(t float64, p Point3D) (Point3D, error) {
+	var d [3]float64
+	for i, eval := range s.equations {
+		value, err := checkValue(eval.EvaluateVars(p.X, p.Y, p.Z, t))
+		if err != nil {
+			return Point3D{}, fmt.Errorf("d%c/dt at t = %g: %w", "xyz"[i], t, err)
+		}
+		d[i] = value
+	}
+	return NewPoint3D(d[0], d[1], d[2]), nil
+}
+
+// combine returns p + h * sum(weights[i] * ks[i])
+func combine(p Point3D, h float64, weights []float64, ks []Point3D) Point3D {
+	for i, w := range weights {
+		p = NewPoint3D(p.X+h*w*ks[i].X, p.Y+h*w*ks[i].Y, p.Z+h*w*ks[i].Z)
+	}
+	return p
+}
+
+// Dormand-Prince 5(4) coefficients
+var (
+	dopriC = []float64{0, 1.0 / 5, 3.0 / 10, 4.0 / 5, 8.0 / 9, 1, 1}
+	dopriA = [][]float64{
+		{},
+		{1.0 / 5},
+		{3.0 / 40, 9.0 / 40},
+		{44.0 / 45, -56.0 / 15, 32.0 / 9},
+		{19372.0 / 6561, -25360.0 / 2187, 64448.0 / 6561, -212.0 / 729},
+		{9017.0 / 3168, -355.0 / 33, 46732.0 / 5247, 49.0 / 176, -5103.0 / 18656},
+		{35.0 / 384, 0, 500.0 / 1113, 125.0 / 192, -2187.0 / 6784, 11.0 / 84},
+	}
+	dopri5 = []float64{35.0 / 384, 0, 500.0 / 1113, 125.0 / 192, -2187.0 / 6784, 11.0 / 84, 0}
+	dopri4 = []float64{5179.0 / 57600, 0, 7571.0 / 16695, 393.0 / 640, -92097.0 / 339200, 187.0 / 2100, 1.0 / 40}
+)
+
+// step advances the state p at time t by h with an explicit Runge-Kutta
+// method given by its nodes c, matrix a and weights b. With a second set of
+// weights, it also returns the difference between the two solutions as an
+// estimate of the error.
+func (s *ODESystem) step(t float64, p Point3D, h float64, c []float64, a [][]float64, b, bErr []float64) (Point3D, float64, error) {
+	ks := make([]Point3D, len(c))
+	for i := range c {
+		k, err := s.Derivative(t+c[i]*h, combine(p, h, a[i], ks))
+		if err != nil {
+			return p, 0, err
+		}
+		ks[i] = k
+	}
+
+	next := combine(p, h, b, ks)
+	if bErr == nil {
+		return next, 0, nil
+	}
+	return next, Distance(next, combine(p, h, bErr, ks)), nil
+}
+
+// Solve integrates the system from the state initial at time t0 to t1 and
+// returns the trajectory along with the time of each of its points. Euler and
+// RK4 take steps of dt; RK45 starts with dt and adapts it so the estimated
+// error of each step is at most tolerance. When the system can't be evaluated,
+// such as when the solution blows up, or the trajectory would pass
+// odeMaxPoints points, the trajectory up to that point is returned with the
+// error.
+func (s *ODESystem) Solve(method ODEMethod, initial Point3D, t0, t1, dt, tolerance float64) ([]Point3D, []float64, error) {
+	if dt <= 0 {
+		return nil, nil, fmt.Errorf("time step must be positive")
+	}
+	if t1 <= t0 {
+		return nil, nil, fmt.Errorf("end time must be after start time")
+	}
+	if method == ODERK45 && tolerance <= 0 {
+		return nil, nil, fmt.Errorf("tolerance must be positive")
+	}
+
+	points := []Point3D{initial}
+	times := []float64{t0}
+	p, t, h := initial, t0, dt
+	for t < t1 && len(points) < odeMaxPoints {
+		// The last step ends exactly at t1
+		last := h >= t1-t
+		if last {
+			h = t1 - t
+		}
+
+		var next Point3D
+		var e float64
+		var err error
+		switch method {
+		case ODEEuler:
+			next, _, err = s.step(t, p, h, []float64{0}, [][]float64{{}}, []float64{1}, nil)
+		case ODERK4:
+			next, _, err = s.step(t, p, h, []float64{0, 0.5, 0.5, 1}, [][]float64{{}, {0.5}, {0, 0.5}, {0, 0, 1}}, []float64{1.0 / 6, 1.0 / 3, 1.0 / 3, 1.0 / 6}, nil)
+		default:
+			next, e, err = s.step(t, p, h, dopriC, dopriA, dopri5, dopri4)
+		}
+		if err != nil {
+			return points, times, err
+		}
+
+		// Scale adaptive steps towards the tolerance, within limits, and
+		// retry steps whose error is too large
+		factor := 1.0
+		if method == ODERK45 {
+			factor = 5.0
+			if e > 0 {
+				factor = math.Max(0.2, math.Min(5, 0.9*math.Pow(tolerance/e, 0.2)))
+			}
+			if e > tolerance {
+				h *= factor
+				if t+h == t {
+					return points, times, fmt.Errorf("step size vanished at t = %g", t)
+				}
+				continue
+			}
+		}
+
+		t += h
+		if last {
+			t = t1
+		}
+		p = next
+		points = append(points, p)
+		times = append(times, t)
+		h *= factor
+	}
+	if t < t1 {
+		return points, times, fmt.Errorf("trajectory reached its limit of %d points at t = %g", odeMaxPoints, t)
+	}
+
+	return points, times, nil
+}
+
+// ParsePoint parses a comma separated list of three constant expressions,
+// such as "1,0,pi/2", as a point
+func ParsePoint(s string) (Point3D, error) {
+	exprs, err := SplitExpressions(s, 3)
+	if err != nil {
+		return Point3D{}, err
+	}
+
+	var coords [3]float64
+	for i, expr := range exprs {
+		value, err := EvaluateConstant(expr)
+		if err != nil {
+			return Point3D{}, fmt.Errorf("%c: %w", "xyz"[i], err)
+		}
+		coords[i] = value
+	}
+	return NewPoint3D(coords[0], coords[1], coords[2]), nil
+}
+
+// GeneratePointsFromODE solves the ODE system (dx, dy, dz) from the state
+// initial over [t0, t1], as described for ODESystem.Solve, and adds the
+// trajectory to the given Space3D instance as points connected in order. If
+// the solution can't be continued to t1, the trajectory so far is still
+// added and the error is returned.
+func GeneratePointsFromODE(space *Space3D, dx, dy, dz string, params []Param, initial Point3D, method ODEMethod, t0, t1, dt, tolerance float64) error {
+	system, err := NewODESystem(dx, dy, dz, params...)
+	if err != nil {
+		return err
+	}
+
+	points, _, err := system.Solve(method, initial, t0, t1, dt, tolerance)
+	if len(points) > 1 {
+		space.AddPolyline(points)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strconv"
+	"strings"
+)
+
+// Param is a named free parameter of a function expression, such as a and b in a*sin(b*x)
+type Param struct {
+	Name  string
+	Value float64
+}
+
+// ParseParams parses a comma separated list of parameter assignments like "a=1,b=2"
+func ParseParams(s string) ([]Param, error) {
+	var params []Param
+	seen := make(map[string]bool)
+
+	for _, assignment := range strings.Split(s, ",") {
+		assignment = strings.TrimSpace(assignment)
+		if assignment == "" {
+			continue
+		}
+
+		name, valueStr, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid parameter %q: expected name=value", assignment)
+		}
+
+		name = strings.TrimSpace(name)
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("invalid parameter name: %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("parameter %s declared more than once", name)
+		}
+		seen[name] = true
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(valueStr), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for parameter %s: %w", name, err)
+		}
+
+		params = append(params, Param{Name: name, Value: value})
+	}
+
+	return params, nil
+}
+
+// FormatParams formats parameters in the form accepted by ParseParams
+func FormatParams(params []Param) string {
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.Name + "=" + strconv.FormatFloat(p.Value, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// FreeParams returns the identifiers in a function expression that are neither
+// one of the given variables nor a named constant, in order of first appearance.
+// These are the parameters the expression needs values for.
+func FreeParams(function string, vars ...string) ([]string, error) {
+	tree, err := parseExpression(function)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expression: %v", err)
+	}
+
+	// Function names are identifiers too, but never parameters
+	functionNames := make(map[*ast.Ident]bool)
+	ast.Inspect(tree, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if fun, ok := call.Fun.(*ast.Ident); ok {
+				functionNames[fun] = true
+			}
+		}
+		return true
+	})
+
+	known := make(map[string]bool)
+	for _, name := range vars {
+		known[name] = true
+	}
+
+	var names []string
+	ast.Inspect(tree, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok || functionNames[ident] || known[ident.Name] {
+			return true
+		}
+		if _, ok := lookupConstant(ident.Name); ok {
+			return true
+		}
+		known[ident.Name] = true
+		names = append(names, ident.Name)
+		return true
+	})
+
+	return names, nil
+}
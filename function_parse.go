@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The function language is close to Go expression syntax, but Go's parser
+// can't express exponentiation (^ is XOR at addition precedence) or the
+// ternary operator. parseExpression builds a go/ast tree with the function
+// language's own precedence rules, from lowest to highest:
+//
+//	cond ? a : b
+//	||
+//	&&
+//	== != < <= > >=
+//	+ -
+//	* / %
+//	unary - + !
+//	^ or ** (right associative, binds tighter than unary minus on its left)
+//
+// Exponentiation is represented as a binary expression with the token.XOR
+// operator, and the ternary operator as a call to the if built-in.
+
+// exprToken is a single lexical token of a function expression, at pos
+// characters from the start
+type exprToken struct {
+	tok token.Token
+	lit string
+	pos int
+}
+
+// tokenizeExpression splits a function expression into tokens
+func tokenizeExpression(src string) ([]exprToken, error) {
+	// Operators ordered so that longer ones are matched first
+	operators := []struct {
+		text string
+		tok  token.Token
+	}{
+		{"**", token.XOR},
+		{"&&", token.LAND},
+		{"||", token.LOR},
+		{"==", token.EQL},
+		{"!=", token.NEQ},
+		{"<=", token.LEQ},
+		{">=", token.GEQ},
+		{"+", token.ADD},
+		{"-", token.SUB},
+		{"*", token.MUL},
+		{"/", token.QUO},
+		{"%", token.REM},
+		{"^", token.XOR},
+		{"<", token.LSS},
+		{">", token.GTR},
+		{"!", token.NOT},
+		{"(", token.LPAREN},
+		{")", token.RPAREN},
+		{",", token.COMMA},
+		{"?", token.ILLEGAL},
+		{":", token.COLON},
+	}
+
+	// column turns a byte offset into a position in characters
+	column := func(offset int) int {
+		return utf8.RuneCountInString(src[:offset])
+	}
+
+	var tokens []exprToken
+	i := 0
+	for i < len(src) {
+		c, size := utf8.DecodeRuneInString(src[i:])
+
+		switch {
+		case c == utf8.RuneError && size == 1:
+			return nil, fmt.Errorf("invalid UTF-8 at position %d", column(i)+1)
+
+		case unicode.IsSpace(c):
+			i += size
+
+		case isDigit(src[i]) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			// Numbers: digits, optional fraction and optional exponent
+			start := i
+			kind := token.INT
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				if src[i] == '.' {
+					kind = token.FLOAT
+				}
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				j := i + 1
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				if j < len(src) && isDigit(src[j]) {
+					for j < len(src) && isDigit(src[j]) {
+						j++
+					}
+					kind = token.FLOAT
+					i = j
+				}
+			}
+			tokens = append(tokens, exprToken{tok: kind, lit: src[start:i], pos: column(start)})
+
+		case unicode.IsLetter(c) || c == '_':
+			// Identifiers may use any letters, such as θ
+			start := i
+			for i < len(src) {
+				c, size := utf8.DecodeRuneInString(src[i:])
+				if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, exprToken{tok: token.IDENT, lit: src[start:i], pos: column(start)})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op.text) {
+					tokens = append(tokens, exprToken{tok: op.tok, lit: op.text, pos: column(i)})
+					i += len(op.text)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, column(i)+1)
+			}
+		}
+	}
+
+	tokens = append(tokens, exprToken{tok: token.EOF, pos: column(len(src))})
+	return tokens, nil
+}
+
+// isDigit reports whether b is an ASCII digit, the only digits of numbers
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// exprParser is a recursive descent parser over the tokens of a function expression
+type exprParser struct {
+	tokens []exprToken
+	next   int
+}
+
+// parseExpression parses a function expression into a go/ast expression tree
+func parseExpression(src string) (ast.Expr, error) {
+	tokens, err := tokenizeExpression(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	expr, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.tok != token.EOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.lit, tok.pos+1)
+	}
+
+	return expr, nil
+}
+
+// peek returns the current token without consuming it
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+// advance consumes and returns the current token
+func (p *exprParser) advance() exprToken {
+	tok := p.tokens[p.next]
+	if tok.tok != token.EOF {
+		p.next++
+	}
+	return tok
+}
+
+// expect consumes the current token if it has the given literal text
+func (p *exprParser) expect(lit string) error {
+	tok := p.peek()
+	if tok.lit != lit {
+		if tok.tok == token.EOF {
+			return fmt.Errorf("expected %q at end of expression", lit)
+		}
+		return fmt.Errorf("expected %q at position %d, found %q", lit, tok.pos+1, tok.lit)
+	}
+	p.advance()
+	return nil
+}
+
+// parseTernary parses cond ? a : b
+func (p *exprParser) parseTernary() (ast.Expr, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().lit != "?" {
+		return cond, nil
+	}
+	p.advance()
+
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.CallExpr{
+		Fun:  ast.NewIdent("if"),
+		Args: []ast.Expr{cond, then, otherwise},
+	}, nil
+}
+
+// binaryLevels lists the left associative binary operators by increasing precedence
+var binaryLevels = [][]token.Token{
+	{token.LOR},
+	{token.LAND},
+	{token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ},
+	{token.ADD, token.SUB},
+	{token.MUL, token.QUO, token.REM},
+}
+
+// parseBinary parses left associative binary operators at the given precedence level
+func (p *exprParser) parseBinary(level int) (ast.Expr, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek().tok
+		found := false
+		for _, candidate := range binaryLevels[level] {
+			if op == candidate {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return left, nil
+		}
+		p.advance()
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &ast.BinaryExpr{X: left, Op: op, Y: right}
+	}
+}
+
+// parseUnary parses prefix -, + and ! operators
+func (p *exprParser) parseUnary() (ast.Expr, error) {
+	switch op := p.peek().tok; op {
+	case token.SUB, token.ADD, token.NOT:
+		p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.UnaryExpr{Op: op, X: operand}, nil
+	}
+
+	return p.parsePower()
+}
+
+// parsePower parses right associative exponentiation
+func (p *exprParser) parsePower() (ast.Expr, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().tok != token.XOR {
+		return base, nil
+	}
+	p.advance()
+
+	// The exponent may carry its own sign, as in 2^-x
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.BinaryExpr{X: base, Op: token.XOR, Y: exponent}, nil
+}
+
+// parsePrimary parses numbers, identifiers, function calls and parentheses
+func (p *exprParser) parsePrimary() (ast.Expr, error) {
+	tok := p.advance()
+
+	switch tok.tok {
+	case token.INT, token.FLOAT:
+		return &ast.BasicLit{Kind: tok.tok, Value: tok.lit}, nil
+
+	case token.IDENT:
+		ident := ast.NewIdent(tok.lit)
+		if p.peek().tok != token.LPAREN {
+			return ident, nil
+		}
+		p.advance()
+
+		call := &ast.CallExpr{Fun: ident}
+		if p.peek().tok == token.RPAREN {
+			p.advance()
+			return call, nil
+		}
+
+		for {
+			arg, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+
+			if p.peek().tok != token.COMMA {
+				break
+			}
+			p.advance()
+		}
+
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return call, nil
+
+	case token.LPAREN:
+		inner, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &ast.ParenExpr{X: inner}, nil
+
+	case token.EOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.lit, tok.pos+1)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// EvaluationReport describes the samples of a function surface, parametric
+// surface or curve that couldn't be evaluated, which are left out as holes
+type EvaluationReport struct {
+	// Samples is the number of points where the function was evaluated
+	Samples int
+
+	// Failures counts the failed samples by error message, such as
+	// "square root of negative number"
+	Failures map[string]int
+
+	// Gaps holds the positions of the first reportMaxGaps failed samples
+	// in the z = 0 plane, or in space for vector fields. For parametric
+	// curves and surfaces they are the parameters t, or u and v, of the
+	// samples instead.
+	Gaps []Point3D
+
+	// Bounds of the failed samples, meaningful when there are any
+	XMin, XMax, YMin, YMax, ZMin, ZMax float64
+
+	// failed counts the failed samples, including those past Gaps
+	failed int
+
+	// vars names the variables the samples are positioned by
+	vars []string
+}
+
+// reportMaxGaps bounds the failed samples kept in EvaluationReport.Gaps
+const reportMaxGaps = 10000
+
+// newEvaluationReport creates an empty report of samples positioned by x and y
+func newEvaluationReport() *EvaluationReport {
+	return newParametricReport("x", "y")
+}
+
+// newParametricReport creates an empty report of samples positioned by the
+// given variables, one for curves, two for surfaces or three for fields
+func newParametricReport(vars ...string) *EvaluationReport {
+	return &EvaluationReport{
+		vars:     vars,
+		Failures: make(map[string]int),
+		XMin:     math.Inf(1),
+		XMax:     math.Inf(-1),
+		YMin:     math.Inf(1),
+		YMax:     math.Inf(-1),
+		ZMin:     math.Inf(1),
+		ZMax:     math.Inf(-1),
+	}
+}
+
+// checkValue turns results that are not finite numbers into errors, so they
+// are reported like other failures rather than plotted
+func checkValue(z float64, err error) (float64, error) {
+	if err == nil && (math.IsNaN(z) || math.IsInf(z, 0)) {
+		return z, fmt.Errorf("result is not finite")
+	}
+	return z, err
+}
+
+// record counts a sample at (x, y), which failed if err is not nil
+func (r *EvaluationReport) record(x, y float64, err error) {
+	r.recordAt(NewPoint3D(x, y, 0), err)
+}
+
+// recordAt counts a sample at p, which failed if err is not nil
+func (r *EvaluationReport) recordAt(p Point3D, err error) {
+	r.Samples++
+	if err == nil {
+		return
+	}
+
+	r.failed++
+	r.Failures[err.Error()]++
+	if len(r.Gaps) < reportMaxGaps {
+		r.Gaps = append(r.Gaps, p)
+	}
+	r.XMin = math.Min(r.XMin, p.X)
+	r.XMax = math.Max(r.XMax, p.X)
+	r.YMin = math.Min(r.YMin, p.Y)
+	r.YMax = math.Max(r.YMax, p.Y)
+	r.ZMin = math.Min(r.ZMin, p.Z)
+	r.ZMax = math.Max(r.ZMax, p.Z)
+}
+
+// Failed returns the number of samples that couldn't be evaluated
+func (r *EvaluationReport) Failed() int {
+	return r.failed
+}
+
+// String summarizes the failures, most common first
+func (r *EvaluationReport) String() string {
+	if r.Failed() == 0 {
+		return fmt.Sprintf("All %d samples evaluated", r.Samples)
+	}
+
+	kinds := make([]string, 0, len(r.Failures))
+	for kind := range r.Failures {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if r.Failures[kinds[i]] != r.Failures[kinds[j]] {
+			return r.Failures[kinds[i]] > r.Failures[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+
+	var b strings.Builder
+	vars := r.vars
+	if len(vars) == 0 {
+		vars = []string{"x", "y"}
+	}
+	fmt.Fprintf(&b, "%d of %d samples failed in %s=[%.2f, %.2f]", r.Failed(), r.Samples, vars[0], r.XMin, r.XMax)
+	if len(vars) > 1 {
+		fmt.Fprintf(&b, ", %s=[%.2f, %.2f]", vars[1], r.YMin, r.YMax)
+	}
+	if len(vars) > 2 {
+		fmt.Fprintf(&b, ", %s=[%.2f, %.2f]", vars[2], r.ZMin, r.ZMax)
+	}
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "\n  %d: %s", r.Failures[kind], kind)
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package main
+
+import "fmt"
+
+// GeneratePointsFromSurface samples the parametric surface
+// (x(u,v), y(u,v), z(u,v)) on a uSamples x vSamples grid spanning
+// [uMin, uMax] x [vMin, vMax] and adds it to the given Space3D instance as a
+// grid of points connected by quad faces. Grid points where the surface can't
+// be evaluated or isn't finite are left out along with the faces touching
+// them, and the returned report says at which u and v and why.
+func GeneratePointsFromSurface(space *Space3D, xExpr, yExpr, zExpr string, params []Param, uMin, uMax, vMin, vMax float64, uSamples, vSamples int) (*EvaluationReport, error) {
+	if uSamples < 2 || vSamples < 2 {
+		return nil, fmt.Errorf("a surface needs at least 2 samples in u and v")
+	}
+
+	// Prepare one evaluator per coordinate
+	var evals [3]*FunctionEvaluator
+	for i, expr := range []string{xExpr, yExpr, zExpr} {
+		eval, err := newEvaluator(expr, []string{"u", "v"}, params)
+		if err != nil {
+			return nil, fmt.Errorf("%c(u,v): %w", "xyz"[i], err)
+		}
+		evals[i] = eval
+	}
+
+	// Rows follow v and columns follow u
+	report := newParametricReport("u", "v")
+	points := make([]Point3D, 0, uSamples*vSamples)
+	valid := make([]bool, 0, uSamples*vSamples)
+	for j := 0; j < vSamples; j++ {
+		v := vMin + (vMax-vMin)*float64(j)/float64(vSamples-1)
+
+		for i := 0; i < uSamples; i++ {
+			u := uMin + (uMax-uMin)*float64(i)/float64(uSamples-1)
+
+			var coords [3]float64
+			var err error
+			for k, eval := range evals {
+				if coords[k], err = checkValue(eval.EvaluateVars(u, v)); err != nil {
+					break
+				}
+			}
+			report.record(u, v, err)
+
+			points = append(points, NewPoint3D(coords[0], coords[1], coords[2]))
+			valid = append(valid, err == nil)
+		}
+	}
+
+	space.AddGrid(vSamples, uSamples, points, valid)
+	return report, nil
+}
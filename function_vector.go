@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// VectorField is a vector field in space given by an expression in x, y and z
+// for each of its components
+type VectorField struct {
+	components [3]*FunctionEvaluator
+}
+
+// NewVectorField creates a vector field with the given component expressions
+func NewVectorField(xExpr, yExpr, zExpr string, params ...Param) (*VectorField, error) {
+	field := &VectorField{}
+	for i, expr := range []string{xExpr, yExpr, zExpr} {
+		eval, err := newEvaluator(expr, []string{"x", "y", "z"}, params)
+		if err != nil {
+			return nil, fmt.Errorf("F%c(x,y,z): %w", "xyz"[i], err)
+		}
+		field.components[i] = eval
+	}
+	return field, nil
+}
+
+// Evaluate returns the vector of the field at the given point. Results that
+// are not finite are errors.
+func (f *VectorField) Evaluate(p Point3D) (Point3D, error) {
+	var v [3]float64
+	for i, eval := range f.components {
+		value, err := checkValue(eval.EvaluateVars(p.X, p.Y, p.Z))
+		if err != nil {
+			return Point3D{}, fmt.Errorf("F%c: %w", "xyz"[i], err)
+		}
+		v[i] = value
+	}
+	return NewPoint3D(v[0], v[1], v[2]), nil
+}
+
+// Magnitude returns the length of a vector
+func Magnitude(v Point3D) float64 {
+	return math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+}
+
+// GenerateVectorField samples the vector field (fx, fy, fz) on a lattice over
+// the given bounds with the given step and adds a point at each lattice
+// position, carrying the field's vector there. Points are colored by the
+// length of their vector, from blue for the shortest to red for the longest.
+// Positions where the field can't be evaluated or isn't finite are left out,
+// and the returned report says which and why. The lattice may have at most
+// maxGridSamples positions.
+func GenerateVectorField(space *Space3D, fx, fy, fz string, params []Param, xMin, xMax, yMin, yMax, zMin, zMax, step float64) (*EvaluationReport, error) {
+	if err := checkGrid(xMin, xMax, yMin, yMax, step); err != nil {
+		return nil, err
+	}
+	if !(zMin <= zMax) {
+		return nil, fmt.Errorf("min values must not be greater than max values")
+	}
+	nx, ny := gridSize(xMin, xMax, yMin, yMax, step)
+	if samples := float64(nx*ny) * ((zMax-zMin)/step + 1); !(samples <= maxGridSamples) {
+		return nil, fmt.Errorf("step size %g is too small: the lattice would have more than %d samples", step, maxGridSamples)
+	}
+	nz := axisSamples(zMin, zMax, step)
+
+	field, err := NewVectorField(fx, fy, fz, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := newParametricReport("x", "y", "z")
+	var points, vectors []Point3D
+	for i := 0; i < nx; i++ {
+		for j := 0; j < ny; j++ {
+			for k := 0; k < nz; k++ {
+				p := NewPoint3D(xMin+float64(i)*step, yMin+float64(j)*step, zMin+float64(k)*step)
+				v, err := field.Evaluate(p)
+				report.recordAt(p, err)
+				if err != nil {
+					continue
+				}
+				points = append(points, p)
+				vectors = append(vectors, v)
+			}
+		}
+	}
+
+	// Color by magnitude relative to the range of magnitudes
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range vectors {
+		lo = math.Min(lo, Magnitude(v))
+		hi = math.Max(hi, Magnitude(v))
+	}
+
+	start := len(space.Points)
+	for i, p := range points {
+		space.AddPoint(p)
+		space.SetVector(start+i, vectors[i])
+
+		t := 0.0
+		if hi > lo {
+			t = (Magnitude(vectors[i]) - lo) / (hi - lo)
+		}
+		space.SetColor(start+i, magnitudeColor(t))
+	}
+
+	return report, nil
+}
+
+// magnitudeColor maps t in [0, 1] to a hue running from blue through cyan,
+// green and yellow to red
+func magnitudeColor(t float64) color.RGBA {
+	return phaseColor((1 - t) * 4 * math.Pi / 3)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"math"
 	"os"
+	"strings"
 )
 
 func add(a, b int) int {
@@ -55,9 +56,32 @@ func generateSampleCSV(fileName string) error {
 	return space.SavePointsToCSV(fileName)
 }
 
+// solidBase returns a base for Space3D.Solidify below the lowest point of
+// the space by a tenth of its height, or by 1 if it is flat
+func solidBase(space *Space3D) float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range space.Points {
+		lo, hi = math.Min(lo, p.Z), math.Max(hi, p.Z)
+	}
+	if !(hi > lo) {
+		return lo - 1
+	}
+	return lo - (hi-lo)/10
+}
+
 func main() {
 	// Command line flags
-	csvFile := flag.String("csv", "", "Path to CSV file with 3D points")
+	inputFile := flag.String("input", "", "Path to a file of 3D points or a mesh: .ply, .obj, .stl, .las, .pcd, .xyz, .pts, or otherwise CSV")
+	csvFile := flag.String("csv", "", "Same as -input")
+	colorBy := flag.String("colorby", "", "What to color the points by: a point attribute, such as a CSV column, or 'height' or 'distance' from the origin")
+	colormapStr := flag.String("colormap", Colormaps[0].Name, "Colormap for -colorby: "+strings.Join(ColormapNames(), ", "))
+	colorMinStr := flag.String("colormin", "", "Value at the start of the colormap (default: the smallest value)")
+	colorMaxStr := flag.String("colormax", "", "Value at the end of the colormap (default: the largest value)")
+	sizeBy := flag.String("sizeby", "", "Numeric point attribute to size the points by")
+	labelBy := flag.String("label", "", "Point attribute to show in hover labels")
+	colsStr := flag.String("cols", "", "CSV columns holding the coordinates, by header name or index from 0 (e.g., 'x=lon,y=lat,z=alt')")
+	decimate := flag.Int("decimate", 1, "Keep only every Nth row of the CSV file")
+	badRowsStr := flag.String("badrows", "fail", "What to do with CSV rows that aren't points: fail, skip, or collect to also print them")
 	generateSample := flag.String("generate", "", "Generate a sample CSV file at the specified path")
 	functionStr := flag.String("function", "", "Mathematical function to visualize (e.g., 'sin(x) * cos(y)')")
 	xMin := flag.Float64("xmin", -5.0, "Minimum x value for function visualization")
@@ -65,8 +89,63 @@ func main() {
 	yMin := flag.Float64("ymin", -5.0, "Minimum y value for function visualization")
 	yMax := flag.Float64("ymax", 5.0, "Maximum y value for function visualization")
 	step := flag.Float64("step", 0.2, "Step size for function visualization")
+	complexPlot := flag.Bool("complex", false, "Plot the function as a complex function of z = x + iy, with height |f(z)| and color arg f(z)")
+	adaptive := flag.Bool("adaptive", false, "Sample the function adaptively, refining the step grid where the surface bends")
+	tolerance := flag.Float64("tolerance", 0.01, "Largest second difference allowed in a cell for adaptive sampling")
+	maxPoints := flag.Int("maxpoints", 20000, "Largest number of points for adaptive sampling")
+	gaps := flag.Bool("gaps", false, "Mark the points where the function can't be evaluated")
+	gradient := flag.Bool("gradient", false, "Print the partial derivatives of the function and show gradient arrows")
+	paramStr := flag.String("param", "", "Values of free parameters in the function (e.g., 'a=1,b=2')")
+	curveStr := flag.String("curve", "", "Parametric curve x(t),y(t),z(t) to visualize (e.g., 'cos(t),sin(t),t/3')")
+	tMin := flag.Float64("tmin", 0.0, "Minimum t value for curve visualization, and start time of ODE solutions")
+	tMax := flag.Float64("tmax", 10.0, "Maximum t value for curve visualization, and end time of ODE solutions")
+	samples := flag.Int("samples", 200, "Number of samples for curve visualization")
+	odeStr := flag.String("ode", "", "ODE system dx/dt,dy/dt,dz/dt in x, y, z and t to solve (e.g., 's*(y-x),x*(r-z)-y,x*y-b*z')")
+	initStr := flag.String("init", "1,1,1", "Initial state x,y,z of the ODE solution at -tmin")
+	methodStr := flag.String("method", "rk45", "ODE integration method: euler, rk4 or rk45")
+	dt := flag.Float64("dt", 0.01, "Time step of ODE solutions, or the first step for rk45")
+	odeTolerance := flag.Float64("odetol", 1e-6, "Largest error allowed in an rk45 step")
+	surfaceStr := flag.String("surface", "", "Parametric surface x(u,v),y(u,v),z(u,v) to visualize (e.g., 'cos(u)*sin(v),sin(u)*sin(v),cos(v)')")
+	uMin := flag.Float64("umin", 0.0, "Minimum u value for surface visualization")
+	uMax := flag.Float64("umax", 2*math.Pi, "Maximum u value for surface visualization")
+	vMin := flag.Float64("vmin", 0.0, "Minimum v value for surface visualization")
+	vMax := flag.Float64("vmax", math.Pi, "Maximum v value for surface visualization")
+	uSamples := flag.Int("usamples", 40, "Number of u samples for surface visualization")
+	vSamples := flag.Int("vsamples", 20, "Number of v samples for surface visualization")
+	implicitStr := flag.String("implicit", "", "Implicit surface f(x,y,z)=iso to visualize (e.g., 'x^2+y^2+z^2-1')")
+	vectorStr := flag.String("vector", "", "Vector field Fx(x,y,z),Fy(x,y,z),Fz(x,y,z) to draw as arrows on the step lattice (e.g., '-y,x,z/2')")
+	streamlines := flag.Bool("streamlines", false, "Trace streamlines of the -vector field instead of drawing arrows")
+	seedsFile := flag.String("seeds", "", "Path to CSV file with streamline seed points (default: a lattice with spacing -seedstep)")
+	seedStep := flag.Float64("seedstep", 1.0, "Spacing of the lattice of streamline seeds")
+	lineLength := flag.Float64("linelength", 20.0, "Largest length of a streamline")
+	lineTolerance := flag.Float64("linetol", 1e-4, "Largest error allowed in a streamline integration step")
+	zMin := flag.Float64("zmin", -5.0, "Minimum z value for implicit surface and vector field visualization")
+	zMax := flag.Float64("zmax", 5.0, "Maximum z value for implicit surface and vector field visualization")
+	resolution := flag.Int("resolution", 40, "Number of samples along each axis for implicit surface visualization")
+	iso := flag.Float64("iso", 0.0, "Iso-value of the implicit surface")
+	upAxisStr := flag.String("up", "", "Axis pointing up in the view: y or z (default z for generated plots, y otherwise)")
+	outputFile := flag.String("output", "", "Save the generated points to a file (.csv, .las, .pcd, .xyz or .pts, or .obj, .ply or .stl to include faces)")
+	plyFormatStr := flag.String("plyformat", "binary_little_endian", "Encoding of saved PLY files: ascii, binary_little_endian or binary_big_endian")
+	stlFormatStr := flag.String("stlformat", "binary", "Encoding of saved STL files: binary or ascii")
+	pcdFormatStr := flag.String("pcdformat", "binary", "Encoding of saved PCD files: ascii or binary")
+	solidify := flag.Bool("solidify", false, "Close surfaces into solids down to a flat base before saving, for 3D printing")
 
 	flag.Parse()
+	if *inputFile == "" {
+		inputFile = csvFile
+	}
+	plyFormat, err := ParsePLYFormat(*plyFormatStr)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	stlFormat, err := ParseSTLFormat(*stlFormatStr)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	pcdFormat, err := ParsePCDFormat(*pcdFormatStr)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
 
 	// Generate sample data if requested
 	if *generateSample != "" {
@@ -78,29 +157,233 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Parse free parameters of the function, if any
+	params, err := ParseParams(*paramStr)
+	if err != nil {
+		log.Fatalf("Error parsing parameters: %v", err)
+	}
+
 	// Create a 3D space
 	space := NewSpace3D()
 
+	// Samples of the function that couldn't be evaluated
+	var report *EvaluationReport
+
 	// Check if function visualization is requested
 	if *functionStr != "" {
+		if err := checkGrid(*xMin, *xMax, *yMin, *yMax, *step); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
 		fmt.Printf("Generating points from function: %s\n", *functionStr)
 		fmt.Printf("Range: x=[%.2f, %.2f], y=[%.2f, %.2f], step=%.2f\n", *xMin, *xMax, *yMin, *yMax, *step)
+		if len(params) > 0 {
+			fmt.Printf("Parameters: %s\n", FormatParams(params))
+		}
 		
-		if err := GeneratePointsFromFunction(space, *functionStr, *xMin, *xMax, *yMin, *yMax, *step); err != nil {
+		switch {
+		case *complexPlot && *adaptive:
+			log.Fatalf("Adaptive sampling isn't available for complex functions")
+		case *complexPlot && *gradient:
+			log.Fatalf("Gradients aren't available for complex functions")
+		case *complexPlot:
+			fmt.Println("Complex plot: height |f(z)|, color arg f(z), z = x + iy")
+			report, err = GeneratePointsFromComplexFunction(space, *functionStr, params, *xMin, *xMax, *yMin, *yMax, *step)
+		case *adaptive:
+			fmt.Printf("Adaptive sampling: tolerance=%g, max points=%d\n", *tolerance, *maxPoints)
+			report, err = GenerateAdaptivePointsFromFunction(space, *functionStr, params, *xMin, *xMax, *yMin, *yMax, *step, *tolerance, *maxPoints)
+		default:
+			report, err = GeneratePointsFromFunction(space, *functionStr, params, *xMin, *xMax, *yMin, *yMax, *step)
+		}
+		if err != nil {
 			log.Fatalf("Error generating points from function: %v", err)
 		}
 		
 		fmt.Printf("Generated %d points from function\n", len(space.Points))
-	// Load points from CSV if provided
-	} else if *csvFile != "" {
-		fmt.Printf("Loading points from CSV file: %s\n", *csvFile)
-		if err := space.LoadPointsFromCSV(*csvFile); err != nil {
-			log.Fatalf("Error loading CSV file: %v", err)
+		if report.Failed() > 0 {
+			fmt.Println(report)
+		}
+
+		if *gradient {
+			g, err := NewGradient(*functionStr, params...)
+			if err != nil {
+				log.Fatalf("Error differentiating function: %v", err)
+			}
+			fmt.Printf("∂f/∂x = %s\n", g.DX.Expression())
+			fmt.Printf("∂f/∂y = %s\n", g.DY.Expression())
+		}
+	// Check if a parametric curve is requested
+	} else if *curveStr != "" {
+		fmt.Printf("Generating points from curve: %s\n", *curveStr)
+		fmt.Printf("Range: t=[%.2f, %.2f], samples=%d\n", *tMin, *tMax, *samples)
+		if len(params) > 0 {
+			fmt.Printf("Parameters: %s\n", FormatParams(params))
+		}
+
+		exprs, err := SplitExpressions(*curveStr, 3)
+		if err != nil {
+			log.Fatalf("Error parsing curve: %v", err)
+		}
+
+		report, err = GeneratePointsFromCurve(space, exprs[0], exprs[1], exprs[2], params, *tMin, *tMax, *samples)
+		if err != nil {
+			log.Fatalf("Error generating points from curve: %v", err)
+		}
+
+		fmt.Printf("Generated %d points from curve\n", len(space.Points))
+		if report.Failed() > 0 {
+			fmt.Println(report)
+		}
+	// Check if an ODE system is requested
+	} else if *odeStr != "" {
+		fmt.Printf("Solving ODE system: %s\n", *odeStr)
+		if len(params) > 0 {
+			fmt.Printf("Parameters: %s\n", FormatParams(params))
+		}
+
+		exprs, err := SplitExpressions(*odeStr, 3)
+		if err != nil {
+			log.Fatalf("Error parsing ODE system: %v", err)
+		}
+		initial, err := ParsePoint(*initStr)
+		if err != nil {
+			log.Fatalf("Error parsing initial state: %v", err)
+		}
+		method, err := ParseODEMethod(*methodStr)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Printf("Initial state: (%g, %g, %g), t=[%.2f, %.2f], method=%v, dt=%g\n", initial.X, initial.Y, initial.Z, *tMin, *tMax, method, *dt)
+
+		// A solution that can't be continued is still shown up to that point
+		if err := GeneratePointsFromODE(space, exprs[0], exprs[1], exprs[2], params, initial, method, *tMin, *tMax, *dt, *odeTolerance); err != nil {
+			if len(space.Points) == 0 {
+				log.Fatalf("Error solving ODE system: %v", err)
+			}
+			fmt.Printf("Solution stopped early: %v\n", err)
+		}
+
+		fmt.Printf("Generated %d points from ODE solution\n", len(space.Points))
+	// Check if a parametric surface is requested
+	} else if *surfaceStr != "" {
+		fmt.Printf("Generating points from surface: %s\n", *surfaceStr)
+		fmt.Printf("Range: u=[%.2f, %.2f], v=[%.2f, %.2f], samples=%dx%d\n", *uMin, *uMax, *vMin, *vMax, *uSamples, *vSamples)
+		if len(params) > 0 {
+			fmt.Printf("Parameters: %s\n", FormatParams(params))
+		}
+
+		exprs, err := SplitExpressions(*surfaceStr, 3)
+		if err != nil {
+			log.Fatalf("Error parsing surface: %v", err)
+		}
+
+		report, err = GeneratePointsFromSurface(space, exprs[0], exprs[1], exprs[2], params, *uMin, *uMax, *vMin, *vMax, *uSamples, *vSamples)
+		if err != nil {
+			log.Fatalf("Error generating points from surface: %v", err)
+		}
+
+		fmt.Printf("Generated %d points and %d faces from surface\n", len(space.Points), len(space.Faces))
+		if report.Failed() > 0 {
+			fmt.Println(report)
+		}
+	// Check if an implicit surface is requested
+	} else if *implicitStr != "" {
+		fmt.Printf("Generating mesh from implicit surface: %s = %g\n", *implicitStr, *iso)
+		fmt.Printf("Range: x=[%.2f, %.2f], y=[%.2f, %.2f], z=[%.2f, %.2f], resolution=%d\n", *xMin, *xMax, *yMin, *yMax, *zMin, *zMax, *resolution)
+		if len(params) > 0 {
+			fmt.Printf("Parameters: %s\n", FormatParams(params))
+		}
+
+		if err := GeneratePointsFromImplicit(space, *implicitStr, params, *xMin, *xMax, *yMin, *yMax, *zMin, *zMax, *resolution, *iso); err != nil {
+			log.Fatalf("Error generating implicit surface: %v", err)
+		}
+
+		fmt.Printf("Generated %d points and %d faces from implicit surface\n", len(space.Points), len(space.Faces))
+	// Check if a vector field is requested
+	} else if *vectorStr != "" {
+		fmt.Printf("Generating arrows from vector field: %s\n", *vectorStr)
+		fmt.Printf("Range: x=[%.2f, %.2f], y=[%.2f, %.2f], z=[%.2f, %.2f], step=%.2f\n", *xMin, *xMax, *yMin, *yMax, *zMin, *zMax, *step)
+		if len(params) > 0 {
+			fmt.Printf("Parameters: %s\n", FormatParams(params))
+		}
+
+		exprs, err := SplitExpressions(*vectorStr, 3)
+		if err != nil {
+			log.Fatalf("Error parsing vector field: %v", err)
+		}
+
+		if *streamlines {
+			// Seeds come from a CSV file or a lattice over the bounds
+			var seeds []Point3D
+			if *seedsFile != "" {
+				seedSpace := NewSpace3D()
+				if err := seedSpace.LoadPointsFromCSV(*seedsFile); err != nil {
+					log.Fatalf("Error loading seeds: %v", err)
+				}
+				seeds = seedSpace.Points
+			} else if *seedStep > 0 {
+				seeds = GridSeeds(*xMin, *xMax, *yMin, *yMax, *zMin, *zMax, *seedStep)
+			} else {
+				log.Fatalf("Seed step must be positive")
+			}
+			fmt.Printf("Streamlines: %d seeds, length=%g, tolerance=%g\n", len(seeds), *lineLength, *lineTolerance)
+
+			if err := GenerateStreamlines(space, exprs[0], exprs[1], exprs[2], params, seeds, *xMin, *xMax, *yMin, *yMax, *zMin, *zMax, *step, *lineTolerance, *lineLength); err != nil {
+				log.Fatalf("Error generating streamlines: %v", err)
+			}
+
+			fmt.Printf("Generated %d streamlines with %d points from vector field\n", len(space.Polylines), len(space.Points))
+		} else {
+			report, err = GenerateVectorField(space, exprs[0], exprs[1], exprs[2], params, *xMin, *xMax, *yMin, *yMax, *zMin, *zMax, *step)
+			if err != nil {
+				log.Fatalf("Error generating vector field: %v", err)
+			}
+
+			fmt.Printf("Generated %d arrows from vector field\n", len(space.Points))
+			if report.Failed() > 0 {
+				fmt.Println(report)
+			}
+		}
+	// Load points from a file if provided, in the format given by its
+	// extension or its first bytes
+	} else if *inputFile != "" {
+		format, err := FileFormatOf(*inputFile)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if format.Name == "csv" {
+			fmt.Printf("Loading points from CSV file: %s\n", *inputFile)
+			mapping, err := ParseColumnMapping(*colsStr)
+			if err != nil {
+				log.Fatalf("Error parsing columns: %v", err)
+			}
+			badRows, err := ParseBadRowPolicy(*badRowsStr)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			if *decimate < 1 {
+				log.Fatalf("Error: decimation must be at least 1")
+			}
+			report, err := space.LoadPointsFromCSVFile(*inputFile, CSVOptions{Columns: mapping, Decimate: *decimate, BadRows: badRows})
+			if err != nil {
+				log.Fatalf("Error loading CSV file: %v", err)
+			}
+			fmt.Printf("Loaded CSV file: %v\n", report)
+			for _, row := range report.BadRows {
+				fmt.Printf("Line %d: %v: %s\n", row.Line, row.Err, strings.Join(row.Fields, ","))
+			}
+		} else {
+			fmt.Printf("Loading %s file: %s\n", strings.ToUpper(format.Name), *inputFile)
+			if err := format.Load(space, *inputFile); err != nil {
+				log.Fatalf("Error loading %s file: %v", strings.ToUpper(format.Name), err)
+			}
+			fmt.Printf("Loaded %d points and %d faces\n", len(space.Points), len(space.Faces))
+		}
+		for _, attr := range space.Attributes {
+			fmt.Printf("Attribute: %s (%v)\n", attr.Name, attr.Kind)
 		}
-		fmt.Printf("Loaded %d points from CSV\n", len(space.Points))
 	} else {
-		// Add some default points if no CSV provided
-		fmt.Println("No function or CSV file specified, using default points")
+		// Add some default points if no file provided
+		fmt.Println("No function, curve, ODE system, surface, implicit surface, vector field or input file specified, using default points")
 		p1 := NewPoint3D(0, 0, 0)
 		p2 := NewPoint3D(3, 4, 0)
 		p3 := NewPoint3D(3, 4, 5)
@@ -115,7 +398,76 @@ func main() {
 		fmt.Printf("Manhattan distance p1 to p3: %.2f\n", ManhattanDistance(p1, p3))
 	}
 
+	// Save the points if requested
+	if *outputFile != "" {
+		saved := space
+		if *solidify {
+			saved = space.Solidify(solidBase(space))
+		}
+		if err := saved.SaveFile(*outputFile, FormatOptions{PLY: plyFormat, STL: stlFormat, PCD: pcdFormat}); err != nil {
+			log.Fatalf("Error saving points: %v", err)
+		}
+		fmt.Printf("Saved points to %s\n", *outputFile)
+	}
+
 	// Create and run the visualizer
 	visualizer := NewVisualizer(space)
+
+	// Generated plots are shown with Z up unless another axis is requested
+	if *functionStr != "" || *curveStr != "" || *odeStr != "" || *surfaceStr != "" || *implicitStr != "" || *vectorStr != "" {
+		visualizer.SetUpAxis(UpZ)
+	}
+	if *vectorStr != "" {
+		visualizer.SetArrowLength(*step)
+	}
+	if *upAxisStr != "" {
+		upAxis, err := ParseUpAxis(*upAxisStr)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		visualizer.SetUpAxis(upAxis)
+	}
+	if *functionStr != "" {
+		visualizer.SetFunction(*functionStr, params, *xMin, *xMax, *yMin, *yMax, *step)
+		if *complexPlot {
+			visualizer.SetComplex()
+		}
+		if *adaptive {
+			visualizer.SetAdaptive(*tolerance, *maxPoints)
+		}
+		visualizer.ShowGradient(*gradient)
+		visualizer.SetEvaluationReport(report)
+		visualizer.ShowGaps(*gaps)
+	}
+	attributeNames := []string{*sizeBy, *labelBy}
+	if *colorBy != ColorByHeight && *colorBy != ColorByDistance {
+		attributeNames = append(attributeNames, *colorBy)
+	}
+	for _, name := range attributeNames {
+		if name != "" && space.Attribute(name) == nil {
+			log.Fatalf("Error: the points have no attribute %s", name)
+		}
+	}
+	if attr := space.Attribute(*sizeBy); attr != nil && !attr.Numeric() {
+		log.Fatalf("Error: attribute %s isn't numeric, so it can't size points", *sizeBy)
+	}
+	visualizer.ColorBy(*colorBy)
+	colormap, err := ColormapByName(*colormapStr)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	visualizer.SetColormap(colormap)
+	colorRange := []float64{math.NaN(), math.NaN()}
+	for i, expr := range []string{*colorMinStr, *colorMaxStr} {
+		if expr == "" {
+			continue
+		}
+		if colorRange[i], err = EvaluateConstant(expr); err != nil {
+			log.Fatalf("Error: invalid color range: %v", err)
+		}
+	}
+	visualizer.SetColorRange(colorRange[0], colorRange[1])
+	visualizer.SizeBy(*sizeBy)
+	visualizer.LabelBy(*labelBy)
 	visualizer.Run()
 }
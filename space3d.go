@@ -3,10 +3,12 @@ package main
 import (
 	"encoding/csv"
 	"fmt"
+	"image/color"
 	"io"
 	"math"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Point3D represents a point in 3D space
@@ -19,9 +21,38 @@ func NewPoint3D(x, y, z float64) Point3D {
 	return Point3D{X: x, Y: y, Z: z}
 }
 
+// Polyline is a sequence of indices into Space3D.Points that are drawn
+// connected in order
+type Polyline []int
+
+// Face is a polygon given by indices into Space3D.Points, in order around
+// its boundary
+type Face []int
+
 // Space3D represents a collection of points in 3D space
 type Space3D struct {
-	Points []Point3D
+	Points    []Point3D
+	Polylines []Polyline
+	Faces     []Face
+
+	// Normals holds the unit surface normal at each point, where known.
+	// It may be shorter than Points, and a missing or zero entry means the
+	// normal of that point is unknown.
+	Normals []Point3D
+
+	// Colors holds the color of each point, where one is given. Like
+	// Normals it may be shorter than Points, and a fully transparent entry
+	// means the point is drawn in the default color.
+	Colors []color.RGBA
+
+	// Vectors holds the vector of a vector field at each point, drawn as an
+	// arrow from the point. Like Normals it may be shorter than Points, and
+	// a missing or zero entry means the point has no arrow.
+	Vectors []Point3D
+
+	// Attributes holds further values of the points, such as the columns of
+	// a CSV file other than the coordinates, in the order they were added
+	Attributes []Attribute
 }
 
 // NewSpace3D creates a new empty 3D space
@@ -36,6 +67,113 @@ func (s *Space3D) AddPoint(p Point3D) {
 	s.Points = append(s.Points, p)
 }
 
+// AddPolyline adds points to the 3D space and connects them in order
+func (s *Space3D) AddPolyline(points []Point3D) {
+	line := make(Polyline, len(points))
+	for i, p := range points {
+		line[i] = len(s.Points)
+		s.AddPoint(p)
+	}
+	s.Polylines = append(s.Polylines, line)
+}
+
+// AddGrid adds a rows x cols grid of points, given in row-major order, and
+// connects neighbouring points with quad faces. Points whose entry in valid is
+// false are left out along with the faces touching them; valid may be nil when
+// every point is valid.
+func (s *Space3D) AddGrid(rows, cols int, points []Point3D, valid []bool) {
+	index := make([]int, len(points))
+	for i, p := range points {
+		if valid != nil && !valid[i] {
+			index[i] = -1
+			continue
+		}
+		index[i] = len(s.Points)
+		s.AddPoint(p)
+	}
+
+	for r := 0; r+1 < rows; r++ {
+		for c := 0; c+1 < cols; c++ {
+			face := Face{
+				index[r*cols+c],
+				index[r*cols+c+1],
+				index[(r+1)*cols+c+1],
+				index[(r+1)*cols+c],
+			}
+			if face[0] < 0 || face[1] < 0 || face[2] < 0 || face[3] < 0 {
+				continue
+			}
+			s.Faces = append(s.Faces, face)
+		}
+	}
+}
+
+// AddMesh adds points to the 3D space along with faces whose indices refer to
+// positions in the given points slice
+func (s *Space3D) AddMesh(points []Point3D, faces []Face) {
+	offset := len(s.Points)
+	s.Points = append(s.Points, points...)
+
+	for _, face := range faces {
+		shifted := make(Face, len(face))
+		for i, index := range face {
+			shifted[i] = index + offset
+		}
+		s.Faces = append(s.Faces, shifted)
+	}
+}
+
+// SetNormal records the surface normal at the point with the given index
+func (s *Space3D) SetNormal(index int, normal Point3D) {
+	for len(s.Normals) <= index {
+		s.Normals = append(s.Normals, Point3D{})
+	}
+	s.Normals[index] = normal
+}
+
+// Normal returns the surface normal at the point with the given index and
+// whether it is known
+func (s *Space3D) Normal(index int) (Point3D, bool) {
+	if index >= len(s.Normals) || s.Normals[index] == (Point3D{}) {
+		return Point3D{}, false
+	}
+	return s.Normals[index], true
+}
+
+// SetColor sets the color of the point with the given index
+func (s *Space3D) SetColor(index int, c color.RGBA) {
+	for len(s.Colors) <= index {
+		s.Colors = append(s.Colors, color.RGBA{})
+	}
+	s.Colors[index] = c
+}
+
+// Color returns the color of the point with the given index and whether it
+// has one
+func (s *Space3D) Color(index int) (color.RGBA, bool) {
+	if index >= len(s.Colors) || s.Colors[index].A == 0 {
+		return color.RGBA{}, false
+	}
+	return s.Colors[index], true
+}
+
+// SetVector sets the field vector at the point with the given index
+func (s *Space3D) SetVector(index int, v Point3D) {
+	for len(s.Vectors) <= index {
+		s.Vectors = append(s.Vectors, Point3D{})
+	}
+	s.Vectors[index] = v
+}
+
+// Vector returns the field vector at the point with the given index and
+// whether it has one
+func (s *Space3D) Vector(index int) (Point3D, bool) {
+	if index >= len(s.Vectors) || s.Vectors[index] == (Point3D{}) {
+		return Point3D{}, false
+	}
+	return s.Vectors[index], true
+}
+
 // Distance calculates the Euclidean distance between two 3D points
 func Distance(p1, p2 Point3D) float64 {
 	return math.Sqrt(
@@ -54,90 +192,177 @@ func (p1 Point3D) DistanceTo(p2 Point3D) float64 {
 	return Distance(p1, p2)
 }
 
-// LoadPointsFromCSV loads 3D points from a CSV file
-// The CSV should have at least 3 columns for X, Y, Z coordinates
-func (s *Space3D) LoadPointsFromCSV(filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open CSV file: %w", err)
-	}
-	defer file.Close()
+// ColumnMapping says which CSV columns hold the X, Y and Z coordinates. Each
+// is a column name from the header or a column index counting from 0. An
+// empty entry picks the column named like the coordinate, such as "x" or "X",
+// or failing that the first, second or third column.
+type ColumnMapping struct {
+	X, Y, Z string
+}
 
-	reader := csv.NewReader(file)
+// ParseColumnMapping parses a column mapping like "x=lon,y=lat,z=alt" or
+// "x=2,y=1,z=3". Coordinates that aren't mentioned keep the default column.
+func ParseColumnMapping(s string) (ColumnMapping, error) {
+	var mapping ColumnMapping
+	for _, assignment := range strings.Split(s, ",") {
+		assignment = strings.TrimSpace(assignment)
+		if assignment == "" {
+			continue
+		}
 
-	// Skip header if exists (optional)
-	// If your CSV has no header, comment this line out
-	_, err = reader.Read()
-	if err != nil {
-		return fmt.Errorf("failed to read CSV header: %w", err)
-	}
+		axis, column, ok := strings.Cut(assignment, "=")
+		column = strings.TrimSpace(column)
+		if !ok || column == "" {
+			return mapping, fmt.Errorf("invalid column mapping %q: expected axis=column", assignment)
+		}
 
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+		var target *string
+		switch strings.ToLower(strings.TrimSpace(axis)) {
+		case "x":
+			target = &mapping.X
+		case "y":
+			target = &mapping.Y
+		case "z":
+			target = &mapping.Z
+		default:
+			return mapping, fmt.Errorf("invalid column mapping %q: axis must be x, y or z", assignment)
 		}
-		if err != nil {
-			return fmt.Errorf("error reading CSV record: %w", err)
+		if *target != "" {
+			return mapping, fmt.Errorf("column of %s given more than once", strings.TrimSpace(axis))
 		}
+		*target = column
+	}
+	return mapping, nil
+}
 
-		// Ensure we have at least 3 columns for X, Y, Z
-		if len(record) < 3 {
-			return fmt.Errorf("invalid CSV format: need at least 3 columns for X, Y, Z coordinates")
+// isCSVHeader reports whether the first record of a CSV file is a header,
+// which it is when a field the coordinates would be read from without one
+// isn't a number, or a coordinate column is given by name. Other columns
+// may hold text in every row.
+func isCSVHeader(record []string, mapping ColumnMapping) bool {
+	columns, err := resolveColumns(columnNames(record, false), mapping)
+	if err != nil {
+		return true
+	}
+	for _, i := range columns {
+		if _, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64); err != nil {
+			return true
 		}
+	}
+	return false
+}
 
-		// Parse the coordinates
-		x, err := strconv.ParseFloat(record[0], 64)
-		if err != nil {
-			return fmt.Errorf("invalid X coordinate: %w", err)
+// columnNames returns the name of each column: its header field, or "col"
+// followed by its index when the file has no header
+func columnNames(first []string, hasHeader bool) []string {
+	names := make([]string, len(first))
+	for i, field := range first {
+		if hasHeader {
+			names[i] = strings.TrimSpace(field)
+		} else {
+			names[i] = "col" + strconv.Itoa(i)
 		}
+	}
+	return names
+}
 
-		y, err := strconv.ParseFloat(record[1], 64)
-		if err != nil {
-			return fmt.Errorf("invalid Y coordinate: %w", err)
-		}
+// resolveColumns finds the index of the X, Y and Z columns given by mapping
+func resolveColumns(names []string, mapping ColumnMapping) ([3]int, error) {
+	var columns [3]int
+	for axis, column := range []string{mapping.X, mapping.Y, mapping.Z} {
+		axisName := string("XYZ"[axis])
+		index := -1
 
-		z, err := strconv.ParseFloat(record[2], 64)
-		if err != nil {
-			return fmt.Errorf("invalid Z coordinate: %w", err)
+		if column == "" {
+			// The column named like the axis, or the axis's position
+			for i, name := range names {
+				if strings.EqualFold(name, axisName) {
+					index = i
+				}
+			}
+			if index < 0 {
+				index = axis
+			}
+		} else if i, err := strconv.Atoi(column); err == nil {
+			index = i
+		} else {
+			for i, name := range names {
+				if name == column {
+					index = i
+				}
+			}
+			if index < 0 {
+				return columns, fmt.Errorf("no column named %q for %s", column, axisName)
+			}
 		}
 
-		// Add the point to our space
-		s.AddPoint(NewPoint3D(x, y, z))
+		if index < 0 || index >= len(names) {
+			return columns, fmt.Errorf("invalid CSV format: no column %d for %s, the file has %d columns", index, axisName, len(names))
+		}
+		columns[axis] = index
 	}
+	return columns, nil
+}
 
-	return nil
+// LoadPointsFromCSV loads 3D points from a CSV file, taking the coordinates
+// from the default columns described for ColumnMapping
+func (s *Space3D) LoadPointsFromCSV(filePath string) error {
+	return s.LoadPointsFromCSVColumns(filePath, ColumnMapping{})
+}
+
+// LoadPointsFromCSVColumns loads 3D points from a CSV file as described for
+// ReadPointsFromCSV, taking the coordinates from the columns given by mapping
+// and stopping at the first bad row
+func (s *Space3D) LoadPointsFromCSVColumns(filePath string, mapping ColumnMapping) error {
+	_, err := s.LoadPointsFromCSVFile(filePath, CSVOptions{Columns: mapping})
+	return err
 }
 
-// SavePointsToCSV saves all points in the space to a CSV file
+// SavePointsToCSV saves all points in the space to a CSV file as described
+// for WritePointsToCSV
 func (s *Space3D) SavePointsToCSV(filePath string) error {
 	file, err := os.Create(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to create CSV file: %w", err)
 	}
 	defer file.Close()
+	return s.WritePointsToCSV(file)
+}
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// WritePointsToCSV writes all points in the space as CSV data, with a column
+// for each attribute
+func (s *Space3D) WritePointsToCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
 
-	// Write header
-	err = writer.Write([]string{"X", "Y", "Z"})
+	// Write header, followed by any attributes
+	header := []string{"X", "Y", "Z"}
+	for _, attr := range s.Attributes {
+		header = append(header, attr.Name)
+	}
+	err := writer.Write(header)
 	if err != nil {
 		return fmt.Errorf("error writing CSV header: %w", err)
 	}
 
 	// Write points
-	for _, point := range s.Points {
+	for i, point := range s.Points {
 		record := []string{
 			strconv.FormatFloat(point.X, 'f', -1, 64),
 			strconv.FormatFloat(point.Y, 'f', -1, 64),
 			strconv.FormatFloat(point.Z, 'f', -1, 64),
 		}
+		for j := range s.Attributes {
+			record = append(record, s.Attributes[j].Text(i))
+		}
 		err := writer.Write(record)
 		if err != nil {
 			return fmt.Errorf("error writing point to CSV: %w", err)
 		}
 	}
 
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing CSV file: %w", err)
+	}
 	return nil
 }
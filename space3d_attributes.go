@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// AttributeKind is the type of the values of an attribute
+type AttributeKind int
+
+const (
+	// AttributeFloat holds real numbers, such as intensities
+	AttributeFloat AttributeKind = iota
+	// AttributeInt holds whole numbers, such as class codes
+	AttributeInt
+	// AttributeString holds text, such as labels
+	AttributeString
+	// AttributeRGB holds colors
+	AttributeRGB
+)
+
+// attributeKindNames are the names of the attribute kinds
+var attributeKindNames = []string{"float", "int", "string", "rgb"}
+
+// String returns the name of the kind
+func (k AttributeKind) String() string {
+	return attributeKindNames[k]
+}
+
+// Attribute is a named channel of values, one for each point, such as the
+// columns of a CSV file other than the coordinates. Only the slice for the
+// attribute's kind is used. It may be shorter than the points, and a missing
+// entry means the point has no value; gaps filled by SetAttribute hold NaN
+// for floats and the zero value otherwise.
+type Attribute struct {
+	Name string
+	Kind AttributeKind
+
+	Floats  []float64
+	Ints    []int64
+	Strings []string
+	Colors  []color.RGBA
+}
+
+// Len returns the number of values of the attribute
+func (a *Attribute) Len() int {
+	switch a.Kind {
+	case AttributeFloat:
+		return len(a.Floats)
+	case AttributeInt:
+		return len(a.Ints)
+	case AttributeString:
+		return len(a.Strings)
+	default:
+		return len(a.Colors)
+	}
+}
+
+// Numeric reports whether the attribute's values are numbers
+func (a *Attribute) Numeric() bool {
+	return a.Kind == AttributeFloat || a.Kind == AttributeInt
+}
+
+// Value returns the numeric value of the point with the given index, and
+// whether it has one
+func (a *Attribute) Value(index int) (float64, bool) {
+	if index >= a.Len() {
+		return 0, false
+	}
+	switch a.Kind {
+	case AttributeFloat:
+		return a.Floats[index], !math.IsNaN(a.Floats[index])
+	case AttributeInt:
+		return float64(a.Ints[index]), true
+	default:
+		return 0, false
+	}
+}
+
+// Text returns the value of the point with the given index as it is written
+// to CSV files, or "" if it has none. Colors are written as #rrggbb.
+func (a *Attribute) Text(index int) string {
+	if index >= a.Len() {
+		return ""
+	}
+	switch a.Kind {
+	case AttributeFloat:
+		if math.IsNaN(a.Floats[index]) {
+			return ""
+		}
+		return strconv.FormatFloat(a.Floats[index], 'f', -1, 64)
+	case AttributeInt:
+		return strconv.FormatInt(a.Ints[index], 10)
+	case AttributeString:
+		return a.Strings[index]
+	default:
+		c := a.Colors[index]
+		if c.A == 0 {
+			return ""
+		}
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+}
+
+// Range returns the smallest and largest numeric values of the attribute, or
+// NaNs if it has none
+func (a *Attribute) Range() (float64, float64) {
+	lo, hi := math.NaN(), math.NaN()
+	for i := 0; i < a.Len(); i++ {
+		if v, ok := a.Value(i); ok {
+			if !(v >= lo) {
+				lo = v
+			}
+			if !(v <= hi) {
+				hi = v
+			}
+		}
+	}
+	return lo, hi
+}
+
+// parseHexColor parses a color written as #rrggbb
+func parseHexColor(s string) (color.RGBA, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	value, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(value >> 16), uint8(value >> 8), uint8(value), 255}, true
+}
+
+// ParseAttribute creates an attribute from the text of its values, choosing
+// the narrowest kind that fits all of them: int, float, rgb for colors written
+// as #rrggbb, or else string. Empty values are missing and don't otherwise
+// affect the kind, but whole numbers with gaps are floats, so the gaps can be
+// told apart, and an attribute with no values at all is a string.
+func ParseAttribute(name string, values []string) Attribute {
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.TrimSpace(v)
+	}
+
+	present, complete := false, true
+	for _, v := range trimmed {
+		present = present || v != ""
+		complete = complete && v != ""
+	}
+	fits := func(parse func(string) bool) bool {
+		for _, v := range trimmed {
+			if v != "" && !parse(v) {
+				return false
+			}
+		}
+		return present
+	}
+	isInt := func(s string) bool { _, err := strconv.ParseInt(s, 10, 64); return err == nil }
+	isFloat := func(s string) bool { _, err := strconv.ParseFloat(s, 64); return err == nil }
+	isColor := func(s string) bool { _, ok := parseHexColor(s); return ok }
+
+	attr := Attribute{Name: name}
+	switch {
+	case fits(isInt) && complete:
+		attr.Kind = AttributeInt
+		attr.Ints = make([]int64, len(trimmed))
+		for i, v := range trimmed {
+			attr.Ints[i], _ = strconv.ParseInt(v, 10, 64)
+		}
+	case fits(isFloat):
+		attr.Kind = AttributeFloat
+		attr.Floats = make([]float64, len(trimmed))
+		for i, v := range trimmed {
+			attr.Floats[i] = math.NaN()
+			if v != "" {
+				attr.Floats[i], _ = strconv.ParseFloat(v, 64)
+			}
+		}
+	case fits(isColor):
+		attr.Kind = AttributeRGB
+		attr.Colors = make([]color.RGBA, len(trimmed))
+		for i, v := range trimmed {
+			attr.Colors[i], _ = parseHexColor(v)
+		}
+	default:
+		attr.Kind = AttributeString
+		attr.Strings = values
+	}
+	return attr
+}
+
+// pad extends the attribute's values with missing entries up to length n
+func (a *Attribute) pad(n int) {
+	for a.Len() < n {
+		switch a.Kind {
+		case AttributeFloat:
+			a.Floats = append(a.Floats, math.NaN())
+		case AttributeInt:
+			a.Ints = append(a.Ints, 0)
+		case AttributeString:
+			a.Strings = append(a.Strings, "")
+		default:
+			a.Colors = append(a.Colors, color.RGBA{})
+		}
+	}
+}
+
+// Attribute returns the attribute with the given name, or nil if the space
+// doesn't have it
+func (s *Space3D) Attribute(name string) *Attribute {
+	for i := range s.Attributes {
+		if s.Attributes[i].Name == name {
+			return &s.Attributes[i]
+		}
+	}
+	return nil
+}
+
+// SetAttribute gives the points starting at index start the values of attr.
+// If the space has no attribute of that name it is added; otherwise the
+// values are added to the existing attribute, which must be of the same kind.
+func (s *Space3D) SetAttribute(start int, attr Attribute) error {
+	existing := s.Attribute(attr.Name)
+	if existing == nil {
+		s.Attributes = append(s.Attributes, Attribute{Name: attr.Name, Kind: attr.Kind})
+		existing = &s.Attributes[len(s.Attributes)-1]
+	}
+	if existing.Kind != attr.Kind {
+		return fmt.Errorf("attribute %s holds %v values, not %v", attr.Name, existing.Kind, attr.Kind)
+	}
+
+	existing.pad(start + attr.Len())
+	switch attr.Kind {
+	case AttributeFloat:
+		copy(existing.Floats[start:], attr.Floats)
+	case AttributeInt:
+		copy(existing.Ints[start:], attr.Ints)
+	case AttributeString:
+		copy(existing.Strings[start:], attr.Strings)
+	default:
+		copy(existing.Colors[start:], attr.Colors)
+	}
+	return nil
+}
+
+// rgbColumns finds columns holding the red, green and blue parts of a color,
+// named r, g and b or red, green and blue in any case. It returns their
+// indices, or nil if there aren't any.
+func rgbColumns(names []string) []int {
+	for _, parts := range [][3]string{{"r", "g", "b"}, {"red", "green", "blue"}} {
+		columns := []int{-1, -1, -1}
+		for i, name := range names {
+			for j, part := range parts {
+				if strings.EqualFold(name, part) {
+					columns[j] = i
+				}
+			}
+		}
+		if columns[0] >= 0 && columns[1] >= 0 && columns[2] >= 0 {
+			return columns
+		}
+	}
+	return nil
+}
+
+// combineRGB makes an rgb attribute of integer red, green and blue channels
+// from 0 to 255. It reports false if the channels don't all fit.
+func combineRGB(name string, r, g, b Attribute) (Attribute, bool) {
+	channels := []Attribute{r, g, b}
+	for _, c := range channels {
+		if c.Kind != AttributeInt {
+			return Attribute{}, false
+		}
+		for _, v := range c.Ints {
+			if v < 0 || v > 255 {
+				return Attribute{}, false
+			}
+		}
+	}
+
+	attr := Attribute{Name: name, Kind: AttributeRGB, Colors: make([]color.RGBA, len(r.Ints))}
+	for i := range attr.Colors {
+		attr.Colors[i] = color.RGBA{uint8(r.Ints[i]), uint8(g.Ints[i]), uint8(b.Ints[i]), 255}
+	}
+	return attr, true
+}
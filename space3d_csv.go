@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BadRowPolicy says what loading a CSV file does with rows that can't be
+// read as points, such as rows with a coordinate that isn't a number
+type BadRowPolicy int
+
+const (
+	// BadRowFail stops loading with an error at the first bad row
+	BadRowFail BadRowPolicy = iota
+	// BadRowSkip leaves bad rows out, noting their line numbers
+	BadRowSkip
+	// BadRowCollect leaves bad rows out like BadRowSkip, and also keeps their
+	// fields and what was wrong with them
+	BadRowCollect
+)
+
+// badRowPolicyNames are the names of the policies accepted by ParseBadRowPolicy
+var badRowPolicyNames = []string{"fail", "skip", "collect"}
+
+// ParseBadRowPolicy parses a policy name: fail, skip or collect
+func ParseBadRowPolicy(s string) (BadRowPolicy, error) {
+	for i, name := range badRowPolicyNames {
+		if strings.EqualFold(s, name) {
+			return BadRowPolicy(i), nil
+		}
+	}
+	return BadRowFail, fmt.Errorf("invalid bad row policy %q: expected %s", s, strings.Join(badRowPolicyNames, ", "))
+}
+
+// String returns the name of the policy
+func (p BadRowPolicy) String() string {
+	return badRowPolicyNames[p]
+}
+
+// CSVOptions control how points are read from a CSV file
+type CSVOptions struct {
+	// Columns holding the coordinates
+	Columns ColumnMapping
+
+	// Keep only every Decimate-th good row, starting with the first. 0 and
+	// 1 keep them all.
+	Decimate int
+
+	// What to do with rows that can't be read as points
+	BadRows BadRowPolicy
+
+	// Size of the input in bytes, or 0 if unknown. When known, the points
+	// are allocated up front from the size of the first rows.
+	Size int64
+}
+
+// CSVBadRow is a row left out of the points, kept with BadRowCollect
+type CSVBadRow struct {
+	Line   int
+	Fields []string
+	Err    error
+}
+
+// CSVReport describes what loading a CSV file did
+type CSVReport struct {
+	// Rows is the number of rows read after the header, good or bad
+	Rows int
+	// Points is the number of points added
+	Points int
+	// Skipped holds the line number of each bad row left out, counting from 1
+	Skipped []int
+	// BadRows holds the bad rows themselves, with BadRowCollect
+	BadRows []CSVBadRow
+}
+
+// csvReportMaxLines bounds the line numbers listed by CSVReport.String
+const csvReportMaxLines = 10
+
+// String summarizes the report, listing the first few skipped lines
+func (r *CSVReport) String() string {
+	summary := fmt.Sprintf("read %d rows, added %d points", r.Rows, r.Points)
+	if len(r.Skipped) == 0 {
+		return summary
+	}
+
+	lines := make([]string, 0, csvReportMaxLines)
+	for i, line := range r.Skipped {
+		if i == csvReportMaxLines {
+			lines = append(lines, fmt.Sprintf("and %d more", len(r.Skipped)-i))
+			break
+		}
+		lines = append(lines, strconv.Itoa(line))
+	}
+	return fmt.Sprintf("%s, skipped %d bad rows at lines %s", summary, len(r.Skipped), strings.Join(lines, ", "))
+}
+
+// csvPresizeRows is the number of rows whose length is averaged to estimate
+// the number of rows in an input of known size
+const csvPresizeRows = 64
+
+// attributeBuilder collects the values of an attribute as rows are read. The
+// values are kept as integers while they all are, then as floats, and as
+// text only once one isn't a number, so numeric columns of large files don't
+// hold a string for every row.
+type attributeBuilder struct {
+	name    string
+	kind    AttributeKind
+	present bool
+
+	ints   []int64
+	floats []float64
+	texts  []string
+}
+
+// add appends the next value, in its text form
+func (b *attributeBuilder) add(value string) {
+	trimmed := strings.TrimSpace(value)
+	b.present = b.present || trimmed != ""
+	if b.kind != AttributeString {
+		if i, err := strconv.ParseInt(trimmed, 10, 64); err == nil && b.kind == AttributeInt {
+			b.ints = append(b.ints, i)
+			return
+		}
+		if f, err := strconv.ParseFloat(trimmed, 64); err == nil || trimmed == "" {
+			if trimmed == "" {
+				f = math.NaN()
+			}
+			b.toFloat()
+			b.floats = append(b.floats, f)
+			return
+		}
+		b.toText()
+	}
+	// Fields of a record share their memory, so keep a copy
+	b.texts = append(b.texts, strings.Clone(value))
+}
+
+// toFloat switches integer values to floats
+func (b *attributeBuilder) toFloat() {
+	if b.kind != AttributeInt {
+		return
+	}
+	b.floats = make([]float64, len(b.ints), cap(b.ints))
+	for i, v := range b.ints {
+		b.floats[i] = float64(v)
+	}
+	b.kind, b.ints = AttributeFloat, nil
+}
+
+// toText switches numeric values to text, written in their shortest form
+func (b *attributeBuilder) toText() {
+	b.texts = make([]string, 0, max(cap(b.ints), cap(b.floats)))
+	for _, v := range b.ints {
+		b.texts = append(b.texts, strconv.FormatInt(v, 10))
+	}
+	for _, v := range b.floats {
+		text := ""
+		if !math.IsNaN(v) {
+			text = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		b.texts = append(b.texts, text)
+	}
+	b.kind, b.ints, b.floats = AttributeString, nil, nil
+}
+
+// attribute returns the collected attribute, of the kind ParseAttribute
+// would choose for the values
+func (b *attributeBuilder) attribute() Attribute {
+	if !b.present {
+		b.toText()
+	}
+	switch b.kind {
+	case AttributeInt:
+		return Attribute{Name: b.name, Kind: AttributeInt, Ints: b.ints}
+	case AttributeFloat:
+		return Attribute{Name: b.name, Kind: AttributeFloat, Floats: b.floats}
+	default:
+		return ParseAttribute(b.name, b.texts)
+	}
+}
+
+// LoadPointsFromCSVFile loads 3D points from a CSV file as described for
+// ReadPointsFromCSV, allocating the points up front from the file's size
+func (s *Space3D) LoadPointsFromCSVFile(filePath string, options CSVOptions) (*CSVReport, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && options.Size == 0 {
+		options.Size = info.Size()
+	}
+	return s.ReadPointsFromCSV(file, options)
+}
+
+// ReadPointsFromCSV reads 3D points from CSV data, one row at a time, taking
+// the coordinates from the columns given by options. The first row is a
+// header when any of its coordinates isn't a number, or when options give a
+// coordinate column by name rather than index; otherwise every row is a
+// point, even when other columns hold text. Columns other than the
+// coordinates are kept as attributes of the points, named by the header or,
+// without one, "col" and the column index, with their kinds chosen as by
+// ParseAttribute. Integer red, green and blue columns, named r, g and b or
+// red, green and blue, are combined into an rgb attribute.
+//
+// Rows that are malformed or lack a coordinate are handled by the bad row
+// policy. Rows missing other columns are kept, with those attributes missing.
+// When loading fails, the space is left as it was.
+func (s *Space3D) ReadPointsFromCSV(r io.Reader, options CSVOptions) (*CSVReport, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	report := &CSVReport{}
+
+	first, err := reader.Read()
+	if err == io.EOF {
+		return report, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV record: %w", err)
+	}
+
+	hasHeader := isCSVHeader(first, options.Columns)
+	names := columnNames(first, hasHeader)
+	columns, err := resolveColumns(names, options.Columns)
+	if err != nil {
+		return nil, err
+	}
+	isCoordinate := func(i int) bool {
+		return i == columns[0] || i == columns[1] || i == columns[2]
+	}
+	builders := make([]*attributeBuilder, len(names))
+	for i, name := range names {
+		if !isCoordinate(i) {
+			builders[i] = &attributeBuilder{name: name, kind: AttributeInt}
+		}
+	}
+
+	start := len(s.Points)
+	fail := func(err error) (*CSVReport, error) {
+		s.Points = s.Points[:start]
+		return nil, err
+	}
+
+	// Without a header the first record is already a point
+	record := first
+	headerEnd := int64(0)
+	if hasHeader {
+		headerEnd = reader.InputOffset()
+		record, err = reader.Read()
+	}
+	for ; err != io.EOF; record, err = reader.Read() {
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return fail(fmt.Errorf("error reading CSV record: %w", err))
+		}
+		report.Rows++
+
+		// Parse the coordinates
+		var coords [3]float64
+		line := 0
+		if err != nil {
+			line = parseErr.StartLine
+			err = fmt.Errorf("invalid CSV format: %w", parseErr.Err)
+		} else {
+			line, _ = reader.FieldPos(0)
+			for axis, column := range columns {
+				if column >= len(record) {
+					err = fmt.Errorf("invalid CSV format: need a %c coordinate in column %d", "XYZ"[axis], column)
+					break
+				}
+				value, parseErr := strconv.ParseFloat(strings.TrimSpace(record[column]), 64)
+				if parseErr != nil {
+					err = fmt.Errorf("invalid %c coordinate: %w", "XYZ"[axis], parseErr)
+					break
+				}
+				coords[axis] = value
+			}
+		}
+
+		if err != nil {
+			switch options.BadRows {
+			case BadRowFail:
+				return fail(fmt.Errorf("line %d: %w", line, err))
+			case BadRowCollect:
+				report.BadRows = append(report.BadRows, CSVBadRow{Line: line, Fields: append([]string(nil), record...), Err: err})
+			}
+			report.Skipped = append(report.Skipped, line)
+			continue
+		}
+
+		// Once the first rows give their average length, allocate for
+		// the rest of the input
+		good := report.Rows - len(report.Skipped)
+		if good == csvPresizeRows && options.Size > 0 {
+			rowBytes := float64(reader.InputOffset()-headerEnd) / float64(report.Rows)
+			estimate := int(float64(options.Size-headerEnd)/rowBytes) / max(options.Decimate, 1)
+			s.reservePoints(estimate - len(s.Points) + start)
+			for _, b := range builders {
+				if b != nil && b.kind == AttributeInt && cap(b.ints) < estimate {
+					b.ints = append(make([]int64, 0, estimate), b.ints...)
+				} else if b != nil && b.kind == AttributeFloat && cap(b.floats) < estimate {
+					b.floats = append(make([]float64, 0, estimate), b.floats...)
+				}
+			}
+		}
+
+		if options.Decimate > 1 && (good-1)%options.Decimate != 0 {
+			continue
+		}
+
+		// Add the point to our space, keeping the rest of the record
+		s.AddPoint(NewPoint3D(coords[0], coords[1], coords[2]))
+		for i, b := range builders {
+			if b == nil {
+				continue
+			}
+			field := ""
+			if i < len(record) {
+				field = record[i]
+			}
+			b.add(field)
+		}
+	}
+	report.Points = len(s.Points) - start
+
+	// The other columns become attributes, with red, green and blue
+	// columns combined into colors
+	attrs := make(map[int]Attribute)
+	for i, b := range builders {
+		if b != nil {
+			attrs[i] = b.attribute()
+		}
+	}
+	rgb := rgbColumns(names)
+	if rgb != nil && !isCoordinate(rgb[0]) && !isCoordinate(rgb[1]) && !isCoordinate(rgb[2]) {
+		if colors, ok := combineRGB("rgb", attrs[rgb[0]], attrs[rgb[1]], attrs[rgb[2]]); ok && s.Attribute("rgb") == nil {
+			delete(attrs, rgb[1])
+			delete(attrs, rgb[2])
+			attrs[rgb[0]] = colors
+		}
+	}
+	for _, attr := range attrs {
+		if existing := s.Attribute(attr.Name); existing != nil && existing.Kind != attr.Kind {
+			return fail(fmt.Errorf("attribute %s holds %v values, not %v", attr.Name, existing.Kind, attr.Kind))
+		}
+	}
+	for i := range names {
+		if attr, ok := attrs[i]; ok {
+			s.SetAttribute(start, attr)
+		}
+	}
+
+	return report, nil
+}
+
+// reservePoints makes room for n more points without reallocating
+func (s *Space3D) reservePoints(n int) {
+	if n > cap(s.Points)-len(s.Points) {
+		s.Points = append(make([]Point3D, 0, len(s.Points)+n), s.Points...)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileFormat is a format that a space can be read from and written to. Files
+// are matched to a format by the extension of their name or, when no format
+// claims it, by the bytes they start with.
+type FileFormat struct {
+	// Name of the format, such as "ply"
+	Name string
+
+	// Extensions of files in the format, such as ".ply"
+	Extensions []string
+
+	// Magic holds the bytes that data in the format may start with, if
+	// there are any that tell it apart
+	Magic []string
+
+	// Read adds the points, and any faces, of data in the format to the
+	// space, leaving it as it was when reading fails
+	Read func(s *Space3D, r io.Reader) error
+
+	// Write writes the space in the format, in the encoding chosen by
+	// options for formats that have several. It is nil for formats that
+	// can only be read.
+	Write func(s *Space3D, w io.Writer, options FormatOptions) error
+}
+
+// FormatOptions choose the encodings of the formats that have several
+type FormatOptions struct {
+	PLY PLYFormat
+	STL STLFormat
+	PCD PCDFormat
+}
+
+// formatMagicSize is the number of bytes at the start of a file that are
+// matched against magic bytes
+const formatMagicSize = 16
+
+var (
+	formatsMu   sync.RWMutex
+	fileFormats []FileFormat
+)
+
+// RegisterFileFormat adds a format that files can be loaded from and saved
+// to. Registering an existing name replaces the previous format, and formats
+// registered earlier are preferred for extensions and magic bytes they share.
+func RegisterFileFormat(format FileFormat) error {
+	if format.Name == "" {
+		return fmt.Errorf("file format has no name")
+	}
+	if format.Read == nil {
+		return fmt.Errorf("file format %s has no reader", format.Name)
+	}
+	for _, magic := range format.Magic {
+		if magic == "" || len(magic) > formatMagicSize {
+			return fmt.Errorf("file format %s has magic bytes %q that aren't 1 to %d long", format.Name, magic, formatMagicSize)
+		}
+	}
+	extensions := make([]string, len(format.Extensions))
+	for i, ext := range format.Extensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+			return fmt.Errorf("file format %s has invalid extension %q", format.Name, ext)
+		}
+		extensions[i] = strings.ToLower(ext)
+	}
+	format.Extensions = extensions
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	for i := range fileFormats {
+		if fileFormats[i].Name == format.Name {
+			fileFormats[i] = format
+			return nil
+		}
+	}
+	fileFormats = append(fileFormats, format)
+	return nil
+}
+
+// FileFormatByName returns the registered format with the given name
+func FileFormatByName(name string) (FileFormat, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, format := range fileFormats {
+		if format.Name == name {
+			return format, true
+		}
+	}
+	return FileFormat{}, false
+}
+
+// FileExtensions returns the extensions of all registered formats, such as
+// for filtering a file dialog
+func FileExtensions() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	var extensions []string
+	for _, format := range fileFormats {
+		extensions = append(extensions, format.Extensions...)
+	}
+	return extensions
+}
+
+// DetectFileFormat returns the format of a file from the extension of its
+// name or, when no format claims the extension, from header, the first
+// bytes of its data. Files matching neither are taken as CSV.
+func DetectFileFormat(fileName string, header []byte) FileFormat {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, format := range fileFormats {
+		for _, e := range format.Extensions {
+			if e == ext {
+				return format
+			}
+		}
+	}
+	for _, format := range fileFormats {
+		for _, magic := range format.Magic {
+			if strings.HasPrefix(string(header), magic) {
+				return format
+			}
+		}
+	}
+	for _, format := range fileFormats {
+		if format.Name == "csv" {
+			return format
+		}
+	}
+	return FileFormat{}
+}
+
+// FileFormatOf returns the format of the file at filePath as detected by
+// DetectFileFormat, reading the start of the file if its extension isn't
+// enough
+func FileFormatOf(filePath string) (FileFormat, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return FileFormat{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	header := make([]byte, formatMagicSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FileFormat{}, fmt.Errorf("error reading file: %w", err)
+	}
+	return DetectFileFormat(filePath, header[:n]), nil
+}
+
+// Load adds the points, and any faces, of the file at filePath to the space
+func (f FileFormat) Load(s *Space3D, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s file: %w", strings.ToUpper(f.Name), err)
+	}
+	defer file.Close()
+	return f.Read(s, file)
+}
+
+// Save saves the space to a file at filePath
+func (f FileFormat) Save(s *Space3D, filePath string, options FormatOptions) error {
+	if f.Write == nil {
+		return fmt.Errorf("%s files can't be written", strings.ToUpper(f.Name))
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s file: %w", strings.ToUpper(f.Name), err)
+	}
+	defer file.Close()
+	return f.Write(s, file, options)
+}
+
+// LoadFile loads points, and any faces, from a file in the format detected
+// by DetectFileFormat
+func (s *Space3D) LoadFile(filePath string) error {
+	format, err := FileFormatOf(filePath)
+	if err != nil {
+		return err
+	}
+	return format.Load(s, filePath)
+}
+
+// ReadFile reads points, and any faces, from the data of a file with the
+// given name, in the format detected by DetectFileFormat
+func (s *Space3D) ReadFile(r io.Reader, fileName string) error {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(formatMagicSize)
+	return DetectFileFormat(fileName, header).Read(s, br)
+}
+
+// SaveFile saves the space in the format claiming the extension of
+// filePath, or as CSV if none does
+func (s *Space3D) SaveFile(filePath string, options FormatOptions) error {
+	return DetectFileFormat(filePath, nil).Save(s, filePath, options)
+}
+
+func init() {
+	formats := []FileFormat{
+		{
+			Name:       "csv",
+			Extensions: []string{".csv"},
+			Read: func(s *Space3D, r io.Reader) error {
+				_, err := s.ReadPointsFromCSV(r, CSVOptions{})
+				return err
+			},
+			Write: func(s *Space3D, w io.Writer, _ FormatOptions) error {
+				return s.WritePointsToCSV(w)
+			},
+		},
+		{
+			Name:       "ply",
+			Extensions: []string{".ply"},
+			Magic:      []string{"ply\n", "ply\r\n"},
+			Read:       (*Space3D).ReadMeshFromPLY,
+			Write: func(s *Space3D, w io.Writer, options FormatOptions) error {
+				return s.WriteMeshToPLY(w, options.PLY)
+			},
+		},
+		{
+			Name:       "obj",
+			Extensions: []string{".obj"},
+			Read:       (*Space3D).ReadMeshFromOBJ,
+			Write: func(s *Space3D, w io.Writer, _ FormatOptions) error {
+				return s.WriteMeshToOBJ(w)
+			},
+		},
+		{
+			Name:       "stl",
+			Extensions: []string{".stl"},
+			Magic:      []string{"solid"},
+			Read:       (*Space3D).ReadMeshFromSTL,
+			Write: func(s *Space3D, w io.Writer, options FormatOptions) error {
+				return s.WriteMeshToSTL(w, options.STL)
+			},
+		},
+		{
+			Name:       "las",
+			Extensions: []string{".las"},
+			Magic:      []string{"LASF"},
+			Read:       (*Space3D).ReadPointsFromLAS,
+			Write: func(s *Space3D, w io.Writer, _ FormatOptions) error {
+				return s.WritePointsToLAS(w)
+			},
+		},
+		{
+			Name:       "pcd",
+			Extensions: []string{".pcd"},
+			Magic:      []string{"# .PCD", "VERSION", "FIELDS"},
+			Read:       (*Space3D).ReadPointsFromPCD,
+			Write: func(s *Space3D, w io.Writer, options FormatOptions) error {
+				return s.WritePointsToPCD(w, options.PCD)
+			},
+		},
+		{
+			Name:       "xyz",
+			Extensions: []string{".xyz"},
+			Read:       (*Space3D).ReadPointsFromXYZ,
+			Write: func(s *Space3D, w io.Writer, _ FormatOptions) error {
+				return s.WritePointsToXYZ(w)
+			},
+		},
+		{
+			Name:       "pts",
+			Extensions: []string{".pts"},
+			Read:       (*Space3D).ReadPointsFromPTS,
+			Write: func(s *Space3D, w io.Writer, _ FormatOptions) error {
+				return s.WritePointsToPTS(w)
+			},
+		},
+	}
+
+	for _, format := range formats {
+		if err := RegisterFileFormat(format); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	endian "encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Names of the attributes holding the fields of LAS point records
+const (
+	lasIntensity          = "intensity"
+	lasReturnNumber       = "return_number"
+	lasNumberOfReturns    = "number_of_returns"
+	lasClassification     = "classification"
+	lasClassificationFlag = "classification_flags"
+	lasScannerChannel     = "scanner_channel"
+	lasScanDirection      = "scan_direction"
+	lasEdgeOfFlightLine   = "edge_of_flight_line"
+	lasScanAngle          = "scan_angle"
+	lasUserData           = "user_data"
+	lasPointSourceID      = "point_source_id"
+	lasGPSTime            = "gps_time"
+	lasNIR                = "nir"
+)
+
+// lasHeaderSize12 and lasHeaderSize14 are the sizes of the public header
+// block of LAS 1.2 and LAS 1.4 files
+const (
+	lasHeaderSize12 = 227
+	lasHeaderSize14 = 375
+)
+
+// lasRecordSizes are the sizes of the point records of each point data
+// format, 0 to 10, without extra bytes
+var lasRecordSizes = []int{20, 28, 26, 34, 57, 63, 30, 36, 38, 59, 67}
+
+// lasLayout gives where the optional fields of the records of a point data
+// format start, or -1 where the format lacks them
+type lasLayout struct {
+	extended      bool
+	gps, rgb, nir int
+}
+
+// lasFormatLayout returns the layout of the records of a point data format
+func lasFormatLayout(format int) lasLayout {
+	layout := lasLayout{extended: format >= 6, gps: -1, rgb: -1, nir: -1}
+	switch format {
+	case 1, 4:
+		layout.gps = 20
+	case 2:
+		layout.rgb = 20
+	case 3, 5:
+		layout.gps, layout.rgb = 20, 28
+	case 6, 9:
+		layout.gps = 22
+	case 7:
+		layout.gps, layout.rgb = 22, 30
+	case 8, 10:
+		layout.gps, layout.rgb, layout.nir = 22, 30, 36
+	}
+	return layout
+}
+
+// LoadPointsFromLAS loads points from a LAS file as described for
+// ReadPointsFromLAS
+func (s *Space3D) LoadPointsFromLAS(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open LAS file: %w", err)
+	}
+	defer file.Close()
+	return s.ReadPointsFromLAS(file)
+}
+
+// ReadPointsFromLAS reads points from uncompressed LAS data of versions 1.0
+// to 1.4, in any of the point data formats 0 to 10. Coordinates are scaled
+// and offset as the header says. The fields of each record become attributes
+// of its point: intensity, return_number, number_of_returns, classification,
+// classification_flags (synthetic, key-point, withheld and, from format 6,
+// overlap, as bits 1, 2, 4 and 8), scanner_channel from format 6,
+// scan_direction, edge_of_flight_line, scan_angle in degrees, user_data,
+// point_source_id, and gps_time and nir where the format has them. Colors
+// become the colors of the points, scaled down to 8 bits when any channel
+// uses 16. Variable length records, waveforms and extra bytes are skipped.
+// When reading fails, the space is left as it was.
+func (s *Space3D) ReadPointsFromLAS(r io.Reader) error {
+	le := endian.LittleEndian
+	br := bufio.NewReader(r)
+
+	header := make([]byte, lasHeaderSize12)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("error reading LAS header: %w", err)
+	}
+	if string(header[:4]) != "LASF" {
+		return fmt.Errorf("invalid LAS file: doesn't start with LASF")
+	}
+	major, minor := header[24], header[25]
+	if major != 1 || minor > 4 {
+		return fmt.Errorf("unsupported LAS version %d.%d", major, minor)
+	}
+	headerSize := int(le.Uint16(header[94:]))
+	pointOffset := int64(le.Uint32(header[96:]))
+	format := int(header[104])
+	recordSize := int(le.Uint16(header[105:]))
+	count := uint64(le.Uint32(header[107:]))
+	var scale, offset [3]float64
+	for axis := range scale {
+		scale[axis] = math.Float64frombits(le.Uint64(header[131+8*axis:]))
+		offset[axis] = math.Float64frombits(le.Uint64(header[155+8*axis:]))
+	}
+
+	if format&0xc0 != 0 {
+		return fmt.Errorf("compressed LAZ files aren't supported")
+	}
+	if format >= len(lasRecordSizes) {
+		return fmt.Errorf("unsupported LAS point data format %d", format)
+	}
+	if recordSize < lasRecordSizes[format] {
+		return fmt.Errorf("invalid LAS file: records of %d bytes are too short for point data format %d", recordSize, format)
+	}
+	if headerSize < lasHeaderSize12 || pointOffset < int64(headerSize) {
+		return fmt.Errorf("invalid LAS file: header of %d bytes with points at %d", headerSize, pointOffset)
+	}
+
+	// LAS 1.4 counts points in 64 bits, leaving the legacy count zero for
+	// the newer formats
+	rest := make([]byte, headerSize-lasHeaderSize12)
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return fmt.Errorf("error reading LAS header: %w", err)
+	}
+	if minor >= 4 && headerSize >= lasHeaderSize14 {
+		if count64 := le.Uint64(rest[247-lasHeaderSize12:]); count64 != 0 {
+			count = count64
+		}
+	}
+
+	// Skip the variable length records
+	if _, err := io.CopyN(io.Discard, br, pointOffset-int64(headerSize)); err != nil {
+		return fmt.Errorf("error reading LAS variable length records: %w", err)
+	}
+
+	layout := lasFormatLayout(format)
+	ints := []string{lasIntensity, lasReturnNumber, lasNumberOfReturns, lasClassification, lasClassificationFlag}
+	if layout.extended {
+		ints = append(ints, lasScannerChannel)
+	}
+	ints = append(ints, lasScanDirection, lasEdgeOfFlightLine, lasUserData, lasPointSourceID)
+	if layout.nir >= 0 {
+		ints = append(ints, lasNIR)
+	}
+	floats := []string{lasScanAngle}
+	if layout.gps >= 0 {
+		floats = append(floats, lasGPSTime)
+	}
+
+	// Nothing is allocated from the point count, which a damaged or
+	// hostile header can make arbitrarily large
+	var points []Point3D
+	intValues := make(map[string][]int64)
+	floatValues := make(map[string][]float64)
+	var rgb [][3]uint16
+	wide := false
+
+	record := make([]byte, recordSize)
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(br, record); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("error reading LAS point %d: %w", i, err)
+		}
+
+		var coords [3]float64
+		for axis := range coords {
+			coords[axis] = float64(int32(le.Uint32(record[4*axis:])))*scale[axis] + offset[axis]
+		}
+		points = append(points, NewPoint3D(coords[0], coords[1], coords[2]))
+
+		field := func(name string, value int64) {
+			intValues[name] = append(intValues[name], value)
+		}
+		field(lasIntensity, int64(le.Uint16(record[12:])))
+		if layout.extended {
+			returns, flags := record[14], record[15]
+			field(lasReturnNumber, int64(returns&15))
+			field(lasNumberOfReturns, int64(returns>>4))
+			field(lasClassificationFlag, int64(flags&15))
+			field(lasScannerChannel, int64(flags>>4&3))
+			field(lasScanDirection, int64(flags>>6&1))
+			field(lasEdgeOfFlightLine, int64(flags>>7))
+			field(lasClassification, int64(record[16]))
+			field(lasUserData, int64(record[17]))
+			floatValues[lasScanAngle] = append(floatValues[lasScanAngle], float64(int16(le.Uint16(record[18:])))*0.006)
+			field(lasPointSourceID, int64(le.Uint16(record[20:])))
+		} else {
+			returns, class := record[14], record[15]
+			field(lasReturnNumber, int64(returns&7))
+			field(lasNumberOfReturns, int64(returns>>3&7))
+			field(lasScanDirection, int64(returns>>6&1))
+			field(lasEdgeOfFlightLine, int64(returns>>7))
+			field(lasClassification, int64(class&31))
+			field(lasClassificationFlag, int64(class>>5))
+			floatValues[lasScanAngle] = append(floatValues[lasScanAngle], float64(int8(record[16])))
+			field(lasUserData, int64(record[17]))
+			field(lasPointSourceID, int64(le.Uint16(record[18:])))
+		}
+		if layout.gps >= 0 {
+			floatValues[lasGPSTime] = append(floatValues[lasGPSTime], math.Float64frombits(le.Uint64(record[layout.gps:])))
+		}
+		if layout.rgb >= 0 {
+			var c [3]uint16
+			for j := range c {
+				c[j] = le.Uint16(record[layout.rgb+2*j:])
+				wide = wide || c[j] > 255
+			}
+			rgb = append(rgb, c)
+		}
+		if layout.nir >= 0 {
+			field(lasNIR, int64(le.Uint16(record[layout.nir:])))
+		}
+	}
+
+	var attrs []Attribute
+	for _, name := range ints {
+		attrs = append(attrs, Attribute{Name: name, Kind: AttributeInt, Ints: intValues[name]})
+	}
+	for _, name := range floats {
+		attrs = append(attrs, Attribute{Name: name, Kind: AttributeFloat, Floats: floatValues[name]})
+	}
+	for _, attr := range attrs {
+		if existing := s.Attribute(attr.Name); existing != nil && existing.Kind != attr.Kind {
+			return fmt.Errorf("attribute %s holds %v values, not %v", attr.Name, existing.Kind, attr.Kind)
+		}
+	}
+
+	start := len(s.Points)
+	s.Points = append(s.Points, points...)
+	for i, c := range rgb {
+		if wide {
+			c = [3]uint16{c[0] >> 8, c[1] >> 8, c[2] >> 8}
+		}
+		s.SetColor(start+i, color.RGBA{uint8(c[0]), uint8(c[1]), uint8(c[2]), 255})
+	}
+	for _, attr := range attrs {
+		s.SetAttribute(start, attr)
+	}
+	return nil
+}
+
+// SavePointsToLAS saves the points of the space to a LAS file as described
+// for WritePointsToLAS
+func (s *Space3D) SavePointsToLAS(filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create LAS file: %w", err)
+	}
+	defer file.Close()
+	return s.WritePointsToLAS(file)
+}
+
+// lasScale returns a scale factor for coordinates spanning the given range:
+// a millimetre, or the smallest power of ten that keeps the range within the
+// 32 bit integers of LAS records
+func lasScale(span float64) float64 {
+	scale := 0.001
+	for span/scale > math.MaxInt32 {
+		scale *= 10
+	}
+	return scale
+}
+
+// WritePointsToLAS writes the points of the space as uncompressed LAS data.
+// The attributes named as for ReadPointsFromLAS fill the fields of the point
+// records, with missing values written as zero, and colors are scaled up to
+// 16 bits. Coordinates are offset from the lowest corner of the points and
+// scaled to a millimetre where they fit. Points that fit the classic formats
+// are written as LAS 1.2, in point data format 0 to 3 depending on whether
+// there are GPS times and colors; otherwise, such as for classes above 31,
+// more than 7 returns or near infrared, they are written as LAS 1.4 in
+// format 6, 7 or 8.
+func (s *Space3D) WritePointsToLAS(w io.Writer) error {
+	le := endian.LittleEndian
+
+	// Values of the named attribute, or zero where there are none
+	value := func(name string, index int) float64 {
+		if attr := s.Attribute(name); attr != nil {
+			if v, ok := attr.Value(index); ok {
+				return v
+			}
+		}
+		return 0
+	}
+	has := func(name string) bool {
+		attr := s.Attribute(name)
+		return attr != nil && attr.Numeric()
+	}
+
+	// Choose the point data format
+	extended := has(lasNIR)
+	for i := range s.Points {
+		extended = extended || value(lasClassification, i) > 31 ||
+			value(lasReturnNumber, i) > 7 || value(lasNumberOfReturns, i) > 7 ||
+			value(lasClassificationFlag, i) > 7 || value(lasScannerChannel, i) > 0
+	}
+	hasColors := len(s.Colors) > 0
+	format := 0
+	switch {
+	case extended && has(lasNIR):
+		format = 8
+	case extended && hasColors:
+		format = 7
+	case extended:
+		format = 6
+	case has(lasGPSTime) && hasColors:
+		format = 3
+	case hasColors:
+		format = 2
+	case has(lasGPSTime):
+		format = 1
+	}
+	layout := lasFormatLayout(format)
+	recordSize := lasRecordSizes[format]
+
+	// Offset coordinates from the lowest corner
+	lo := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range s.Points {
+		for axis, v := range []float64{p.X, p.Y, p.Z} {
+			lo[axis], hi[axis] = math.Min(lo[axis], v), math.Max(hi[axis], v)
+		}
+	}
+	var scale, offset [3]float64
+	for axis := range scale {
+		if len(s.Points) == 0 {
+			lo[axis], hi[axis] = 0, 0
+		}
+		offset[axis] = math.Floor(lo[axis])
+		scale[axis] = lasScale(hi[axis] - offset[axis])
+	}
+
+	// Points by return number, 5 of them in LAS 1.2 and 15 in LAS 1.4
+	var byReturn [15]uint64
+	for i := range s.Points {
+		if n := int(value(lasReturnNumber, i)); n >= 1 && n <= 15 {
+			byReturn[n-1]++
+		}
+	}
+
+	headerSize := lasHeaderSize12
+	if extended {
+		headerSize = lasHeaderSize14
+	}
+	header := make([]byte, headerSize)
+	copy(header, "LASF")
+	header[24], header[25] = 1, 2
+	if extended {
+		// WKT coordinate systems are required from format 6
+		le.PutUint16(header[6:], 0x10)
+		header[25] = 4
+	}
+	copy(header[26:58], "3dspace")
+	copy(header[58:90], "3dspace")
+	now := time.Now()
+	le.PutUint16(header[90:], uint16(now.YearDay()))
+	le.PutUint16(header[92:], uint16(now.Year()))
+	le.PutUint16(header[94:], uint16(headerSize))
+	le.PutUint32(header[96:], uint32(headerSize))
+	header[104] = byte(format)
+	le.PutUint16(header[105:], uint16(recordSize))
+	if !extended {
+		le.PutUint32(header[107:], uint32(len(s.Points)))
+		for n := 0; n < 5; n++ {
+			le.PutUint32(header[111+4*n:], uint32(byReturn[n]))
+		}
+	}
+	for axis := range scale {
+		le.PutUint64(header[131+8*axis:], math.Float64bits(scale[axis]))
+		le.PutUint64(header[155+8*axis:], math.Float64bits(offset[axis]))
+		le.PutUint64(header[179+16*axis:], math.Float64bits(hi[axis]))
+		le.PutUint64(header[187+16*axis:], math.Float64bits(lo[axis]))
+	}
+	if extended {
+		le.PutUint64(header[247:], uint64(len(s.Points)))
+		for n := 0; n < 15; n++ {
+			le.PutUint64(header[255+8*n:], byReturn[n])
+		}
+	}
+
+	writer := bufio.NewWriter(w)
+	if _, err := writer.Write(header); err != nil {
+		return fmt.Errorf("error writing LAS header: %w", err)
+	}
+
+	record := make([]byte, recordSize)
+	for i, p := range s.Points {
+		for j := range record {
+			record[j] = 0
+		}
+		for axis, v := range []float64{p.X, p.Y, p.Z} {
+			le.PutUint32(record[4*axis:], uint32(int32(math.Round((v-offset[axis])/scale[axis]))))
+		}
+		field := func(name string) uint64 {
+			return uint64(int64(value(name, i)))
+		}
+		le.PutUint16(record[12:], uint16(field(lasIntensity)))
+		if extended {
+			record[14] = byte(field(lasReturnNumber)&15 | field(lasNumberOfReturns)&15<<4)
+			record[15] = byte(field(lasClassificationFlag)&15 | field(lasScannerChannel)&3<<4 |
+				field(lasScanDirection)&1<<6 | field(lasEdgeOfFlightLine)&1<<7)
+			record[16] = byte(field(lasClassification))
+			record[17] = byte(field(lasUserData))
+			angle := math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(value(lasScanAngle, i)/0.006)))
+			le.PutUint16(record[18:], uint16(int16(angle)))
+			le.PutUint16(record[20:], uint16(field(lasPointSourceID)))
+		} else {
+			record[14] = byte(field(lasReturnNumber)&7 | field(lasNumberOfReturns)&7<<3 |
+				field(lasScanDirection)&1<<6 | field(lasEdgeOfFlightLine)&1<<7)
+			record[15] = byte(field(lasClassification)&31 | field(lasClassificationFlag)&7<<5)
+			angle := math.Max(-90, math.Min(90, math.Round(value(lasScanAngle, i))))
+			record[16] = byte(int8(angle))
+			record[17] = byte(field(lasUserData))
+			le.PutUint16(record[18:], uint16(field(lasPointSourceID)))
+		}
+		if layout.gps >= 0 {
+			le.PutUint64(record[layout.gps:], math.Float64bits(value(lasGPSTime, i)))
+		}
+		if layout.rgb >= 0 {
+			c, _ := s.Color(i)
+			for j, channel := range []uint8{c.R, c.G, c.B} {
+				le.PutUint16(record[layout.rgb+2*j:], uint16(channel)*257)
+			}
+		}
+		if layout.nir >= 0 {
+			le.PutUint16(record[layout.nir:], uint16(field(lasNIR)))
+		}
+
+		if _, err := writer.Write(record); err != nil {
+			return fmt.Errorf("error writing LAS point: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing LAS file: %w", err)
+	}
+	return nil
+}
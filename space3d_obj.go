@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// objGroupAttribute is the name of the attribute holding the OBJ group of
+// each point
+const objGroupAttribute = "group"
+
+// objIndex resolves an OBJ index, which counts from 1, or backwards from -1
+// for the last element so far, to an index counting from 0
+func objIndex(s string, count int) (int, error) {
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index %q", s)
+	}
+	if index < 0 {
+		index += count + 1
+	}
+	if index < 1 || index > count {
+		return 0, fmt.Errorf("index %s out of range, there are %d", s, count)
+	}
+	return index - 1, nil
+}
+
+// LoadMeshFromOBJ loads points and faces from a Wavefront OBJ file as
+// described for ReadMeshFromOBJ
+func (s *Space3D) LoadMeshFromOBJ(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open OBJ file: %w", err)
+	}
+	defer file.Close()
+	return s.ReadMeshFromOBJ(file)
+}
+
+// ReadMeshFromOBJ reads points and faces from Wavefront OBJ data. Vertices
+// (v) become points, with colors when they are followed by red, green and
+// blue from 0 to 1. Faces (f) become faces, and the vertex normals (vn) they
+// refer to become the normals of their points. Lines (l) become polylines.
+// When the data has groups (g or o), each point used by a face is given the
+// name of the group of the first such face in a "group" attribute. Texture
+// coordinates, materials and other statements are skipped. When reading
+// fails, the space is left as it was.
+func (s *Space3D) ReadMeshFromOBJ(r io.Reader) error {
+	var points, normals []Point3D
+	var colors []color.RGBA
+	var faces []Face
+	var polylines []Polyline
+	pointNormals := make(map[int]Point3D)
+	pointGroups := make(map[int]string)
+	group, grouped := "", false
+	start := len(s.Points)
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		invalid := func(format string, args ...interface{}) error {
+			return fmt.Errorf("invalid OBJ line %d: %s", lineNumber, fmt.Sprintf(format, args...))
+		}
+		numbers := func(fields []string) ([]float64, error) {
+			values := make([]float64, len(fields))
+			for i, field := range fields {
+				value, err := strconv.ParseFloat(field, 64)
+				if err != nil {
+					return nil, invalid("%q isn't a number", field)
+				}
+				values[i] = value
+			}
+			return values, nil
+		}
+
+		switch fields[0] {
+		case "v":
+			values, err := numbers(fields[1:])
+			if err != nil {
+				return err
+			}
+			if len(values) < 3 {
+				return invalid("a vertex needs x, y and z")
+			}
+			points = append(points, NewPoint3D(values[0], values[1], values[2]))
+			if len(values) == 6 {
+				c := color.RGBA{A: 255}
+				for i, channel := range []*uint8{&c.R, &c.G, &c.B} {
+					*channel = uint8(math.Max(0, math.Min(255, math.Round(values[3+i]*255))))
+				}
+				for len(colors) < len(points)-1 {
+					colors = append(colors, color.RGBA{})
+				}
+				colors = append(colors, c)
+			}
+		case "vn":
+			values, err := numbers(fields[1:])
+			if err != nil {
+				return err
+			}
+			if len(values) != 3 {
+				return invalid("a normal needs x, y and z")
+			}
+			normals = append(normals, NewPoint3D(values[0], values[1], values[2]))
+		case "f":
+			if len(fields) < 4 {
+				return invalid("a face needs at least 3 vertices")
+			}
+			face := make(Face, len(fields)-1)
+			for i, vertex := range fields[1:] {
+				// Each vertex is v, v/vt, v//vn or v/vt/vn
+				parts := strings.Split(vertex, "/")
+				index, err := objIndex(parts[0], len(points))
+				if err != nil {
+					return invalid("%v", err)
+				}
+				if len(parts) == 3 && parts[2] != "" {
+					n, err := objIndex(parts[2], len(normals))
+					if err != nil {
+						return invalid("%v", err)
+					}
+					pointNormals[index] = normals[n]
+				}
+				if _, ok := pointGroups[index]; !ok && grouped {
+					pointGroups[index] = group
+				}
+				face[i] = start + index
+			}
+			faces = append(faces, face)
+		case "l":
+			if len(fields) < 3 {
+				return invalid("a line needs at least 2 vertices")
+			}
+			line := make(Polyline, len(fields)-1)
+			for i, vertex := range fields[1:] {
+				index, err := objIndex(strings.Split(vertex, "/")[0], len(points))
+				if err != nil {
+					return invalid("%v", err)
+				}
+				line[i] = start + index
+			}
+			polylines = append(polylines, line)
+		case "g", "o":
+			group, grouped = strings.Join(fields[1:], " "), true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading OBJ file: %w", err)
+	}
+
+	var groups *Attribute
+	if len(pointGroups) > 0 {
+		if existing := s.Attribute(objGroupAttribute); existing != nil && existing.Kind != AttributeString {
+			return fmt.Errorf("attribute %s holds %v values, not %v", objGroupAttribute, existing.Kind, AttributeString)
+		}
+		groups = &Attribute{Name: objGroupAttribute, Kind: AttributeString, Strings: make([]string, len(points))}
+		for index, name := range pointGroups {
+			groups.Strings[index] = name
+		}
+	}
+
+	s.Points = append(s.Points, points...)
+	for index, normal := range pointNormals {
+		s.SetNormal(start+index, normal)
+	}
+	for i, c := range colors {
+		if c.A != 0 {
+			s.SetColor(start+i, c)
+		}
+	}
+	if groups != nil {
+		s.SetAttribute(start, *groups)
+	}
+	s.Faces = append(s.Faces, faces...)
+	s.Polylines = append(s.Polylines, polylines...)
+	return nil
+}
+
+// SaveMeshToOBJ saves the space to a Wavefront OBJ file as described for
+// WriteMeshToOBJ
+func (s *Space3D) SaveMeshToOBJ(filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create OBJ file: %w", err)
+	}
+	defer file.Close()
+	return s.WriteMeshToOBJ(file)
+}
+
+// WriteMeshToOBJ writes all points, faces and polylines in the space as
+// Wavefront OBJ data. Points with colors are followed by their red, green and
+// blue from 0 to 1, and points with normals have a vertex normal that the
+// faces refer to. With a "group" attribute, as read by ReadMeshFromOBJ, a
+// group statement starts each run of faces whose first points share a group,
+// which gives back the groups that were read when they don't share points.
+func (s *Space3D) WriteMeshToOBJ(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	// Write vertices
+	for i, point := range s.Points {
+		line := "v " + format(point.X) + " " + format(point.Y) + " " + format(point.Z)
+		if c, ok := s.Color(i); ok {
+			line += " " + format(float64(c.R)/255) + " " + format(float64(c.G)/255) + " " + format(float64(c.B)/255)
+		}
+		if _, err := fmt.Fprintln(writer, line); err != nil {
+			return fmt.Errorf("error writing vertex to OBJ: %w", err)
+		}
+	}
+
+	// Write normals, numbered in the order of their points
+	normalIndex := make(map[int]int)
+	for i := range s.Points {
+		if n, ok := s.Normal(i); ok {
+			normalIndex[i] = len(normalIndex) + 1
+			if _, err := fmt.Fprintf(writer, "vn %s %s %s\n", format(n.X), format(n.Y), format(n.Z)); err != nil {
+				return fmt.Errorf("error writing normal to OBJ: %w", err)
+			}
+		}
+	}
+
+	// Write faces, whose vertex indices start at 1, with normals when all
+	// their points have them
+	groups := s.Attribute(objGroupAttribute)
+	if groups != nil && groups.Kind != AttributeString {
+		groups = nil
+	}
+	group, grouped := "", false
+	for _, face := range s.Faces {
+		if groups != nil && len(face) > 0 {
+			if name := groups.Text(face[0]); !grouped || name != group {
+				group, grouped = name, true
+				if _, err := fmt.Fprintln(writer, strings.TrimSpace("g "+group)); err != nil {
+					return fmt.Errorf("error writing group to OBJ: %w", err)
+				}
+			}
+		}
+
+		withNormals := true
+		for _, index := range face {
+			_, ok := normalIndex[index]
+			withNormals = withNormals && ok
+		}
+		line := "f"
+		for _, index := range face {
+			line += " " + strconv.Itoa(index+1)
+			if withNormals {
+				line += "//" + strconv.Itoa(normalIndex[index])
+			}
+		}
+		if _, err := fmt.Fprintln(writer, line); err != nil {
+			return fmt.Errorf("error writing face to OBJ: %w", err)
+		}
+	}
+
+	// Write polylines
+	for _, polyline := range s.Polylines {
+		line := "l"
+		for _, index := range polyline {
+			line += " " + strconv.Itoa(index+1)
+		}
+		if _, err := fmt.Fprintln(writer, line); err != nil {
+			return fmt.Errorf("error writing line to OBJ: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing OBJ file: %w", err)
+	}
+	return nil
+}
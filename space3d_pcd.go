@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	endian "encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PCDFormat is the encoding of the points of a PCD file
+type PCDFormat int
+
+const (
+	// PCDASCII writes points as text, a line for each
+	PCDASCII PCDFormat = iota
+	// PCDBinary writes points as little endian binary records
+	PCDBinary
+)
+
+// pcdFormatNames are the names of the formats, as in the DATA line of a PCD
+// header and as accepted by ParsePCDFormat
+var pcdFormatNames = []string{"ascii", "binary"}
+
+// ParsePCDFormat parses a format name: ascii or binary
+func ParsePCDFormat(s string) (PCDFormat, error) {
+	for i, name := range pcdFormatNames {
+		if strings.EqualFold(s, name) {
+			return PCDFormat(i), nil
+		}
+	}
+	return PCDASCII, fmt.Errorf("invalid PCD format %q: expected %s", s, strings.Join(pcdFormatNames, ", "))
+}
+
+// String returns the name of the format
+func (f PCDFormat) String() string {
+	return pcdFormatNames[f]
+}
+
+// pcdField is a field of the points of a PCD file
+type pcdField struct {
+	name  string
+	size  int
+	typ   byte // I for signed integers, U for unsigned and F for floats
+	count int
+}
+
+// pcdMaxPointSize is the largest number of bytes the fields of a point may
+// take up, well beyond the largest feature histograms
+const pcdMaxPointSize = 1 << 20
+
+// pcdNormalFields are the names of the fields holding the parts of normals
+var pcdNormalFields = []string{"normal_x", "normal_y", "normal_z"}
+
+// parse reads a value of the field from its text
+func (f pcdField) parse(s string) (float64, error) {
+	if f.typ == 'F' {
+		return strconv.ParseFloat(s, 64)
+	}
+	if f.typ == 'U' {
+		v, err := strconv.ParseUint(s, 10, 64)
+		return float64(v), err
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	return float64(v), err
+}
+
+// decode reads a value of the field from its little endian bytes
+func (f pcdField) decode(b []byte) float64 {
+	le := endian.LittleEndian
+	switch {
+	case f.typ == 'F' && f.size == 4:
+		return float64(math.Float32frombits(le.Uint32(b)))
+	case f.typ == 'F':
+		return math.Float64frombits(le.Uint64(b))
+	}
+	var u uint64
+	switch f.size {
+	case 1:
+		u = uint64(b[0])
+	case 2:
+		u = uint64(le.Uint16(b))
+	case 4:
+		u = uint64(le.Uint32(b))
+	default:
+		u = le.Uint64(b)
+	}
+	if f.typ == 'U' {
+		return float64(u)
+	}
+	shift := 64 - 8*f.size
+	return float64(int64(u<<shift) >> shift)
+}
+
+// pcdColor unpacks the color of a PCD rgb or rgba field, stored as the bits
+// of a float or an unsigned integer holding 0xRRGGBB
+func pcdColor(field pcdField, value float64, raw []byte) color.RGBA {
+	var bits uint32
+	switch {
+	case raw != nil && field.size == 4:
+		bits = endian.LittleEndian.Uint32(raw)
+	case field.typ == 'F':
+		bits = math.Float32bits(float32(value))
+	default:
+		bits = uint32(value)
+	}
+	return color.RGBA{uint8(bits >> 16), uint8(bits >> 8), uint8(bits), 255}
+}
+
+// readPCDHeader reads the header of a PCD file, up to and including its DATA
+// line, returning the fields, the number of points and the encoding
+func readPCDHeader(r *bufio.Reader) ([]pcdField, int, string, error) {
+	var names, sizes, types, counts []string
+	points := -1
+	width, height := 0, 1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, 0, "", fmt.Errorf("error reading PCD header: %w", err)
+		}
+		words := strings.Fields(line)
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+		number := func() (int, error) {
+			if len(words) != 2 {
+				return 0, fmt.Errorf("invalid PCD header: %s needs a number", words[0])
+			}
+			n, err := strconv.Atoi(words[1])
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid PCD header: %s %q isn't a count", words[0], words[1])
+			}
+			return n, nil
+		}
+
+		switch strings.ToUpper(words[0]) {
+		case "FIELDS":
+			names = words[1:]
+		case "SIZE":
+			sizes = words[1:]
+		case "TYPE":
+			types = words[1:]
+		case "COUNT":
+			counts = words[1:]
+		case "WIDTH":
+			if width, err = number(); err != nil {
+				return nil, 0, "", err
+			}
+		case "HEIGHT":
+			if height, err = number(); err != nil {
+				return nil, 0, "", err
+			}
+		case "POINTS":
+			if points, err = number(); err != nil {
+				return nil, 0, "", err
+			}
+		case "DATA":
+			if len(words) != 2 {
+				return nil, 0, "", fmt.Errorf("invalid PCD header: DATA needs an encoding")
+			}
+			if points < 0 {
+				points = width * height
+			}
+			fields, err := pcdFields(names, sizes, types, counts)
+			return fields, points, strings.ToLower(words[1]), err
+		}
+	}
+}
+
+// pcdFields combines the FIELDS, SIZE, TYPE and COUNT lines of a PCD header,
+// taking sizes of 4, floats and counts of 1 where they are missing
+func pcdFields(names, sizes, types, counts []string) ([]pcdField, error) {
+	fields := make([]pcdField, len(names))
+	pointSize := 0
+	for i, name := range names {
+		f := pcdField{name: name, size: 4, typ: 'F', count: 1}
+		if sizes != nil {
+			if len(sizes) != len(names) {
+				return nil, fmt.Errorf("invalid PCD header: %d sizes for %d fields", len(sizes), len(names))
+			}
+			f.size, _ = strconv.Atoi(sizes[i])
+		}
+		if types != nil {
+			if len(types) != len(names) {
+				return nil, fmt.Errorf("invalid PCD header: %d types for %d fields", len(types), len(names))
+			}
+			f.typ = strings.ToUpper(types[i])[0]
+		}
+		if counts != nil {
+			if len(counts) != len(names) {
+				return nil, fmt.Errorf("invalid PCD header: %d counts for %d fields", len(counts), len(names))
+			}
+			f.count, _ = strconv.Atoi(counts[i])
+		}
+
+		valid := f.size == 1 || f.size == 2 || f.size == 4 || f.size == 8
+		if f.typ == 'F' {
+			valid = f.size == 4 || f.size == 8
+		} else if f.typ != 'I' && f.typ != 'U' {
+			valid = false
+		}
+		if !valid || f.count < 1 {
+			return nil, fmt.Errorf("invalid PCD header: field %s has type %c, size %d and count %d", name, f.typ, f.size, f.count)
+		}
+		if f.count > pcdMaxPointSize || pointSize+f.size*f.count > pcdMaxPointSize {
+			return nil, fmt.Errorf("invalid PCD header: points of more than %d bytes aren't supported", pcdMaxPointSize)
+		}
+		pointSize += f.size * f.count
+		fields[i] = f
+	}
+	return fields, nil
+}
+
+// LoadPointsFromPCD loads points from a PCD file as described for
+// ReadPointsFromPCD
+func (s *Space3D) LoadPointsFromPCD(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open PCD file: %w", err)
+	}
+	defer file.Close()
+	return s.ReadPointsFromPCD(file)
+}
+
+// ReadPointsFromPCD reads points from Point Cloud Library PCD data, in the
+// ascii or binary encoding. The x, y and z fields give the coordinates,
+// normal_x, normal_y and normal_z the normals, and rgb or rgba the colors.
+// Other fields holding one value become attributes: integers for I and U
+// fields and floats for F fields. Fields holding several values, such as
+// feature histograms, are skipped, and so are points with a coordinate that
+// isn't a number, which organized clouds use for missing points. When
+// reading fails, the space is left as it was.
+func (s *Space3D) ReadPointsFromPCD(r io.Reader) error {
+	br := bufio.NewReader(r)
+	fields, count, data, err := readPCDHeader(br)
+	if err != nil {
+		return err
+	}
+	if data == "binary_compressed" {
+		return fmt.Errorf("compressed PCD files aren't supported")
+	}
+	if data != "ascii" && data != "binary" {
+		return fmt.Errorf("invalid PCD header: unknown encoding %q", data)
+	}
+
+	// Find where each field goes, as offsets into the values of a point
+	// and its bytes
+	coordinate := [3]int{-1, -1, -1}
+	normal := [3]int{-1, -1, -1}
+	rgb := -1
+	var attrs []Attribute
+	attrField := make(map[int]int)
+	values, size := 0, 0
+	offsets := make([]int, len(fields))
+	byteOffsets := make([]int, len(fields))
+	for i, f := range fields {
+		offsets[i], byteOffsets[i] = values, size
+		values += f.count
+		size += f.size * f.count
+		if f.count != 1 {
+			continue
+		}
+		switch name := strings.ToLower(f.name); {
+		case name == "x" || name == "y" || name == "z":
+			coordinate[name[0]-'x'] = i
+		case name == "rgb" || name == "rgba":
+			rgb = i
+		default:
+			isNormal := false
+			for axis, normalName := range pcdNormalFields {
+				if name == normalName {
+					normal[axis], isNormal = i, true
+				}
+			}
+			if !isNormal {
+				attr := Attribute{Name: f.name, Kind: AttributeFloat}
+				if f.typ != 'F' {
+					attr.Kind = AttributeInt
+				}
+				attrField[len(attrs)] = i
+				attrs = append(attrs, attr)
+			}
+		}
+	}
+	if coordinate[0] < 0 || coordinate[1] < 0 || coordinate[2] < 0 {
+		return fmt.Errorf("invalid PCD file: needs x, y and z fields")
+	}
+	hasNormals := normal[0] >= 0 && normal[1] >= 0 && normal[2] >= 0
+	for _, attr := range attrs {
+		if existing := s.Attribute(attr.Name); existing != nil && existing.Kind != attr.Kind {
+			return fmt.Errorf("attribute %s holds %v values, not %v", attr.Name, existing.Kind, attr.Kind)
+		}
+	}
+
+	var points []Point3D
+	var normals []Point3D
+	var colors []color.RGBA
+	point := make([]float64, values)
+	record := make([]byte, size)
+	var words *bufio.Scanner
+	if data == "ascii" {
+		words = bufio.NewScanner(br)
+		words.Split(bufio.ScanWords)
+	}
+
+	for n := 0; n < count; n++ {
+		if words != nil {
+			for i, f := range fields {
+				for j := 0; j < f.count; j++ {
+					if !words.Scan() {
+						if err := words.Err(); err != nil {
+							return fmt.Errorf("error reading PCD point %d: %w", n, err)
+						}
+						return fmt.Errorf("invalid PCD file: %d points instead of %d", n, count)
+					}
+					value, err := f.parse(words.Text())
+					if err != nil {
+						return fmt.Errorf("invalid PCD point %d: %q isn't a value of field %s", n, words.Text(), f.name)
+					}
+					point[offsets[i]+j] = value
+				}
+			}
+		} else {
+			if _, err := io.ReadFull(br, record); err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return fmt.Errorf("invalid PCD file: %d points instead of %d", n, count)
+				}
+				return fmt.Errorf("error reading PCD point %d: %w", n, err)
+			}
+			for i, f := range fields {
+				for j := 0; j < f.count; j++ {
+					point[offsets[i]+j] = f.decode(record[byteOffsets[i]+f.size*j:])
+				}
+			}
+		}
+
+		p := NewPoint3D(point[offsets[coordinate[0]]], point[offsets[coordinate[1]]], point[offsets[coordinate[2]]])
+		if math.IsNaN(p.X) || math.IsNaN(p.Y) || math.IsNaN(p.Z) {
+			continue
+		}
+		points = append(points, p)
+		if hasNormals {
+			normals = append(normals, NewPoint3D(point[offsets[normal[0]]], point[offsets[normal[1]]], point[offsets[normal[2]]]))
+		}
+		if rgb >= 0 {
+			var raw []byte
+			if words == nil {
+				raw = record[byteOffsets[rgb]:]
+			}
+			colors = append(colors, pcdColor(fields[rgb], point[offsets[rgb]], raw))
+		}
+		for a := range attrs {
+			value := point[offsets[attrField[a]]]
+			if attrs[a].Kind == AttributeInt {
+				attrs[a].Ints = append(attrs[a].Ints, int64(value))
+			} else {
+				attrs[a].Floats = append(attrs[a].Floats, value)
+			}
+		}
+	}
+
+	start := len(s.Points)
+	s.Points = append(s.Points, points...)
+	for i, n := range normals {
+		s.SetNormal(start+i, n)
+	}
+	for i, c := range colors {
+		s.SetColor(start+i, c)
+	}
+	for _, attr := range attrs {
+		s.SetAttribute(start, attr)
+	}
+	return nil
+}
+
+// SavePointsToPCD saves the points of the space to a PCD file as described
+// for WritePointsToPCD
+func (s *Space3D) SavePointsToPCD(filePath string, format PCDFormat) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create PCD file: %w", err)
+	}
+	defer file.Close()
+	return s.WritePointsToPCD(file, format)
+}
+
+// WritePointsToPCD writes the points of the space as PCD data in the given
+// encoding. Coordinates and normals are written as 4 byte floats, as the
+// Point Cloud Library's point types hold them, and colors packed into an rgb
+// float, with points lacking a normal or color given zero. Integer
+// attributes are written as 4 byte integers where they fit and as 8 byte
+// floats otherwise, float attributes as 8 byte floats, and other attributes
+// are left out. Spaces in attribute names are replaced by underscores.
+func (s *Space3D) WritePointsToPCD(w io.Writer, format PCDFormat) error {
+	fields := []pcdField{{"x", 4, 'F', 1}, {"y", 4, 'F', 1}, {"z", 4, 'F', 1}}
+	hasNormals := len(s.Normals) > 0
+	if hasNormals {
+		for _, name := range pcdNormalFields {
+			fields = append(fields, pcdField{name, 4, 'F', 1})
+		}
+	}
+	colorField := -1
+	if len(s.Colors) > 0 {
+		colorField = len(fields)
+		fields = append(fields, pcdField{"rgb", 4, 'F', 1})
+	}
+	var attrs []*Attribute
+	for i := range s.Attributes {
+		attr := &s.Attributes[i]
+		if !attr.Numeric() {
+			continue
+		}
+		f := pcdField{strings.ReplaceAll(attr.Name, " ", "_"), 8, 'F', 1}
+		if attr.Kind == AttributeInt {
+			f.size, f.typ = 4, 'I'
+			for _, v := range attr.Ints {
+				if v < math.MinInt32 || v > math.MaxInt32 {
+					f.size, f.typ = 8, 'F'
+				}
+			}
+		}
+		fields = append(fields, f)
+		attrs = append(attrs, attr)
+	}
+
+	writer := bufio.NewWriter(w)
+	var names, sizes, types, counts []string
+	size := 0
+	for _, f := range fields {
+		names = append(names, f.name)
+		sizes = append(sizes, strconv.Itoa(f.size))
+		types = append(types, string(f.typ))
+		counts = append(counts, "1")
+		size += f.size
+	}
+	fmt.Fprintln(writer, "# .PCD v0.7 - Point Cloud Data file format")
+	fmt.Fprintln(writer, "VERSION 0.7")
+	fmt.Fprintln(writer, "FIELDS", strings.Join(names, " "))
+	fmt.Fprintln(writer, "SIZE", strings.Join(sizes, " "))
+	fmt.Fprintln(writer, "TYPE", strings.Join(types, " "))
+	fmt.Fprintln(writer, "COUNT", strings.Join(counts, " "))
+	fmt.Fprintln(writer, "WIDTH", len(s.Points))
+	fmt.Fprintln(writer, "HEIGHT", 1)
+	fmt.Fprintln(writer, "VIEWPOINT 0 0 0 1 0 0 0")
+	fmt.Fprintln(writer, "POINTS", len(s.Points))
+	if _, err := fmt.Fprintln(writer, "DATA", format); err != nil {
+		return fmt.Errorf("error writing PCD header: %w", err)
+	}
+
+	le := endian.LittleEndian
+	values := make([]float64, len(fields))
+	record := make([]byte, size)
+	line := make([]string, len(fields))
+	for i, p := range s.Points {
+		values = values[:0]
+		values = append(values, p.X, p.Y, p.Z)
+		if hasNormals {
+			n, _ := s.Normal(i)
+			values = append(values, n.X, n.Y, n.Z)
+		}
+		var packed uint32
+		if colorField >= 0 {
+			c, _ := s.Color(i)
+			packed = uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+			values = append(values, 0)
+		}
+		for _, attr := range attrs {
+			v, _ := attr.Value(i)
+			values = append(values, v)
+		}
+
+		offset := 0
+		for j, f := range fields {
+			v, isColor := values[j], j == colorField
+			if format == PCDBinary {
+				switch {
+				case isColor:
+					le.PutUint32(record[offset:], packed)
+				case f.typ == 'I':
+					le.PutUint32(record[offset:], uint32(int32(v)))
+				case f.size == 4:
+					le.PutUint32(record[offset:], math.Float32bits(float32(v)))
+				default:
+					le.PutUint64(record[offset:], math.Float64bits(v))
+				}
+				offset += f.size
+				continue
+			}
+			switch {
+			case isColor:
+				line[j] = strconv.FormatFloat(float64(math.Float32frombits(packed)), 'g', -1, 32)
+			case f.typ == 'I':
+				line[j] = strconv.FormatInt(int64(v), 10)
+			case f.size == 4:
+				line[j] = strconv.FormatFloat(v, 'g', -1, 32)
+			default:
+				line[j] = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+		}
+
+		var err error
+		if format == PCDBinary {
+			_, err = writer.Write(record)
+		} else {
+			_, err = fmt.Fprintln(writer, strings.Join(line, " "))
+		}
+		if err != nil {
+			return fmt.Errorf("error writing point to PCD: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing PCD file: %w", err)
+	}
+	return nil
+}
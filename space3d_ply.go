@@ -0,0 +1,632 @@
+package main
+
+import (
+	"bufio"
+	endian "encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PLYFormat is the encoding of the data of a PLY file
+type PLYFormat int
+
+const (
+	// PLYASCII writes values as text, an item of an element to a line
+	PLYASCII PLYFormat = iota
+	// PLYBinaryLittleEndian writes values in binary, least significant byte first
+	PLYBinaryLittleEndian
+	// PLYBinaryBigEndian writes values in binary, most significant byte first
+	PLYBinaryBigEndian
+)
+
+// plyFormatNames are the names of the formats as written in PLY headers
+var plyFormatNames = []string{"ascii", "binary_little_endian", "binary_big_endian"}
+
+// ParsePLYFormat parses a format name: ascii, binary_little_endian or
+// binary_big_endian
+func ParsePLYFormat(s string) (PLYFormat, error) {
+	for i, name := range plyFormatNames {
+		if strings.EqualFold(s, name) {
+			return PLYFormat(i), nil
+		}
+	}
+	return PLYASCII, fmt.Errorf("invalid PLY format %q: expected %s", s, strings.Join(plyFormatNames, ", "))
+}
+
+// String returns the name of the format
+func (f PLYFormat) String() string {
+	return plyFormatNames[f]
+}
+
+// plyType is the type of a PLY property value
+type plyType int
+
+const (
+	plyInt8 plyType = iota
+	plyUint8
+	plyInt16
+	plyUint16
+	plyInt32
+	plyUint32
+	plyFloat32
+	plyFloat64
+)
+
+// plyTypeNames are the names of each type, in the original PLY spelling and
+// the sized one
+var plyTypeNames = [][2]string{
+	{"char", "int8"}, {"uchar", "uint8"}, {"short", "int16"}, {"ushort", "uint16"},
+	{"int", "int32"}, {"uint", "uint32"}, {"float", "float32"}, {"double", "float64"},
+}
+
+// plyTypeSizes are the sizes of the types in bytes
+var plyTypeSizes = []int{1, 1, 2, 2, 4, 4, 4, 8}
+
+// parsePLYType parses the name of a property type
+func parsePLYType(s string) (plyType, error) {
+	for i, names := range plyTypeNames {
+		if s == names[0] || s == names[1] {
+			return plyType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid PLY property type %q", s)
+}
+
+// integer reports whether values of the type are whole numbers
+func (t plyType) integer() bool {
+	return t < plyFloat32
+}
+
+// plyProperty is a property of the items of an element: a single value, or
+// a list of values preceded by their count
+type plyProperty struct {
+	name      string
+	typ       plyType
+	list      bool
+	countType plyType
+}
+
+// plyElement is an element of a PLY file, such as its vertices or faces
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// index returns the index of the named property, or -1 if there is none
+func (e *plyElement) index(names ...string) int {
+	for i, p := range e.properties {
+		for _, name := range names {
+			if p.name == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// readPLYHeader reads the header of a PLY file, up to and including its
+// end_header line
+func readPLYHeader(r *bufio.Reader) (PLYFormat, []plyElement, error) {
+	var format PLYFormat
+	var elements []plyElement
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return format, nil, fmt.Errorf("error reading PLY header: %w", err)
+		}
+		fields := strings.Fields(line)
+
+		if lineNumber == 1 {
+			if len(fields) != 1 || fields[0] != "ply" {
+				return format, nil, fmt.Errorf("invalid PLY file: doesn't start with ply")
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		invalid := fmt.Errorf("invalid PLY header line %d: %q", lineNumber, strings.TrimSpace(line))
+		switch fields[0] {
+		case "comment", "obj_info":
+		case "format":
+			if len(fields) != 3 {
+				return format, nil, invalid
+			}
+			if format, err = ParsePLYFormat(fields[1]); err != nil {
+				return format, nil, err
+			}
+			if fields[2] != "1.0" {
+				return format, nil, fmt.Errorf("unsupported PLY version %s", fields[2])
+			}
+		case "element":
+			if len(fields) != 3 {
+				return format, nil, invalid
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return format, nil, invalid
+			}
+			elements = append(elements, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return format, nil, invalid
+			}
+			var property plyProperty
+			var err error
+			switch {
+			case len(fields) == 3:
+				property.name = fields[2]
+				property.typ, err = parsePLYType(fields[1])
+			case len(fields) == 5 && fields[1] == "list":
+				property.name, property.list = fields[4], true
+				if property.countType, err = parsePLYType(fields[2]); err == nil {
+					property.typ, err = parsePLYType(fields[3])
+				}
+			default:
+				return format, nil, invalid
+			}
+			if err != nil {
+				return format, nil, err
+			}
+			element := &elements[len(elements)-1]
+			element.properties = append(element.properties, property)
+		case "end_header":
+			return format, elements, nil
+		default:
+			return format, nil, invalid
+		}
+	}
+}
+
+// plyDecoder reads the values of the data of a PLY file one at a time
+type plyDecoder struct {
+	r     *bufio.Reader
+	words *bufio.Scanner
+	order endian.ByteOrder
+	buf   [8]byte
+}
+
+// newPLYDecoder creates a decoder of data in the given format
+func newPLYDecoder(r *bufio.Reader, format PLYFormat) *plyDecoder {
+	d := &plyDecoder{r: r, order: endian.LittleEndian}
+	switch format {
+	case PLYASCII:
+		d.words = bufio.NewScanner(r)
+		d.words.Split(bufio.ScanWords)
+	case PLYBinaryBigEndian:
+		d.order = endian.BigEndian
+	}
+	return d
+}
+
+// read reads a value of the given type
+func (d *plyDecoder) read(t plyType) (float64, error) {
+	if d.words != nil {
+		if !d.words.Scan() {
+			if err := d.words.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		return strconv.ParseFloat(d.words.Text(), 64)
+	}
+
+	b := d.buf[:plyTypeSizes[t]]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	switch t {
+	case plyInt8:
+		return float64(int8(b[0])), nil
+	case plyUint8:
+		return float64(b[0]), nil
+	case plyInt16:
+		return float64(int16(d.order.Uint16(b))), nil
+	case plyUint16:
+		return float64(d.order.Uint16(b)), nil
+	case plyInt32:
+		return float64(int32(d.order.Uint32(b))), nil
+	case plyUint32:
+		return float64(d.order.Uint32(b)), nil
+	case plyFloat32:
+		return float64(math.Float32frombits(d.order.Uint32(b))), nil
+	default:
+		return math.Float64frombits(d.order.Uint64(b)), nil
+	}
+}
+
+// readItem reads an item of the element, filling values with its scalar
+// properties and lists with its list properties, each at the property's index
+func (d *plyDecoder) readItem(element *plyElement, values []float64, lists [][]int) error {
+	for i, property := range element.properties {
+		if !property.list {
+			value, err := d.read(property.typ)
+			if err != nil {
+				return fmt.Errorf("error reading PLY %s %s: %w", element.name, property.name, err)
+			}
+			values[i] = value
+			continue
+		}
+
+		count, err := d.read(property.countType)
+		if err != nil || count < 0 || count != math.Trunc(count) {
+			return fmt.Errorf("error reading PLY %s %s: invalid list length", element.name, property.name)
+		}
+		lists[i] = lists[i][:0]
+		for j := 0; j < int(count); j++ {
+			value, err := d.read(property.typ)
+			if err != nil {
+				return fmt.Errorf("error reading PLY %s %s: %w", element.name, property.name, err)
+			}
+			lists[i] = append(lists[i], int(value))
+		}
+	}
+	return nil
+}
+
+// plyColorChannel converts a color channel of the given type to a byte.
+// Floating point channels run from 0 to 1 and 16 bit ones from 0 to 65535.
+func plyColorChannel(value float64, t plyType) uint8 {
+	switch {
+	case !t.integer():
+		value *= 255
+	case t == plyUint16 || t == plyInt16:
+		value /= 257
+	}
+	return uint8(math.Max(0, math.Min(255, math.Round(value))))
+}
+
+// LoadMeshFromPLY loads points and faces from a PLY file as described for
+// ReadMeshFromPLY
+func (s *Space3D) LoadMeshFromPLY(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open PLY file: %w", err)
+	}
+	defer file.Close()
+	return s.ReadMeshFromPLY(file)
+}
+
+// ReadMeshFromPLY reads points and faces from PLY data in any of its
+// formats. Vertices become points, with their nx, ny and nz properties as
+// normals and red, green, blue and alpha as colors, and their other scalar
+// properties as attributes. Faces become faces and edges become polylines
+// joining their two vertices. Other elements and properties are skipped.
+// When reading fails, the space is left as it was.
+func (s *Space3D) ReadMeshFromPLY(r io.Reader) error {
+	br := bufio.NewReader(r)
+	format, elements, err := readPLYHeader(br)
+	if err != nil {
+		return err
+	}
+	decoder := newPLYDecoder(br, format)
+
+	vertexCount := 0
+	for _, element := range elements {
+		if element.name == "vertex" {
+			vertexCount = element.count
+		}
+	}
+	start := len(s.Points)
+	vertex := func(index int) (int, error) {
+		if index < 0 || index >= vertexCount {
+			return 0, fmt.Errorf("invalid PLY file: vertex %d out of range, there are %d", index, vertexCount)
+		}
+		return start + index, nil
+	}
+
+	var points, normals []Point3D
+	var colors []color.RGBA
+	var attrs []Attribute
+	var faces []Face
+	var polylines []Polyline
+	for e := range elements {
+		element := &elements[e]
+		values := make([]float64, len(element.properties))
+		lists := make([][]int, len(element.properties))
+
+		// Properties of the element that are read
+		coords := []int{element.index("x"), element.index("y"), element.index("z")}
+		normal := []int{element.index("nx"), element.index("ny"), element.index("nz")}
+		rgba := []int{
+			element.index("red", "diffuse_red"), element.index("green", "diffuse_green"),
+			element.index("blue", "diffuse_blue"), element.index("alpha"),
+		}
+		indices := element.index("vertex_indices", "vertex_index")
+		ends := []int{element.index("vertex1"), element.index("vertex2")}
+
+		var attrProperties []int
+		switch element.name {
+		case "vertex":
+			if coords[0] < 0 || coords[1] < 0 || coords[2] < 0 {
+				return fmt.Errorf("invalid PLY file: vertices need x, y and z")
+			}
+			for i, property := range element.properties {
+				known := i == coords[0] || i == coords[1] || i == coords[2] ||
+					i == normal[0] || i == normal[1] || i == normal[2] ||
+					i == rgba[0] || i == rgba[1] || i == rgba[2] || i == rgba[3]
+				if !known && !property.list {
+					attrProperties = append(attrProperties, i)
+					kind := AttributeFloat
+					if property.typ.integer() {
+						kind = AttributeInt
+					}
+					attrs = append(attrs, Attribute{Name: property.name, Kind: kind})
+				}
+			}
+		case "face":
+			if indices < 0 || !element.properties[indices].list {
+				return fmt.Errorf("invalid PLY file: faces need vertex_indices")
+			}
+		}
+		hasNormals := normal[0] >= 0 && normal[1] >= 0 && normal[2] >= 0
+		hasColors := rgba[0] >= 0 && rgba[1] >= 0 && rgba[2] >= 0
+		firstAttr := len(attrs) - len(attrProperties)
+
+		for item := 0; item < element.count; item++ {
+			if err := decoder.readItem(element, values, lists); err != nil {
+				return err
+			}
+
+			switch element.name {
+			case "vertex":
+				points = append(points, NewPoint3D(values[coords[0]], values[coords[1]], values[coords[2]]))
+				if hasNormals {
+					normals = append(normals, NewPoint3D(values[normal[0]], values[normal[1]], values[normal[2]]))
+				}
+				if hasColors {
+					c := color.RGBA{A: 255}
+					for i, channel := range []*uint8{&c.R, &c.G, &c.B, &c.A} {
+						if rgba[i] >= 0 {
+							*channel = plyColorChannel(values[rgba[i]], element.properties[rgba[i]].typ)
+						}
+					}
+					colors = append(colors, c)
+				}
+				for i, property := range attrProperties {
+					attr := &attrs[firstAttr+i]
+					if attr.Kind == AttributeInt {
+						attr.Ints = append(attr.Ints, int64(values[property]))
+					} else {
+						attr.Floats = append(attr.Floats, values[property])
+					}
+				}
+			case "face":
+				face := make(Face, len(lists[indices]))
+				for i, index := range lists[indices] {
+					if face[i], err = vertex(index); err != nil {
+						return err
+					}
+				}
+				faces = append(faces, face)
+			case "edge":
+				if ends[0] < 0 || ends[1] < 0 {
+					continue
+				}
+				line := make(Polyline, 2)
+				for i, end := range ends {
+					if line[i], err = vertex(int(values[end])); err != nil {
+						return err
+					}
+				}
+				polylines = append(polylines, line)
+			}
+		}
+	}
+
+	for _, attr := range attrs {
+		if existing := s.Attribute(attr.Name); existing != nil && existing.Kind != attr.Kind {
+			return fmt.Errorf("attribute %s holds %v values, not %v", attr.Name, existing.Kind, attr.Kind)
+		}
+	}
+	s.Points = append(s.Points, points...)
+	for i, n := range normals {
+		s.SetNormal(start+i, n)
+	}
+	for i, c := range colors {
+		s.SetColor(start+i, c)
+	}
+	for _, attr := range attrs {
+		s.SetAttribute(start, attr)
+	}
+	s.Faces = append(s.Faces, faces...)
+	s.Polylines = append(s.Polylines, polylines...)
+	return nil
+}
+
+// plyEncoder writes the values of the data of a PLY file
+type plyEncoder struct {
+	w      *bufio.Writer
+	format PLYFormat
+	order  endian.ByteOrder
+	buf    [8]byte
+
+	// Whether the next ASCII value starts a line
+	lineStart bool
+}
+
+// write writes a value of the given type
+func (e *plyEncoder) write(t plyType, value float64) error {
+	if e.format == PLYASCII {
+		if !e.lineStart {
+			e.w.WriteByte(' ')
+		}
+		e.lineStart = false
+		if t.integer() {
+			_, err := e.w.WriteString(strconv.FormatInt(int64(value), 10))
+			return err
+		}
+		_, err := e.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+		return err
+	}
+
+	b := e.buf[:plyTypeSizes[t]]
+	switch t {
+	case plyInt8, plyUint8:
+		b[0] = byte(int64(value))
+	case plyInt16, plyUint16:
+		e.order.PutUint16(b, uint16(int64(value)))
+	case plyInt32, plyUint32:
+		e.order.PutUint32(b, uint32(int64(value)))
+	case plyFloat32:
+		e.order.PutUint32(b, math.Float32bits(float32(value)))
+	default:
+		e.order.PutUint64(b, math.Float64bits(value))
+	}
+	_, err := e.w.Write(b)
+	return err
+}
+
+// endItem ends an item of an element, which in ASCII ends its line
+func (e *plyEncoder) endItem() error {
+	if e.format != PLYASCII {
+		return nil
+	}
+	e.lineStart = true
+	return e.w.WriteByte('\n')
+}
+
+// SaveMeshToPLY saves the space to a PLY file as described for WriteMeshToPLY
+func (s *Space3D) SaveMeshToPLY(filePath string, format PLYFormat) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create PLY file: %w", err)
+	}
+	defer file.Close()
+
+	return s.WriteMeshToPLY(file, format)
+}
+
+// WriteMeshToPLY writes the points, faces and polylines of the space as PLY
+// data in the given format. Points are written as vertices with double
+// precision coordinates, with normals and colors when any point has them,
+// and with a property for each numeric attribute; text and color attributes
+// are left out, as are spaces in attribute names. Each segment of a polyline
+// is written as an edge.
+func (s *Space3D) WriteMeshToPLY(w io.Writer, format PLYFormat) error {
+	vertexProperties := []plyProperty{{name: "x", typ: plyFloat64}, {name: "y", typ: plyFloat64}, {name: "z", typ: plyFloat64}}
+	if len(s.Normals) > 0 {
+		vertexProperties = append(vertexProperties, plyProperty{name: "nx", typ: plyFloat32}, plyProperty{name: "ny", typ: plyFloat32}, plyProperty{name: "nz", typ: plyFloat32})
+	}
+	if len(s.Colors) > 0 {
+		vertexProperties = append(vertexProperties, plyProperty{name: "red", typ: plyUint8}, plyProperty{name: "green", typ: plyUint8}, plyProperty{name: "blue", typ: plyUint8}, plyProperty{name: "alpha", typ: plyUint8})
+	}
+	var attrs []*Attribute
+	for i := range s.Attributes {
+		attr := &s.Attributes[i]
+		if !attr.Numeric() {
+			continue
+		}
+		typ := plyFloat64
+		if attr.Kind == AttributeInt {
+			typ = plyInt32
+			for _, v := range attr.Ints {
+				if v < math.MinInt32 || v > math.MaxInt32 {
+					typ = plyFloat64
+				}
+			}
+		}
+		attrs = append(attrs, attr)
+		vertexProperties = append(vertexProperties, plyProperty{name: strings.Join(strings.Fields(attr.Name), "_"), typ: typ})
+	}
+
+	countType := plyUint8
+	for _, face := range s.Faces {
+		if len(face) > math.MaxUint8 {
+			countType = plyInt32
+		}
+	}
+	edges := 0
+	for _, line := range s.Polylines {
+		edges += max(len(line)-1, 0)
+	}
+
+	writer := bufio.NewWriter(w)
+	fmt.Fprintf(writer, "ply\nformat %v 1.0\n", format)
+	fmt.Fprintf(writer, "element vertex %d\n", len(s.Points))
+	for _, property := range vertexProperties {
+		fmt.Fprintf(writer, "property %s %s\n", plyTypeNames[property.typ][0], property.name)
+	}
+	if len(s.Faces) > 0 {
+		fmt.Fprintf(writer, "element face %d\nproperty list %s int vertex_indices\n", len(s.Faces), plyTypeNames[countType][0])
+	}
+	if edges > 0 {
+		fmt.Fprintf(writer, "element edge %d\nproperty int vertex1\nproperty int vertex2\n", edges)
+	}
+	if _, err := writer.WriteString("end_header\n"); err != nil {
+		return fmt.Errorf("error writing PLY header: %w", err)
+	}
+
+	encoder := &plyEncoder{w: writer, format: format, order: endian.LittleEndian, lineStart: true}
+	if format == PLYBinaryBigEndian {
+		encoder.order = endian.BigEndian
+	}
+	for i, p := range s.Points {
+		values := []float64{p.X, p.Y, p.Z}
+		if len(s.Normals) > 0 {
+			n, _ := s.Normal(i)
+			values = append(values, n.X, n.Y, n.Z)
+		}
+		if len(s.Colors) > 0 {
+			var c color.RGBA
+			if i < len(s.Colors) {
+				c = s.Colors[i]
+			}
+			values = append(values, float64(c.R), float64(c.G), float64(c.B), float64(c.A))
+		}
+		for _, attr := range attrs {
+			value, ok := attr.Value(i)
+			if !ok && attr.Kind == AttributeFloat {
+				value = math.NaN()
+			}
+			values = append(values, value)
+		}
+
+		for j, value := range values {
+			if err := encoder.write(vertexProperties[j].typ, value); err != nil {
+				return fmt.Errorf("error writing vertex to PLY: %w", err)
+			}
+		}
+		if err := encoder.endItem(); err != nil {
+			return fmt.Errorf("error writing vertex to PLY: %w", err)
+		}
+	}
+
+	for _, face := range s.Faces {
+		encoder.write(countType, float64(len(face)))
+		for _, index := range face {
+			encoder.write(plyInt32, float64(index))
+		}
+		if err := encoder.endItem(); err != nil {
+			return fmt.Errorf("error writing face to PLY: %w", err)
+		}
+	}
+
+	for _, line := range s.Polylines {
+		for i := 0; i+1 < len(line); i++ {
+			encoder.write(plyInt32, float64(line[i]))
+			encoder.write(plyInt32, float64(line[i+1]))
+			if err := encoder.endItem(); err != nil {
+				return fmt.Errorf("error writing edge to PLY: %w", err)
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing PLY file: %w", err)
+	}
+	return nil
+}
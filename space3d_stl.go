@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	endian "encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// STLFormat is the encoding of an STL file
+type STLFormat int
+
+const (
+	// STLBinary writes each triangle as 50 bytes, after an 80 byte header
+	STLBinary STLFormat = iota
+	// STLASCII writes triangles as text
+	STLASCII
+)
+
+// stlFormatNames are the names of the formats accepted by ParseSTLFormat
+var stlFormatNames = []string{"binary", "ascii"}
+
+// ParseSTLFormat parses a format name: binary or ascii
+func ParseSTLFormat(s string) (STLFormat, error) {
+	for i, name := range stlFormatNames {
+		if strings.EqualFold(s, name) {
+			return STLFormat(i), nil
+		}
+	}
+	return STLBinary, fmt.Errorf("invalid STL format %q: expected %s", s, strings.Join(stlFormatNames, ", "))
+}
+
+// String returns the name of the format
+func (f STLFormat) String() string {
+	return stlFormatNames[f]
+}
+
+// stlHeaderSize and stlTriangleSize are the sizes in bytes of the header and
+// of each triangle of a binary STL file
+const (
+	stlHeaderSize   = 84
+	stlTriangleSize = 50
+)
+
+// LoadMeshFromSTL loads a mesh from an STL file as described for
+// ReadMeshFromSTL
+func (s *Space3D) LoadMeshFromSTL(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open STL file: %w", err)
+	}
+	defer file.Close()
+	return s.ReadMeshFromSTL(file)
+}
+
+// ReadMeshFromSTL reads a mesh from STL data, either binary or ASCII. When
+// the reader knows how much data is left, as files do, the data is binary if
+// its size matches the triangle count of a binary header; otherwise it is
+// ASCII if it starts with solid and the count is text too. Triangles are
+// read as they come rather than the whole data at once. STL gives each
+// triangle its own corners, so corners at the same position are merged into
+// one point, connecting the triangles. Facet normals are skipped, as the
+// faces' own are used for shading. When reading fails, the space is left as
+// it was.
+func (s *Space3D) ReadMeshFromSTL(r io.Reader) error {
+	size := stlRemaining(r)
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(stlHeaderSize)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("error reading STL file: %w", err)
+	}
+
+	mesh := stlMesh{index: make(map[Point3D]int)}
+	binary := false
+	if len(header) == stlHeaderSize {
+		count := int64(endian.LittleEndian.Uint32(header[80:]))
+		if size >= 0 {
+			binary = stlHeaderSize+stlTriangleSize*count == size
+		} else {
+			binary = !bytes.HasPrefix(header, []byte("solid")) || !isSTLText(header[80:])
+		}
+	}
+	if binary {
+		err = readSTLBinary(reader, &mesh)
+	} else {
+		err = readSTLText(reader, &mesh)
+	}
+	if err != nil {
+		return err
+	}
+	s.AddMesh(mesh.points, mesh.faces)
+	return nil
+}
+
+// stlRemaining returns the number of bytes left in r, or -1 if r can't tell
+func stlRemaining(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
+
+// isSTLText reports whether data is printable ASCII or whitespace, as the
+// start of an ASCII STL file is, while a binary triangle count rarely is
+func isSTLText(data []byte) bool {
+	for _, b := range data {
+		if b > '~' || b < ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+// stlMesh collects the triangles of an STL file, merging corners at the same
+// position into one point
+type stlMesh struct {
+	index  map[Point3D]int
+	points []Point3D
+	faces  []Face
+}
+
+// add adds a triangle with the given corners
+func (m *stlMesh) add(corners [3]Point3D) {
+	face := make(Face, 3)
+	for j, corner := range corners {
+		k, ok := m.index[corner]
+		if !ok {
+			k = len(m.points)
+			m.index[corner] = k
+			m.points = append(m.points, corner)
+		}
+		face[j] = k
+	}
+	m.faces = append(m.faces, face)
+}
+
+// readSTLBinary reads the triangles of binary STL data, header included.
+// Nothing is allocated from the triangle count, which a damaged header can
+// make arbitrarily large.
+func readSTLBinary(r io.Reader, mesh *stlMesh) error {
+	header := make([]byte, stlHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("error reading STL header: %w", err)
+	}
+	count := endian.LittleEndian.Uint32(header[80:])
+
+	triangle := make([]byte, stlTriangleSize)
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(r, triangle); err != nil {
+			return fmt.Errorf("invalid STL file: triangle %d of %d: %w", i+1, count, err)
+		}
+		// The normal comes first, then the corners
+		var corners [3]Point3D
+		for corner := range corners {
+			var coords [3]float64
+			for axis := range coords {
+				bits := endian.LittleEndian.Uint32(triangle[12*(corner+1)+4*axis:])
+				coords[axis] = float64(math.Float32frombits(bits))
+			}
+			corners[corner] = NewPoint3D(coords[0], coords[1], coords[2])
+		}
+		mesh.add(corners)
+	}
+	return nil
+}
+
+// readSTLText reads the triangles of ASCII STL data, three corners for each
+// facet
+func readSTLText(r io.Reader, mesh *stlMesh) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	if !scanner.Scan() || scanner.Text() != "solid" {
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading STL file: %w", err)
+		}
+		return fmt.Errorf("invalid STL file: neither binary nor starting with solid")
+	}
+
+	var corners [3]Point3D
+	facetCorners := 0
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "facet":
+			facetCorners = 0
+		case "vertex":
+			var coords [3]float64
+			for axis := range coords {
+				if !scanner.Scan() {
+					return fmt.Errorf("invalid STL file: vertex needs x, y and z")
+				}
+				value, err := strconv.ParseFloat(scanner.Text(), 64)
+				if err != nil {
+					return fmt.Errorf("invalid STL file: %q isn't a number", scanner.Text())
+				}
+				coords[axis] = value
+			}
+			if facetCorners < len(corners) {
+				corners[facetCorners] = NewPoint3D(coords[0], coords[1], coords[2])
+			}
+			facetCorners++
+		case "endfacet":
+			if facetCorners != 3 {
+				return fmt.Errorf("invalid STL file: facet %d has %d vertices, not 3", len(mesh.faces)+1, facetCorners)
+			}
+			mesh.add(corners)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading STL file: %w", err)
+	}
+	return nil
+}
+
+// stlTriangles splits the faces of the space into triangles, fanning out from
+// the first point of each face, and returns their corners three at a time
+func (s *Space3D) stlTriangles() []Point3D {
+	var corners []Point3D
+	for _, face := range s.Faces {
+		for i := 1; i+1 < len(face); i++ {
+			corners = append(corners, s.Points[face[0]], s.Points[face[i]], s.Points[face[i+1]])
+		}
+	}
+	return corners
+}
+
+// triangleNormal returns the unit normal of the triangle a, b, c, facing the
+// side from which its corners run anticlockwise, or zero if it is degenerate
+func triangleNormal(a, b, c Point3D) Point3D {
+	u := NewPoint3D(b.X-a.X, b.Y-a.Y, b.Z-a.Z)
+	v := NewPoint3D(c.X-a.X, c.Y-a.Y, c.Z-a.Z)
+	n := NewPoint3D(u.Y*v.Z-u.Z*v.Y, u.Z*v.X-u.X*v.Z, u.X*v.Y-u.Y*v.X)
+	length := Magnitude(n)
+	if length == 0 {
+		return Point3D{}
+	}
+	return NewPoint3D(n.X/length, n.Y/length, n.Z/length)
+}
+
+// SaveMeshToSTL saves the faces of the space to an STL file as described for
+// WriteMeshToSTL
+func (s *Space3D) SaveMeshToSTL(filePath string, format STLFormat) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create STL file: %w", err)
+	}
+	defer file.Close()
+	return s.WriteMeshToSTL(file, format)
+}
+
+// WriteMeshToSTL writes the faces of the space as STL data in the given
+// format, split into triangles as STL requires. Points not on a face and
+// polylines are left out.
+func (s *Space3D) WriteMeshToSTL(w io.Writer, format STLFormat) error {
+	corners := s.stlTriangles()
+	writer := bufio.NewWriter(w)
+
+	if format == STLASCII {
+		formatFloat := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		fmt.Fprintln(writer, "solid 3dspace")
+		for i := 0; i < len(corners); i += 3 {
+			n := triangleNormal(corners[i], corners[i+1], corners[i+2])
+			fmt.Fprintf(writer, "  facet normal %s %s %s\n    outer loop\n", formatFloat(n.X), formatFloat(n.Y), formatFloat(n.Z))
+			for _, p := range corners[i : i+3] {
+				fmt.Fprintf(writer, "      vertex %s %s %s\n", formatFloat(p.X), formatFloat(p.Y), formatFloat(p.Z))
+			}
+			if _, err := fmt.Fprintln(writer, "    endloop\n  endfacet"); err != nil {
+				return fmt.Errorf("error writing facet to STL: %w", err)
+			}
+		}
+		fmt.Fprintln(writer, "endsolid 3dspace")
+	} else {
+		header := make([]byte, stlHeaderSize)
+		copy(header, "binary STL written by 3dspace")
+		endian.LittleEndian.PutUint32(header[80:], uint32(len(corners)/3))
+		writer.Write(header)
+
+		triangle := make([]byte, stlTriangleSize)
+		for i := 0; i < len(corners); i += 3 {
+			n := triangleNormal(corners[i], corners[i+1], corners[i+2])
+			for j, p := range []Point3D{n, corners[i], corners[i+1], corners[i+2]} {
+				for axis, v := range []float64{p.X, p.Y, p.Z} {
+					endian.LittleEndian.PutUint32(triangle[12*j+4*axis:], math.Float32bits(float32(v)))
+				}
+			}
+			if _, err := writer.Write(triangle); err != nil {
+				return fmt.Errorf("error writing facet to STL: %w", err)
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing STL file: %w", err)
+	}
+	return nil
+}
+
+// Solidify returns a closed solid made from the faces of the space, such as
+// a function surface, for 3D printing. Each face is copied onto the plane
+// z = base with its orientation reversed, and each edge on the boundary of
+// the faces is joined to its copy by a wall. For surfaces that lie above the
+// base, with no two points over the same x and y, the result is watertight.
+func (s *Space3D) Solidify(base float64) *Space3D {
+	solid := NewSpace3D()
+
+	// Copy the points on faces, each with a twin on the base
+	top := make(map[int]int)
+	bottom := make(map[int]int)
+	for _, face := range s.Faces {
+		for _, index := range face {
+			if _, ok := top[index]; !ok {
+				p := s.Points[index]
+				top[index] = len(solid.Points)
+				solid.AddPoint(p)
+				bottom[index] = len(solid.Points)
+				solid.AddPoint(NewPoint3D(p.X, p.Y, base))
+			}
+		}
+	}
+
+	// Edges used by a single face are on the boundary
+	type edge struct{ from, to int }
+	uses := make(map[edge]int)
+	for _, face := range s.Faces {
+		for i := range face {
+			a, b := face[i], face[(i+1)%len(face)]
+			uses[edge{min(a, b), max(a, b)}]++
+		}
+	}
+
+	for _, face := range s.Faces {
+		upper := make(Face, len(face))
+		lower := make(Face, len(face))
+		for i, index := range face {
+			upper[i] = top[index]
+			lower[len(face)-1-i] = bottom[index]
+		}
+		solid.Faces = append(solid.Faces, upper, lower)
+
+		for i := range face {
+			a, b := face[i], face[(i+1)%len(face)]
+			if uses[edge{min(a, b), max(a, b)}] == 1 {
+				solid.Faces = append(solid.Faces, Face{top[b], top[a], bottom[a], bottom[b]})
+			}
+		}
+	}
+	return solid
+}
@@ -1,7 +1,15 @@
 package main
 
 import (
+	endian "encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
 	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -60,3 +68,1015 @@ func TestSpace3D(t *testing.T) {
 		t.Errorf("Points not stored correctly in space")
 	}
 }
+
+func TestAddPolyline(t *testing.T) {
+	space := NewSpace3D()
+	space.AddPoint(NewPoint3D(0, 0, 0))
+	space.AddPolyline([]Point3D{NewPoint3D(1, 0, 0), NewPoint3D(2, 0, 0)})
+
+	if len(space.Points) != 3 {
+		t.Errorf("Expected 3 points in space, got %d", len(space.Points))
+	}
+
+	if len(space.Polylines) != 1 || len(space.Polylines[0]) != 2 ||
+		space.Polylines[0][0] != 1 || space.Polylines[0][1] != 2 {
+		t.Errorf("Polyline not stored correctly, got %v", space.Polylines)
+	}
+}
+
+func TestAddGrid(t *testing.T) {
+	space := NewSpace3D()
+
+	var points []Point3D
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			points = append(points, NewPoint3D(float64(c), float64(r), 0))
+		}
+	}
+
+	space.AddGrid(3, 3, points, nil)
+	if len(space.Points) != 9 || len(space.Faces) != 4 {
+		t.Fatalf("Expected 9 points and 4 faces, got %d and %d", len(space.Points), len(space.Faces))
+	}
+	if face := space.Faces[3]; face[0] != 4 || face[1] != 5 || face[2] != 8 || face[3] != 7 {
+		t.Errorf("Face not connected correctly, got %v", face)
+	}
+
+	// Leaving out the centre point removes every face
+	space = NewSpace3D()
+	valid := []bool{true, true, true, true, false, true, true, true, true}
+	space.AddGrid(3, 3, points, valid)
+	if len(space.Points) != 8 || len(space.Faces) != 0 {
+		t.Errorf("Expected 8 points and no faces, got %d and %d", len(space.Points), len(space.Faces))
+	}
+}
+
+func TestSaveMeshToOBJ(t *testing.T) {
+	space := NewSpace3D()
+	space.AddMesh([]Point3D{
+		NewPoint3D(0, 0, 0),
+		NewPoint3D(1, 0, 0),
+		NewPoint3D(0, 1.5, 0),
+	}, []Face{{0, 1, 2}})
+
+	filePath := filepath.Join(t.TempDir(), "mesh.obj")
+	if err := space.SaveMeshToOBJ(filePath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "v 0 0 0\nv 1 0 0\nv 0 1.5 0\nf 1 2 3\n"
+	if string(data) != expected {
+		t.Errorf("Expected OBJ file %q, got %q", expected, string(data))
+	}
+}
+
+func TestLoadPointsFromCSVColumns(t *testing.T) {
+	dir := t.TempDir()
+	writeCSV := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return path
+	}
+
+	// Columns are found by name, and the rest are kept as attributes
+	withHeader := writeCSV("survey.csv", "id,lat,lon,alt,intensity\na,1,2,3,0.5\nb,4,5,6,0.25\n")
+	mapping, err := ParseColumnMapping("x=lon, y=lat, z=alt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	space := NewSpace3D()
+	if err := space.LoadPointsFromCSVColumns(withHeader, mapping); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 2 || space.Points[1] != NewPoint3D(5, 4, 6) {
+		t.Fatalf("Expected 2 points ending at (5, 4, 6), got %v", space.Points)
+	}
+	if len(space.Attributes) != 2 || space.Attributes[0].Name != "id" || space.Attributes[1].Name != "intensity" {
+		t.Fatalf("Expected attributes id and intensity, got %v", space.Attributes)
+	}
+	if attr := space.Attribute("intensity"); attr.Kind != AttributeFloat || attr.Floats[0] != 0.5 || attr.Floats[1] != 0.25 {
+		t.Errorf("Expected intensities 0.5 and 0.25, got %v", attr)
+	}
+
+	// Without a header every row is a point, and columns go by index
+	noHeader := writeCSV("plain.csv", "1,2,3,9\n4,5,6,8\n")
+	space = NewSpace3D()
+	if err := space.LoadPointsFromCSVColumns(noHeader, ColumnMapping{X: "3", Y: "1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 2 || space.Points[0] != NewPoint3D(9, 2, 3) {
+		t.Fatalf("Expected 2 points starting at (9, 2, 3), got %v", space.Points)
+	}
+	if attr := space.Attribute("col0"); attr == nil || attr.Text(1) != "4" {
+		t.Errorf("Expected attribute col0, got %v", space.Attributes)
+	}
+
+	// Only the coordinates decide whether the first row is a header, so
+	// text in other columns doesn't cost the first point
+	space = NewSpace3D()
+	if err := space.LoadPointsFromCSV(writeCSV("labelled.csv", "1,2,3,tree\n4,5,6,bush\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 2 || space.Points[0] != NewPoint3D(1, 2, 3) {
+		t.Fatalf("Expected 2 points starting at (1, 2, 3), got %v", space.Points)
+	}
+	if attr := space.Attribute("col3"); attr == nil || attr.Text(0) != "tree" {
+		t.Errorf("Expected attribute col3 starting with tree, got %v", space.Attributes)
+	}
+	space = NewSpace3D()
+	if err := space.LoadPointsFromCSVColumns(writeCSV("leading.csv", "tree,1,2,3\nbush,4,5,6\n"), ColumnMapping{X: "1", Y: "2", Z: "3"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 2 || space.Points[1] != NewPoint3D(4, 5, 6) {
+		t.Fatalf("Expected 2 points ending at (4, 5, 6), got %v", space.Points)
+	}
+
+	// Default columns are named like the axes, or come first
+	space = NewSpace3D()
+	if err := space.LoadPointsFromCSV(writeCSV("named.csv", "Z,Y,X\n1,2,3\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if space.Points[0] != NewPoint3D(3, 2, 1) {
+		t.Errorf("Expected (3, 2, 1), got %v", space.Points[0])
+	}
+
+	if err := NewSpace3D().LoadPointsFromCSVColumns(withHeader, ColumnMapping{Z: "height"}); err == nil {
+		t.Errorf("Expected error for missing column")
+	}
+	for _, s := range []string{"x", "w=lon", "x=1,x=2"} {
+		if _, err := ParseColumnMapping(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestParseAttribute(t *testing.T) {
+	tests := []struct {
+		values []string
+		kind   AttributeKind
+	}{
+		{[]string{"1", " 2", "-3"}, AttributeInt},
+		{[]string{"1", "2.5", ""}, AttributeFloat},
+		{[]string{"1", "", "3"}, AttributeFloat},
+		{[]string{"#ff0000", "#00FF80"}, AttributeRGB},
+		{[]string{"ground", "2", ""}, AttributeString},
+		{[]string{"", ""}, AttributeString},
+	}
+	for _, test := range tests {
+		if attr := ParseAttribute("a", test.values); attr.Kind != test.kind || attr.Len() != len(test.values) {
+			t.Errorf("%q: expected %d %v values, got %d %v values", test.values, len(test.values), test.kind, attr.Len(), attr.Kind)
+		}
+	}
+
+	// Missing floats have no value
+	attr := ParseAttribute("a", []string{"1.5", ""})
+	if v, ok := attr.Value(0); !ok || v != 1.5 {
+		t.Errorf("Expected 1.5, got %v, %v", v, ok)
+	}
+	if _, ok := attr.Value(1); ok || attr.Text(1) != "" {
+		t.Errorf("Expected no value for a missing float")
+	}
+	attr = ParseAttribute("a", []string{"3", "-1", "2"})
+	if lo, hi := attr.Range(); lo != -1 || hi != 3 {
+		t.Errorf("Expected range [-1, 3], got [%v, %v]", lo, hi)
+	}
+
+	// Attributes added to some of the points are padded
+	space := NewSpace3D()
+	if err := space.SetAttribute(2, ParseAttribute("class", []string{"7"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attr := space.Attribute("class"); attr.Len() != 3 || attr.Ints[2] != 7 {
+		t.Errorf("Expected class 7 at index 2, got %v", attr)
+	}
+	if err := space.SetAttribute(3, ParseAttribute("class", []string{"ground"})); err == nil {
+		t.Errorf("Expected error adding strings to an int attribute")
+	}
+}
+
+func TestSavePointsToCSVAttributes(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	data := "x,y,z,red,green,blue,class,label\n0,0,0,255,0,0,2,ground\n1,2,3,0,128,255,5,tree\n"
+	if err := os.WriteFile(input, []byte(data), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	space := NewSpace3D()
+	if err := space.LoadPointsFromCSV(input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Red, green and blue columns become one color attribute
+	if len(space.Attributes) != 3 {
+		t.Fatalf("Expected attributes rgb, class and label, got %v", space.Attributes)
+	}
+	for i, kind := range []AttributeKind{AttributeRGB, AttributeInt, AttributeString} {
+		if space.Attributes[i].Kind != kind {
+			t.Errorf("Attribute %s: expected %v, got %v", space.Attributes[i].Name, kind, space.Attributes[i].Kind)
+		}
+	}
+
+	output := filepath.Join(dir, "out.csv")
+	if err := space.SavePointsToCSV(output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	saved, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "X,Y,Z,rgb,class,label\n0,0,0,#ff0000,2,ground\n1,2,3,#0080ff,5,tree\n"
+	if string(saved) != expected {
+		t.Errorf("Expected CSV file %q, got %q", expected, string(saved))
+	}
+
+	// Saved files load back with the same attributes
+	reloaded := NewSpace3D()
+	if err := reloaded.LoadPointsFromCSV(output); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reloaded.Attributes) != 3 || reloaded.Attribute("rgb").Colors[1] != (color.RGBA{0, 128, 255, 255}) {
+		t.Errorf("Expected attributes to survive saving, got %v", reloaded.Attributes)
+	}
+}
+
+func TestColorScale(t *testing.T) {
+	viridis, err := ColormapByName("Viridis")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ColormapByName("jet"); err == nil {
+		t.Errorf("Expected an error for an unknown colormap")
+	}
+
+	// Numbers span the colormap, clamped at its ends
+	scale := NewColorScale(viridis)
+	for _, value := range []float64{2, -1, 5} {
+		scale.Include(value)
+	}
+	if scale.Min != -1 || scale.Max != 5 {
+		t.Errorf("Expected range [-1, 5], got [%g, %g]", scale.Min, scale.Max)
+	}
+	start, end := color.RGBA{0x44, 0x01, 0x54, 255}, color.RGBA{0xfd, 0xe7, 0x25, 255}
+	if c := scale.Color(-1); c != start {
+		t.Errorf("Expected %v at the start, got %v", start, c)
+	}
+	if c := scale.Color(10); c != end {
+		t.Errorf("Expected %v past the end, got %v", end, c)
+	}
+
+	// Halfway between two stops blends them
+	if c := viridis.At(0.05); c != (color.RGBA{0x46, 0x13, 0x65, 255}) {
+		t.Errorf("Expected a blend of the first two stops, got %v", c)
+	}
+
+	// Categories are numbered in order, and categorical palettes repeat
+	palette, err := ColormapByName("category10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	categories := NewColorScale(palette)
+	for k := 0; k < 11; k++ {
+		categories.AddCategory(string(rune('a' + k)))
+	}
+	if categories.AddCategory("b") != 1 || len(categories.Categories) != 11 {
+		t.Errorf("Expected existing categories to keep their index, got %v", categories.Categories)
+	}
+	if categories.CategoryColor("a") != categories.CategoryColor("k") {
+		t.Errorf("Expected the palette to repeat after 10 categories")
+	}
+	if categories.CategoryColor("a") == categories.CategoryColor("b") {
+		t.Errorf("Expected distinct colors for the first categories")
+	}
+
+	// Continuous colormaps spread categories from end to end
+	spread := NewColorScale(viridis)
+	spread.AddCategory("low")
+	spread.AddCategory("high")
+	if spread.CategoryColor("low") != start || spread.CategoryColor("high") != end {
+		t.Errorf("Expected categories at the ends of the colormap")
+	}
+}
+
+func TestReadPointsFromCSV(t *testing.T) {
+	input := "x,y,z,class\n0,0,0,1\n1,1,1,2\nbad,2,2,3\n3,3,3,4\n4,4\n5,5,5,6\n6,6,6,\"7\n"
+
+	// Failing stops at the first bad row and leaves the space alone
+	space := NewSpace3D()
+	space.AddPoint(NewPoint3D(9, 9, 9))
+	if _, err := space.ReadPointsFromCSV(strings.NewReader(input), CSVOptions{}); err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("Expected an error at line 4, got %v", err)
+	}
+	if len(space.Points) != 1 || len(space.Attributes) != 0 {
+		t.Errorf("Expected the space to be unchanged, got %d points and %v", len(space.Points), space.Attributes)
+	}
+
+	// Skipping leaves bad rows out and reports their lines
+	space = NewSpace3D()
+	report, err := space.ReadPointsFromCSV(strings.NewReader(input), CSVOptions{BadRows: BadRowSkip})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Rows != 7 || report.Points != 4 || len(space.Points) != 4 {
+		t.Errorf("Expected 7 rows and 4 points, got %d rows, %d points", report.Rows, report.Points)
+	}
+	expectedLines := []int{4, 6, 8}
+	if len(report.Skipped) != len(expectedLines) {
+		t.Fatalf("Expected skipped lines %v, got %v", expectedLines, report.Skipped)
+	}
+	for i, line := range expectedLines {
+		if report.Skipped[i] != line {
+			t.Errorf("Expected skipped lines %v, got %v", expectedLines, report.Skipped)
+		}
+	}
+	if report.BadRows != nil {
+		t.Errorf("Expected bad rows to be kept only when collecting")
+	}
+	if class := space.Attribute("class"); class == nil || class.Kind != AttributeInt || class.Ints[3] != 6 {
+		t.Errorf("Expected the class of the good rows, got %v", space.Attributes)
+	}
+
+	// Collecting keeps the bad rows too
+	space = NewSpace3D()
+	report, err = space.ReadPointsFromCSV(strings.NewReader(input), CSVOptions{BadRows: BadRowCollect})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.BadRows) != 3 || report.BadRows[0].Fields[0] != "bad" || report.BadRows[1].Err == nil {
+		t.Errorf("Expected the bad rows with their errors, got %v", report.BadRows)
+	}
+
+	// Decimation keeps every other good row
+	space = NewSpace3D()
+	if _, err := space.ReadPointsFromCSV(strings.NewReader(input), CSVOptions{BadRows: BadRowSkip, Decimate: 2}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 2 || space.Points[1] != NewPoint3D(3, 3, 3) {
+		t.Errorf("Expected points 0 and 3, got %v", space.Points)
+	}
+	if class := space.Attribute("class"); class == nil || len(class.Ints) != 2 || class.Ints[1] != 4 {
+		t.Errorf("Expected the classes of the kept points, got %v", space.Attributes)
+	}
+}
+
+func TestReadPointsFromCSVPresized(t *testing.T) {
+	var builder strings.Builder
+	builder.WriteString("x,y,z,label\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&builder, "%03d,%03d,%03d,p%03d\n", i, i, i, i)
+	}
+	input := builder.String()
+
+	space := NewSpace3D()
+	report, err := space.ReadPointsFromCSV(strings.NewReader(input), CSVOptions{Size: int64(len(input))})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Points != 1000 || space.Points[999] != NewPoint3D(999, 999, 999) {
+		t.Fatalf("Expected 1000 points, got %d", report.Points)
+	}
+	// Rows of equal length give an exact estimate
+	if cap(space.Points) != 1000 {
+		t.Errorf("Expected points allocated for the 1000 rows, got capacity %d", cap(space.Points))
+	}
+	if label := space.Attribute("label"); label == nil || label.Strings[999] != "p999" {
+		t.Errorf("Expected the labels of all points, got %v", label)
+	}
+}
+
+func TestPLYRoundTrip(t *testing.T) {
+	space := NewSpace3D()
+	space.AddMesh([]Point3D{NewPoint3D(0, 0, 0), NewPoint3D(1, 0, 0.5), NewPoint3D(0, 1, -2.25)}, []Face{{0, 1, 2}})
+	space.AddPolyline([]Point3D{NewPoint3D(2, 2, 2), NewPoint3D(3, 3, 3), NewPoint3D(4, 4, 4)})
+	space.SetNormal(0, NewPoint3D(0, 0, 1))
+	space.SetColor(1, color.RGBA{255, 128, 0, 255})
+	space.SetAttribute(0, Attribute{Name: "intensity", Kind: AttributeFloat, Floats: []float64{0.5, 1, 1.5, 2, 2.5, 3}})
+	space.SetAttribute(0, Attribute{Name: "class", Kind: AttributeInt, Ints: []int64{-1, 2, 3}})
+	space.SetAttribute(0, Attribute{Name: "label", Kind: AttributeString, Strings: []string{"a"}})
+
+	for _, format := range []PLYFormat{PLYASCII, PLYBinaryLittleEndian, PLYBinaryBigEndian} {
+		var buf strings.Builder
+		if err := space.WriteMeshToPLY(&buf, format); err != nil {
+			t.Fatalf("%v: unexpected error: %v", format, err)
+		}
+
+		loaded := NewSpace3D()
+		if err := loaded.ReadMeshFromPLY(strings.NewReader(buf.String())); err != nil {
+			t.Fatalf("%v: unexpected error: %v", format, err)
+		}
+		if len(loaded.Points) != 6 || loaded.Points[2] != NewPoint3D(0, 1, -2.25) {
+			t.Errorf("%v: expected the points back, got %v", format, loaded.Points)
+		}
+		if len(loaded.Faces) != 1 || len(loaded.Faces[0]) != 3 || loaded.Faces[0][2] != 2 {
+			t.Errorf("%v: expected the face back, got %v", format, loaded.Faces)
+		}
+		if len(loaded.Polylines) != 2 || loaded.Polylines[1][0] != 4 || loaded.Polylines[1][1] != 5 {
+			t.Errorf("%v: expected a polyline for each segment, got %v", format, loaded.Polylines)
+		}
+		if n, ok := loaded.Normal(0); !ok || n != NewPoint3D(0, 0, 1) {
+			t.Errorf("%v: expected the normal back, got %v", format, n)
+		}
+		if _, ok := loaded.Normal(1); ok {
+			t.Errorf("%v: expected no normal at point 1", format)
+		}
+		if c, ok := loaded.Color(1); !ok || c != (color.RGBA{255, 128, 0, 255}) {
+			t.Errorf("%v: expected the color back, got %v", format, c)
+		}
+		if _, ok := loaded.Color(0); ok {
+			t.Errorf("%v: expected no color at point 0", format)
+		}
+
+		// Numeric attributes survive, text ones are left out
+		if len(loaded.Attributes) != 2 {
+			t.Fatalf("%v: expected attributes intensity and class, got %v", format, loaded.Attributes)
+		}
+		if attr := loaded.Attribute("intensity"); attr == nil || attr.Kind != AttributeFloat || attr.Floats[5] != 3 {
+			t.Errorf("%v: expected intensity back, got %v", format, attr)
+		}
+		if attr := loaded.Attribute("class"); attr == nil || attr.Kind != AttributeInt || attr.Ints[0] != -1 || attr.Ints[5] != 0 {
+			t.Errorf("%v: expected class back, got %v", format, attr)
+		}
+	}
+}
+
+func TestReadMeshFromPLY(t *testing.T) {
+	input := `ply
+format ascii 1.0
+comment made by hand
+element vertex 3
+property float x
+property float y
+property float z
+property float red
+property float green
+property float blue
+property list uchar int ignored
+element material 1
+property uchar id
+element face 1
+property list uchar uint vertex_index
+property uchar flags
+end_header
+0 0 0 1 0 0 2 7 8
+1 0 0 0 0.5 0 0
+0 1 0 0 0 1 1 9
+4
+3 0 1 2 0
+`
+	space := NewSpace3D()
+	space.AddPoint(NewPoint3D(5, 5, 5))
+	if err := space.ReadMeshFromPLY(strings.NewReader(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 4 || len(space.Attributes) != 0 {
+		t.Errorf("Expected 3 more points and no attributes, got %v and %v", space.Points, space.Attributes)
+	}
+	if len(space.Faces) != 1 || space.Faces[0][0] != 1 || space.Faces[0][2] != 3 {
+		t.Errorf("Expected the face to refer to the new points, got %v", space.Faces)
+	}
+	if c, _ := space.Color(2); c != (color.RGBA{0, 128, 0, 255}) {
+		t.Errorf("Expected colors scaled from 0 to 1, got %v", c)
+	}
+
+	// A face outside the vertices fails and leaves the space as it was
+	bad := strings.Replace(input, "3 0 1 2 0", "3 0 1 3 0", 1)
+	if err := space.ReadMeshFromPLY(strings.NewReader(bad)); err == nil {
+		t.Errorf("Expected an error for a vertex out of range")
+	}
+	if len(space.Points) != 4 || len(space.Faces) != 1 {
+		t.Errorf("Expected the space to be unchanged, got %d points and %d faces", len(space.Points), len(space.Faces))
+	}
+
+	// Truncated binary data fails
+	var buf strings.Builder
+	if err := space.WriteMeshToPLY(&buf, PLYBinaryLittleEndian); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := NewSpace3D().ReadMeshFromPLY(strings.NewReader(buf.String()[:buf.Len()-3])); err == nil {
+		t.Errorf("Expected an error for truncated data")
+	}
+}
+
+func TestReadMeshFromOBJ(t *testing.T) {
+	input := `# a square and a line
+mtllib square.mtl
+v 0 0 0
+v 1 0 0 1 0 0
+v 1 1 0
+v 0 1 0
+v 0 2 0
+vt 0 0
+vn 0 0 1
+g bottom
+f 1/1/1 2/1/1 3//1
+g top
+f -2//1 -1//1 -4//1
+o path
+l 1 3
+`
+	space := NewSpace3D()
+	space.AddPoint(NewPoint3D(7, 7, 7))
+	if err := space.ReadMeshFromOBJ(strings.NewReader(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(space.Points) != 6 || len(space.Faces) != 2 || len(space.Polylines) != 1 {
+		t.Fatalf("Expected 5 more points, 2 faces and a line, got %d, %d and %d", len(space.Points), len(space.Faces), len(space.Polylines))
+	}
+	if space.Faces[1][0] != 4 || space.Faces[1][2] != 2 || space.Polylines[0][1] != 3 {
+		t.Errorf("Expected indices from 1 and from the end to refer to the new points, got %v and %v", space.Faces, space.Polylines)
+	}
+	if n, ok := space.Normal(3); !ok || n != NewPoint3D(0, 0, 1) {
+		t.Errorf("Expected the face normal at point 3, got %v", n)
+	}
+	if c, ok := space.Color(2); !ok || c != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("Expected the vertex color, got %v", c)
+	}
+	if groups := space.Attribute("group"); groups == nil || groups.Text(3) != "bottom" || groups.Text(4) != "top" || groups.Text(0) != "" {
+		t.Errorf("Expected the group of each point's first face, got %v", groups)
+	}
+
+	// Written back, groups, normals, colors and lines survive
+	var buf strings.Builder
+	if err := space.WriteMeshToOBJ(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{"v 1 0 0 1 0 0\n", "vn 0 0 1\n", "g bottom\nf 2//1 3//2 4//3\ng top\nf 5//4 6//5 3//2\n", "l 2 4\n"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected OBJ data to contain %q, got %q", line, buf.String())
+		}
+	}
+
+	// Bad references fail and leave the space as it was
+	if err := space.ReadMeshFromOBJ(strings.NewReader("v 0 0 0\nf 1 2 3\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error at line 2, got %v", err)
+	}
+	if len(space.Points) != 6 {
+		t.Errorf("Expected the space to be unchanged, got %d points", len(space.Points))
+	}
+}
+
+func TestSTLRoundTrip(t *testing.T) {
+	space := NewSpace3D()
+	space.AddGrid(2, 3, []Point3D{
+		NewPoint3D(0, 0, 1), NewPoint3D(1, 0, 1), NewPoint3D(2, 0, 1),
+		NewPoint3D(0, 1, 1), NewPoint3D(1, 1, 2), NewPoint3D(2, 1, 1),
+	}, nil)
+
+	for _, format := range []STLFormat{STLBinary, STLASCII} {
+		var buf strings.Builder
+		if err := space.WriteMeshToSTL(&buf, format); err != nil {
+			t.Fatalf("%v: unexpected error: %v", format, err)
+		}
+		if format == STLBinary && buf.Len() != 84+4*50 {
+			t.Errorf("%v: expected 4 triangles in %d bytes, got %d bytes", format, 84+4*50, buf.Len())
+		}
+
+		// Triangles sharing corners share points again
+		loaded := NewSpace3D()
+		if err := loaded.ReadMeshFromSTL(strings.NewReader(buf.String())); err != nil {
+			t.Fatalf("%v: unexpected error: %v", format, err)
+		}
+		if len(loaded.Points) != 6 || len(loaded.Faces) != 4 {
+			t.Errorf("%v: expected 6 points and 4 triangles, got %d and %d", format, len(loaded.Points), len(loaded.Faces))
+		}
+		if loaded.Points[loaded.Faces[0][2]] != NewPoint3D(1, 1, 2) {
+			t.Errorf("%v: expected the corners in order, got %v", format, loaded.Faces[0])
+		}
+	}
+
+	// Binary headers starting with solid are told apart from text by the file
+	// size, or failing that by the triangle count not being text
+	var buf strings.Builder
+	if err := space.WriteMeshToSTL(&buf, STLBinary); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := "solid" + buf.String()[5:]
+	filePath := filepath.Join(t.TempDir(), "mesh.stl")
+	if err := os.WriteFile(filePath, []byte(data), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fromFile := NewSpace3D()
+	if err := fromFile.LoadMeshFromSTL(filePath); err != nil || len(fromFile.Faces) != 4 {
+		t.Errorf("Expected 4 triangles from a file, got %d and error %v", len(fromFile.Faces), err)
+	}
+	unsized := NewSpace3D()
+	if err := unsized.ReadMeshFromSTL(io.MultiReader(strings.NewReader(data))); err != nil || len(unsized.Faces) != 4 {
+		t.Errorf("Expected 4 triangles from a reader of unknown size, got %d and error %v", len(unsized.Faces), err)
+	}
+	if err := unsized.ReadMeshFromSTL(io.MultiReader(strings.NewReader(data[:len(data)-10]))); err == nil || !strings.Contains(err.Error(), "triangle 4 of 4") {
+		t.Errorf("Expected an error for the truncated last triangle, got %v", err)
+	}
+	if len(unsized.Faces) != 4 {
+		t.Errorf("Expected the space to be unchanged, got %d faces", len(unsized.Faces))
+	}
+
+	if err := NewSpace3D().ReadMeshFromSTL(strings.NewReader("not an stl file")); err == nil {
+		t.Errorf("Expected an error for data that isn't STL")
+	}
+}
+
+func TestSolidify(t *testing.T) {
+	space := NewSpace3D()
+	space.AddGrid(3, 3, []Point3D{
+		NewPoint3D(0, 0, 1), NewPoint3D(1, 0, 1), NewPoint3D(2, 0, 1),
+		NewPoint3D(0, 1, 1), NewPoint3D(1, 1, 3), NewPoint3D(2, 1, 1),
+		NewPoint3D(0, 2, 1), NewPoint3D(1, 2, 1), NewPoint3D(2, 2, 1),
+	}, nil)
+
+	solid := space.Solidify(-1)
+	if len(solid.Points) != 18 || len(solid.Faces) != 4+4+8 {
+		t.Fatalf("Expected 18 points and 16 faces, got %d and %d", len(solid.Points), len(solid.Faces))
+	}
+
+	// Watertight: each edge is used once in each direction
+	type edge struct{ from, to int }
+	uses := make(map[edge]int)
+	for _, face := range solid.Faces {
+		for i := range face {
+			uses[edge{face[i], face[(i+1)%len(face)]}]++
+		}
+	}
+	for e, n := range uses {
+		if n != 1 || uses[edge{e.to, e.from}] != 1 {
+			t.Errorf("Expected edge %v to be used once each way, got %d and %d", e, n, uses[edge{e.to, e.from}])
+		}
+	}
+	for _, p := range solid.Points {
+		if p.Z != -1 && p.Z < 1 {
+			t.Errorf("Expected points on the surface or the base, got %v", p)
+		}
+	}
+}
+
+// allocatedBytes returns the number of bytes allocated on the heap while f
+// runs
+func allocatedBytes(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestLASRoundTrip(t *testing.T) {
+	space := NewSpace3D()
+	space.AddPoint(NewPoint3D(1000.25, 2000.5, 10))
+	space.AddPoint(NewPoint3D(1001.125, 2003, 12.75))
+	space.AddPoint(NewPoint3D(999.5, 1999, -3.001))
+	space.SetColor(1, color.RGBA{255, 128, 0, 255})
+	space.SetAttribute(0, Attribute{Name: "intensity", Kind: AttributeInt, Ints: []int64{100, 200, 300}})
+	space.SetAttribute(0, Attribute{Name: "classification", Kind: AttributeInt, Ints: []int64{2, 6, 9}})
+	space.SetAttribute(0, Attribute{Name: "return_number", Kind: AttributeInt, Ints: []int64{1, 2, 1}})
+	space.SetAttribute(0, Attribute{Name: "gps_time", Kind: AttributeFloat, Floats: []float64{1.5, 2.5, 3.5}})
+
+	roundTrip := func(format, minor byte) *Space3D {
+		var buf strings.Builder
+		if err := space.WritePointsToLAS(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		data := buf.String()
+		if data[25] != minor || data[104] != format {
+			t.Errorf("Expected LAS 1.%d in point data format %d, got 1.%d in %d", minor, format, data[25], data[104])
+		}
+		loaded := NewSpace3D()
+		if err := loaded.ReadPointsFromLAS(strings.NewReader(data)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(loaded.Points) != 3 {
+			t.Fatalf("Expected 3 points, got %d", len(loaded.Points))
+		}
+		for i, p := range space.Points {
+			q := loaded.Points[i]
+			if math.Abs(p.X-q.X) > 1e-9 || math.Abs(p.Y-q.Y) > 1e-9 || math.Abs(p.Z-q.Z) > 1e-9 {
+				t.Errorf("Expected point %d at %v, got %v", i, p, q)
+			}
+		}
+		if c, ok := loaded.Color(1); !ok || c != (color.RGBA{255, 128, 0, 255}) {
+			t.Errorf("Expected the color back, got %v", c)
+		}
+		if attr := loaded.Attribute("intensity"); attr == nil || attr.Kind != AttributeInt || attr.Ints[2] != 300 {
+			t.Errorf("Expected intensity back, got %v", attr)
+		}
+		if attr := loaded.Attribute("return_number"); attr == nil || attr.Ints[1] != 2 {
+			t.Errorf("Expected return numbers back, got %v", attr)
+		}
+		if attr := loaded.Attribute("gps_time"); attr == nil || attr.Kind != AttributeFloat || attr.Floats[2] != 3.5 {
+			t.Errorf("Expected GPS times back, got %v", attr)
+		}
+		return loaded
+	}
+
+	// Classic fields fit LAS 1.2, with GPS times and colors in format 3
+	loaded := roundTrip(3, 2)
+	if attr := loaded.Attribute("classification"); attr == nil || attr.Ints[1] != 6 {
+		t.Errorf("Expected classes back, got %v", attr)
+	}
+
+	// Classes above 31 need LAS 1.4
+	space.SetAttribute(0, Attribute{Name: "classification", Kind: AttributeInt, Ints: []int64{2, 64, 9}})
+	loaded = roundTrip(7, 4)
+	if attr := loaded.Attribute("classification"); attr == nil || attr.Ints[1] != 64 {
+		t.Errorf("Expected class 64 back, got %v", attr)
+	}
+
+	if err := NewSpace3D().ReadPointsFromLAS(strings.NewReader("not a las file")); err == nil {
+		t.Errorf("Expected an error for data that isn't LAS")
+	}
+
+	// Compressed LAZ data is refused
+	var buf strings.Builder
+	space.WritePointsToLAS(&buf)
+	laz := []byte(buf.String())
+	laz[104] |= 0x80
+	if err := NewSpace3D().ReadPointsFromLAS(strings.NewReader(string(laz))); err == nil || !strings.Contains(err.Error(), "LAZ") {
+		t.Errorf("Expected an error for LAZ data, got %v", err)
+	}
+
+	// A header claiming more points than the data holds fails when the
+	// records run out, having allocated little
+	truncated := []byte(buf.String())
+	truncated = truncated[:endian.LittleEndian.Uint32(truncated[96:])]
+	endian.LittleEndian.PutUint32(truncated[107:], math.MaxUint32)
+	if truncated[25] >= 4 {
+		endian.LittleEndian.PutUint64(truncated[247:], math.MaxUint64)
+	}
+	var err error
+	allocated := allocatedBytes(func() {
+		err = NewSpace3D().ReadPointsFromLAS(strings.NewReader(string(truncated)))
+	})
+	if err == nil || !strings.Contains(err.Error(), "point 0") {
+		t.Errorf("Expected an error reading point 0, got %v", err)
+	}
+	if allocated > 1<<20 {
+		t.Errorf("Expected little to be allocated for missing points, got %d bytes", allocated)
+	}
+}
+
+func TestXYZAndPTSRoundTrip(t *testing.T) {
+	space := NewSpace3D()
+	space.AddPoint(NewPoint3D(1, 2, 3))
+	space.AddPoint(NewPoint3D(-1.5, 0.25, 1e6))
+	space.SetColor(1, color.RGBA{255, 128, 0, 255})
+	space.SetAttribute(0, Attribute{Name: "intensity", Kind: AttributeInt, Ints: []int64{-200, 1500}})
+
+	var xyz strings.Builder
+	if err := space.WritePointsToXYZ(&xyz); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if xyz.String() != "1 2 3 0 0 0\n-1.5 0.25 1000000 255 128 0\n" {
+		t.Errorf("Unexpected XYZ data:\n%s", xyz.String())
+	}
+	var pts strings.Builder
+	if err := space.WritePointsToPTS(&pts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pts.String() != "2\n1 2 3 -200 0 0 0\n-1.5 0.25 1000000 1500 255 128 0\n" {
+		t.Errorf("Unexpected PTS data:\n%s", pts.String())
+	}
+
+	loaded := NewSpace3D()
+	if err := loaded.ReadPointsFromPTS(strings.NewReader(pts.String())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(loaded.Points) != 2 || loaded.Points[1] != NewPoint3D(-1.5, 0.25, 1e6) {
+		t.Errorf("Expected the points back, got %v", loaded.Points)
+	}
+	if c, ok := loaded.Color(1); !ok || c != (color.RGBA{255, 128, 0, 255}) {
+		t.Errorf("Expected the color back, got %v", c)
+	}
+	if attr := loaded.Attribute("intensity"); attr == nil || attr.Kind != AttributeInt || attr.Ints[0] != -200 {
+		t.Errorf("Expected intensity back, got %v", attr)
+	}
+
+	// Columns that can't be colors are kept as attributes
+	loaded = NewSpace3D()
+	data := "# x y z nx ny nz\n0 0 0 0 0 1\n\n1 0 0 0.5 0.5 0.7\n"
+	if err := loaded.ReadPointsFromXYZ(strings.NewReader(data)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(loaded.Points) != 2 || len(loaded.Colors) != 0 || len(loaded.Attributes) != 3 {
+		t.Fatalf("Expected 2 points with 3 attributes and no colors, got %v", loaded)
+	}
+	if attr := loaded.Attribute("col5"); attr == nil || attr.Kind != AttributeFloat || attr.Floats[1] != 0.7 {
+		t.Errorf("Expected col5 kept, got %v", attr)
+	}
+
+	if err := NewSpace3D().ReadPointsFromXYZ(strings.NewReader("1 2\n")); err == nil {
+		t.Errorf("Expected an error for a point without z")
+	}
+
+	// PTS counts are only a guide, and an overstated one costs nothing
+	loaded = NewSpace3D()
+	var err error
+	allocated := allocatedBytes(func() {
+		err = loaded.ReadPointsFromPTS(strings.NewReader("2000000000\n1 2 3\n"))
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(loaded.Points) != 1 {
+		t.Errorf("Expected 1 point, got %v", loaded.Points)
+	}
+	if allocated > 1<<20 {
+		t.Errorf("Expected little to be allocated for the count, got %d bytes", allocated)
+	}
+}
+
+func TestPCDRoundTrip(t *testing.T) {
+	space := NewSpace3D()
+	space.AddPoint(NewPoint3D(1, 2, 3))
+	space.AddPoint(NewPoint3D(-1.5, 0.25, 8))
+	space.SetNormal(0, NewPoint3D(0, 0, 1))
+	space.SetColor(1, color.RGBA{255, 128, 1, 255})
+	space.SetAttribute(0, Attribute{Name: "class", Kind: AttributeInt, Ints: []int64{-1, 7}})
+	space.SetAttribute(0, Attribute{Name: "gps time", Kind: AttributeFloat, Floats: []float64{0.1, 1e10 + 0.5}})
+	space.SetAttribute(0, Attribute{Name: "label", Kind: AttributeString, Strings: []string{"a", "b"}})
+
+	for _, format := range []PCDFormat{PCDASCII, PCDBinary} {
+		var buf strings.Builder
+		if err := space.WritePointsToPCD(&buf, format); err != nil {
+			t.Fatalf("%v: unexpected error: %v", format, err)
+		}
+		if !strings.Contains(buf.String(), "FIELDS x y z normal_x normal_y normal_z rgb class gps_time\n") {
+			t.Errorf("%v: unexpected header:\n%s", format, buf.String())
+		}
+
+		loaded := NewSpace3D()
+		if err := loaded.ReadPointsFromPCD(strings.NewReader(buf.String())); err != nil {
+			t.Fatalf("%v: unexpected error: %v", format, err)
+		}
+		if len(loaded.Points) != 2 || loaded.Points[1] != NewPoint3D(-1.5, 0.25, 8) {
+			t.Errorf("%v: expected the points back, got %v", format, loaded.Points)
+		}
+		if n, ok := loaded.Normal(0); !ok || n != NewPoint3D(0, 0, 1) {
+			t.Errorf("%v: expected the normal back, got %v", format, n)
+		}
+		if c, ok := loaded.Color(1); !ok || c != (color.RGBA{255, 128, 1, 255}) {
+			t.Errorf("%v: expected the color back, got %v", format, c)
+		}
+		if attr := loaded.Attribute("class"); attr == nil || attr.Kind != AttributeInt || attr.Ints[0] != -1 {
+			t.Errorf("%v: expected class back, got %v", format, attr)
+		}
+		if attr := loaded.Attribute("gps_time"); attr == nil || attr.Kind != AttributeFloat || attr.Floats[1] != 1e10+0.5 {
+			t.Errorf("%v: expected GPS times back, got %v", format, attr)
+		}
+		if loaded.Attribute("label") != nil {
+			t.Errorf("%v: expected text attributes left out", format)
+		}
+	}
+}
+
+func TestReadPointsFromPCD(t *testing.T) {
+	// Colors packed into an unsigned integer, a histogram that is skipped
+	// and a missing point of an organized cloud
+	data := `# .PCD v0.7 - Point Cloud Data file format
+VERSION 0.7
+FIELDS x y z rgb hist intensity
+SIZE 4 4 4 4 4 2
+TYPE F F F U F U
+COUNT 1 1 1 1 3 1
+WIDTH 3
+HEIGHT 1
+POINTS 3
+DATA ascii
+1 2 3 16744448 0 0 0 10
+nan nan nan 0 0 0 0 20
+4 5 6 255 1 2 3 30
+`
+	space := NewSpace3D()
+	if err := space.ReadPointsFromPCD(strings.NewReader(data)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(space.Points) != 2 || space.Points[1] != NewPoint3D(4, 5, 6) {
+		t.Fatalf("Expected the 2 valid points, got %v", space.Points)
+	}
+	if c, _ := space.Color(0); c != (color.RGBA{255, 128, 0, 255}) {
+		t.Errorf("Expected orange, got %v", c)
+	}
+	if len(space.Attributes) != 1 || space.Attributes[0].Name != "intensity" || space.Attributes[0].Ints[1] != 30 {
+		t.Errorf("Expected only intensity, got %v", space.Attributes)
+	}
+
+	compressed := strings.Replace(data, "DATA ascii", "DATA binary_compressed", 1)
+	if err := NewSpace3D().ReadPointsFromPCD(strings.NewReader(compressed)); err == nil {
+		t.Errorf("Expected an error for compressed data")
+	}
+
+	// Fields holding more values than any point could are refused
+	huge := "FIELDS x y z h\nCOUNT 1 1 1 4000000000000000000\nPOINTS 1\nDATA ascii\n1 2 3 4\n"
+	if err := NewSpace3D().ReadPointsFromPCD(strings.NewReader(huge)); err == nil {
+		t.Errorf("Expected an error for a field with too many values")
+	}
+
+	// A header claiming more points than the data holds fails when the
+	// points run out, having allocated little
+	overcounted := strings.Replace(data, "POINTS 3", "POINTS 2000000000", 1)
+	var err error
+	allocated := allocatedBytes(func() {
+		err = NewSpace3D().ReadPointsFromPCD(strings.NewReader(overcounted))
+	})
+	if err == nil || !strings.Contains(err.Error(), "3 points instead of 2000000000") {
+		t.Errorf("Expected an error for missing points, got %v", err)
+	}
+	if allocated > 1<<20 {
+		t.Errorf("Expected little to be allocated for missing points, got %d bytes", allocated)
+	}
+}
+
+func TestFileFormats(t *testing.T) {
+	for _, c := range []struct {
+		fileName, header, format string
+	}{
+		{"scan.PLY", "", "ply"},
+		{"cloud.pcd", "", "pcd"},
+		{"survey.las", "", "las"},
+		{"points.xyz", "", "xyz"},
+		{"upload", "ply\nformat ascii 1.0\n", "ply"},
+		{"upload", "LASF", "las"},
+		{"upload", "# .PCD v0.7", "pcd"},
+		{"points.txt", "x,y,z\n", "csv"},
+		{"points.xyz", "LASF", "xyz"},
+	} {
+		if format := DetectFileFormat(c.fileName, []byte(c.header)); format.Name != c.format {
+			t.Errorf("%s with %q: expected %s, got %s", c.fileName, c.header, c.format, format.Name)
+		}
+	}
+
+	// Formats can be added, and are found by their own extension and magic.
+	// The registry is put back afterwards for the other tests.
+	formatsMu.RLock()
+	registered := append([]FileFormat(nil), fileFormats...)
+	formatsMu.RUnlock()
+	t.Cleanup(func() {
+		formatsMu.Lock()
+		defer formatsMu.Unlock()
+		fileFormats = registered
+	})
+	err := RegisterFileFormat(FileFormat{
+		Name:       "test",
+		Extensions: []string{".TST"},
+		Magic:      []string{"TEST"},
+		Read: func(s *Space3D, r io.Reader) error {
+			s.AddPoint(NewPoint3D(1, 2, 3))
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	space := NewSpace3D()
+	if err := space.ReadFile(strings.NewReader("TEST data"), "upload"); err != nil || len(space.Points) != 1 {
+		t.Errorf("Expected the test format to read a point, got %v and %v", err, space.Points)
+	}
+	if format := DetectFileFormat("a.tst", nil); format.Name != "test" {
+		t.Errorf("Expected the test format for .tst, got %s", format.Name)
+	}
+	if err := space.SaveFile(filepath.Join(t.TempDir(), "a.tst"), FormatOptions{}); err == nil {
+		t.Errorf("Expected an error saving a format without a writer")
+	}
+	if err := RegisterFileFormat(FileFormat{Name: "bad", Extensions: []string{"bad"}, Read: (*Space3D).ReadPointsFromXYZ}); err == nil {
+		t.Errorf("Expected an error for an extension without a dot")
+	}
+
+	// Saving and loading goes through the format of the extension
+	path := filepath.Join(t.TempDir(), "points.pcd")
+	if err := space.SaveFile(path, FormatOptions{PCD: PCDBinary}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loaded := NewSpace3D()
+	if err := loaded.LoadFile(path); err != nil || len(loaded.Points) != 1 || loaded.Points[0] != NewPoint3D(1, 2, 3) {
+		t.Errorf("Expected the point back, got %v and %v", err, loaded.Points)
+	}
+}
+
+func TestUpAxis(t *testing.T) {
+	// The up axis is drawn toward the top of the screen, with the other
+	// axes right-handed around it
+	for _, c := range []struct {
+		up           UpAxis
+		up3D, toward Point3D
+		right3D      Point3D
+	}{
+		{UpZ, NewPoint3D(0, 0, 1), NewPoint3D(0, -1, 0), NewPoint3D(1, 0, 0)},
+		{UpY, NewPoint3D(0, 1, 0), NewPoint3D(0, 0, 1), NewPoint3D(1, 0, 0)},
+	} {
+		v := &Visualizer{upAxis: c.up, scale: 50}
+		_, oy := v.project3DTo2D(Point3D{})
+		if _, y := v.project3DTo2D(c.up3D); y >= oy {
+			t.Errorf("%s: expected %v above the origin, got screen y %v below %v", upAxisNames[c.up], c.up3D, y, oy)
+		}
+		if x, _ := v.project3DTo2D(c.right3D); x <= 0 {
+			t.Errorf("%s: expected %v to the right, got screen x %v", upAxisNames[c.up], c.right3D, x)
+		}
+		if _, _, z := v.rotate3D(c.toward); z >= 0 {
+			t.Errorf("%s: expected %v toward the viewer, got depth %v", upAxisNames[c.up], c.toward, z)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ptsIntensity is the name of the attribute holding the intensity of PTS
+// points
+const ptsIntensity = "intensity"
+
+// LoadPointsFromXYZ loads points from an XYZ file as described for
+// ReadPointsFromXYZ
+func (s *Space3D) LoadPointsFromXYZ(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open XYZ file: %w", err)
+	}
+	defer file.Close()
+	return s.ReadPointsFromXYZ(file)
+}
+
+// ReadPointsFromXYZ reads points from XYZ data: a point on each line, as x,
+// y and z separated by whitespace. When the next three columns hold whole
+// numbers from 0 to 255 on every line they are the red, green and blue of
+// the point's color; other columns are kept as attributes named "col" and
+// their index, as for headerless CSV files. Blank lines and lines starting
+// with # are skipped. When reading fails, the space is left as it was.
+func (s *Space3D) ReadPointsFromXYZ(r io.Reader) error {
+	return s.readPointColumns(r, "XYZ", nil)
+}
+
+// LoadPointsFromPTS loads points from a PTS file as described for
+// ReadPointsFromPTS
+func (s *Space3D) LoadPointsFromPTS(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open PTS file: %w", err)
+	}
+	defer file.Close()
+	return s.ReadPointsFromPTS(file)
+}
+
+// ReadPointsFromPTS reads points from PTS data, as written by laser
+// scanners: blocks of points, each after a line with their count, and each
+// point on a line as x, y and z, then optionally an intensity and the red,
+// green and blue of its color from 0 to 255, separated by whitespace. The
+// intensities are kept in an "intensity" attribute, and further columns as
+// for ReadPointsFromXYZ. When reading fails, the space is left as it was.
+func (s *Space3D) ReadPointsFromPTS(r io.Reader) error {
+	return s.readPointColumns(r, "PTS", []string{ptsIntensity})
+}
+
+// readPointColumns reads points given as whitespace-separated columns, with
+// the columns after the coordinates named by names, then taken as colors if
+// they can be, then named by their index. Lines with a single whole number
+// are counts of the points that follow, which PTS data starts blocks with.
+func (s *Space3D) readPointColumns(r io.Reader, formatName string, names []string) error {
+	var points []Point3D
+	var builders []*attributeBuilder
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) == 1 {
+			if count, err := strconv.Atoi(fields[0]); err != nil || count < 0 {
+				return fmt.Errorf("invalid %s line %d: a point needs x, y and z", formatName, lineNumber)
+			}
+			continue
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("invalid %s line %d: a point needs x, y and z", formatName, lineNumber)
+		}
+
+		var coords [3]float64
+		for axis := range coords {
+			value, err := strconv.ParseFloat(fields[axis], 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s line %d: invalid %c coordinate %q", formatName, lineNumber, "XYZ"[axis], fields[axis])
+			}
+			coords[axis] = value
+		}
+		points = append(points, NewPoint3D(coords[0], coords[1], coords[2]))
+
+		// Start a column on the first line that has it, with the earlier
+		// points missing its values
+		for len(builders) < len(fields)-3 {
+			column := len(builders) + 3
+			name := "col" + strconv.Itoa(column)
+			if len(builders) < len(names) {
+				name = names[len(builders)]
+			}
+			b := &attributeBuilder{name: name, kind: AttributeInt}
+			for range points[1:] {
+				b.add("")
+			}
+			builders = append(builders, b)
+		}
+		for i, b := range builders {
+			field := ""
+			if 3+i < len(fields) {
+				field = fields[3+i]
+			}
+			b.add(field)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s file: %w", formatName, err)
+	}
+
+	attrs := make([]Attribute, len(builders))
+	for i, b := range builders {
+		attrs[i] = b.attribute()
+	}
+
+	// The three columns after the named ones are colors if they fit
+	var colors []color.RGBA
+	if first := len(names); len(attrs) >= first+3 {
+		if rgb, ok := combineRGB("rgb", attrs[first], attrs[first+1], attrs[first+2]); ok && len(rgb.Colors) == len(points) {
+			colors = rgb.Colors
+			attrs = append(attrs[:first], attrs[first+3:]...)
+		}
+	}
+	for _, attr := range attrs {
+		if existing := s.Attribute(attr.Name); existing != nil && existing.Kind != attr.Kind {
+			return fmt.Errorf("attribute %s holds %v values, not %v", attr.Name, existing.Kind, attr.Kind)
+		}
+	}
+
+	start := len(s.Points)
+	s.Points = append(s.Points, points...)
+	for i, c := range colors {
+		s.SetColor(start+i, c)
+	}
+	for _, attr := range attrs {
+		s.SetAttribute(start, attr)
+	}
+	return nil
+}
+
+// SavePointsToXYZ saves the points of the space to an XYZ file as described
+// for WritePointsToXYZ
+func (s *Space3D) SavePointsToXYZ(filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create XYZ file: %w", err)
+	}
+	defer file.Close()
+	return s.WritePointsToXYZ(file)
+}
+
+// WritePointsToXYZ writes the points of the space as XYZ data, a line of x,
+// y and z for each point, followed by the red, green and blue of its color
+// when the space has colors. Points without a color are written as black.
+// Attributes are left out.
+func (s *Space3D) WritePointsToXYZ(w io.Writer) error {
+	return s.writePointColumns(w, "XYZ", false)
+}
+
+// SavePointsToPTS saves the points of the space to a PTS file as described
+// for WritePointsToPTS
+func (s *Space3D) SavePointsToPTS(filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create PTS file: %w", err)
+	}
+	defer file.Close()
+	return s.WritePointsToPTS(file)
+}
+
+// WritePointsToPTS writes the points of the space as PTS data: the number of
+// points, then a line for each point of x, y, z and its intensity, taken from
+// an "intensity" attribute or else zero, followed by the red, green and blue
+// of its color when the space has colors. Points without a color are written
+// as black.
+func (s *Space3D) WritePointsToPTS(w io.Writer) error {
+	return s.writePointColumns(w, "PTS", true)
+}
+
+// writePointColumns writes a line for each point as described for
+// WritePointsToXYZ, with a count and intensities for PTS
+func (s *Space3D) writePointColumns(w io.Writer, formatName string, pts bool) error {
+	writer := bufio.NewWriter(w)
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	var intensity *Attribute
+	if pts {
+		if attr := s.Attribute(ptsIntensity); attr != nil && attr.Numeric() {
+			intensity = attr
+		}
+		fmt.Fprintln(writer, len(s.Points))
+	}
+	hasColors := len(s.Colors) > 0
+
+	for i, p := range s.Points {
+		line := format(p.X) + " " + format(p.Y) + " " + format(p.Z)
+		if pts {
+			value := 0.0
+			if intensity != nil {
+				if v, ok := intensity.Value(i); ok {
+					value = v
+				}
+			}
+			line += " " + format(value)
+		}
+		if hasColors {
+			c, _ := s.Color(i)
+			line += fmt.Sprintf(" %d %d %d", c.R, c.G, c.B)
+		}
+		if _, err := fmt.Fprintln(writer, line); err != nil {
+			return fmt.Errorf("error writing point to %s: %w", formatName, err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing %s file: %w", formatName, err)
+	}
+	return nil
+}
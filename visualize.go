@@ -1,19 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"io"
 	"math"
-	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/layout"
@@ -23,6 +29,16 @@ import (
 
 // Visualizer represents a 3D points visualizer
 type Visualizer struct {
+	// mu guards what the canvas is drawn from, which event handlers and
+	// background generation change while the canvas draws in a goroutine
+	// of its own
+	mu sync.Mutex
+
+	// Cancels the function plot being generated in the background, if any,
+	// and counts the generations so that only the latest is shown
+	cancelGeneration context.CancelFunc
+	generation       int
+
 	space     *Space3D
 	app       fyne.App
 	window    fyne.Window
@@ -48,6 +64,126 @@ type Visualizer struct {
 	rotateMode bool
 	panMode    bool
 	rKeyPressed bool
+
+	// Function currently plotted, if any
+	plot *functionPlot
+
+	// How faces are drawn
+	renderMode renderMode
+
+	// Which axis of the points is drawn as the view's vertical axis
+	upAxis UpAxis
+
+	// Whether gradient arrows are drawn over the function surface
+	showGradient bool
+
+	// Whether the samples where the function failed are marked
+	showGaps bool
+
+	// Length of the longest vector field arrow, in the units of the points
+	arrowLength float64
+
+	// What the colors of points are mapped from: a point attribute,
+	// ColorByHeight or ColorByDistance, or "" for the points' own colors
+	colorSource string
+
+	// Names of the point attributes that set the size of points and are shown
+	// in hover labels, or "" for none
+	sizeAttribute  string
+	labelAttribute string
+
+	// Colormap of the color source, and the range of numbers it spans, with
+	// NaN for ends found from the points
+	colormap           *Colormap
+	colorMin, colorMax float64
+
+	// Scales of the color source and size attribute, found again for the
+	// next frame once the points or what they are colored and sized by
+	// change, when scalesReady is cleared
+	attributeScales attributeScales
+	scalesReady     bool
+}
+
+// attributeScales map attribute values to colors and sizes
+type attributeScales struct {
+	// color is nil when points have their own colors
+	color            *ColorScale
+	sizeMin, sizeMax float64
+}
+
+// Color sources other than point attributes. An attribute of the same name
+// takes their place.
+const (
+	// ColorByHeight colors points by their coordinate along the up axis
+	ColorByHeight = "height"
+	// ColorByDistance colors points by their distance from the origin
+	ColorByDistance = "distance"
+)
+
+// UpAxis selects which coordinate axis points up in the view
+type UpAxis int
+
+const (
+	// UpY draws the Y axis vertically
+	UpY UpAxis = iota
+	// UpZ draws the Z axis vertically, as is usual for plots of z = f(x, y)
+	UpZ
+)
+
+// upAxisNames are the labels of the up axes in the GUI
+var upAxisNames = []string{"Y up", "Z up"}
+
+// ParseUpAxis parses an up axis name, "y" or "z"
+func ParseUpAxis(s string) (UpAxis, error) {
+	switch strings.ToLower(s) {
+	case "y":
+		return UpY, nil
+	case "z":
+		return UpZ, nil
+	default:
+		return UpY, fmt.Errorf("invalid up axis %q: expected y or z", s)
+	}
+}
+
+// renderMode selects how points with faces between them are drawn
+type renderMode int
+
+const (
+	// renderPoints draws every point and no faces
+	renderPoints renderMode = iota
+	// renderWireframe draws the edges of faces instead of their points
+	renderWireframe
+	// renderShaded fills faces, lit from the viewer
+	renderShaded
+)
+
+// renderModeNames are the labels of the render modes in the GUI
+var renderModeNames = []string{"Points", "Wireframe", "Shaded"}
+
+// functionPlot holds the inputs of a plotted function surface, so the surface
+// can be regenerated when one of its parameters changes
+type functionPlot struct {
+	function   string
+	params     []Param
+	xMin, xMax float64
+	yMin, yMax float64
+	step       float64
+
+	// Adaptive sampling refines the step grid where the surface bends
+	adaptive  bool
+	tolerance float64
+	maxPoints int
+
+	// Complex functions of z = x + iy are plotted as |f(z)| colored by arg f(z)
+	complex bool
+
+	// Samples of the last generated surface that couldn't be evaluated
+	report *EvaluationReport
+
+	// The function and its gradient, for the gradient arrows, or nil when
+	// the function can't be differentiated
+	eval     *FunctionEvaluator
+	gradient *Gradient
 }
 
 // NewVisualizer creates a new 3D visualizer
@@ -68,16 +204,422 @@ func NewVisualizer(space *Space3D) *Visualizer {
 		rKeyPressed: false,
 		hoverX:     0,
 		hoverY:     0,
+		renderMode: renderWireframe,
+		arrowLength: 1,
+		colormap:   Colormaps[0],
+		colorMin:   math.NaN(),
+		colorMax:   math.NaN(),
 	}
 	return vis
 }
 
-// project3DTo2D projects a 3D point onto a 2D plane with simple perspective
-func (v *Visualizer) project3DTo2D(point Point3D) (float32, float32) {
+// SetUpAxis sets which axis of the points is drawn vertically
+func (v *Visualizer) SetUpAxis(axis UpAxis) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.upAxis = axis
+	v.scalesReady = false
+}
+
+// ShowGradient turns the gradient arrows over the function surface on or off
+func (v *Visualizer) ShowGradient(show bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.showGradient = show
+}
+
+// SetArrowLength sets the length of the longest vector field arrow, usually
+// the spacing of the lattice the field was sampled on. Other arrows are
+// scaled in proportion to their vectors.
+func (v *Visualizer) SetArrowLength(length float64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.arrowLength = length
+}
+
+// ColorBy colors points by the named attribute, by ColorByHeight or
+// ColorByDistance, or by their own colors when name is "". Attributes holding
+// colors are used as they are, numbers are mapped over the colormap, and
+// strings are categories, each with a color of its own.
+func (v *Visualizer) ColorBy(name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.colorSource = name
+	v.scalesReady = false
+}
+
+// SetColormap sets the colormap that colors points. With a categorical
+// colormap, each distinct value of a numeric attribute is a category too.
+func (v *Visualizer) SetColormap(colormap *Colormap) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.colormap = colormap
+	v.scalesReady = false
+}
+
+// SetColorRange sets the numbers mapped to the ends of the colormap. Points
+// outside the range get the color of the nearest end. Either end may be NaN
+// to take the smallest or largest value of the points.
+func (v *Visualizer) SetColorRange(min, max float64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.colorMin, v.colorMax = min, max
+	v.scalesReady = false
+}
+
+// SizeBy sizes points by the named numeric attribute, from half the usual
+// size for the smallest value to twice it for the largest, or all alike when
+// name is ""
+func (v *Visualizer) SizeBy(name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sizeAttribute = name
+	v.scalesReady = false
+}
+
+// LabelBy adds the value of the named attribute to the hover label of each
+// point, or nothing when name is ""
+func (v *Visualizer) LabelBy(name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.labelAttribute = name
+}
+
+// colorValue returns the number that the point with the given index is
+// colored by, and whether it has one
+func (v *Visualizer) colorValue(index int) (float64, bool) {
+	if attr := v.space.Attribute(v.colorSource); attr != nil {
+		return attr.Value(index)
+	}
+	if index >= len(v.space.Points) {
+		return 0, false
+	}
+	p := v.space.Points[index]
+	switch v.colorSource {
+	case ColorByHeight:
+		if v.upAxis == UpY {
+			return p.Y, true
+		}
+		return p.Z, true
+	case ColorByDistance:
+		return Magnitude(p), true
+	}
+	return 0, false
+}
+
+// prepareAttributes finds the scales of the color source and size attribute
+func (v *Visualizer) prepareAttributes() {
+	scales := attributeScales{}
+
+	attr := v.space.Attribute(v.colorSource)
+	switch {
+	case attr != nil && attr.Kind == AttributeRGB:
+		// Colors are used as they are
+	case attr != nil && (attr.Kind == AttributeString || v.colormap.Categorical):
+		// Numeric categories are in order of value, others in order of
+		// appearance
+		scales.color = NewColorScale(v.colormap)
+		indices := make([]int, 0, attr.Len())
+		for i := 0; i < attr.Len(); i++ {
+			if attr.Text(i) != "" {
+				indices = append(indices, i)
+			}
+		}
+		if attr.Numeric() {
+			sort.SliceStable(indices, func(a, b int) bool {
+				x, _ := attr.Value(indices[a])
+				y, _ := attr.Value(indices[b])
+				return x < y
+			})
+		}
+		for _, i := range indices {
+			scales.color.AddCategory(attr.Text(i))
+		}
+	case attr != nil || v.colorSource == ColorByHeight || v.colorSource == ColorByDistance:
+		scales.color = NewColorScale(v.colormap)
+		for i := range v.space.Points {
+			if value, ok := v.colorValue(i); ok {
+				scales.color.Include(value)
+			}
+		}
+		if !math.IsNaN(v.colorMin) {
+			scales.color.Min = v.colorMin
+		}
+		if !math.IsNaN(v.colorMax) {
+			scales.color.Max = v.colorMax
+		}
+	}
+
+	if attr := v.space.Attribute(v.sizeAttribute); attr != nil {
+		scales.sizeMin, scales.sizeMax = attr.Range()
+	}
+	v.attributeScales = scales
+}
+
+// pointColor returns the color of the point with the given index, from the
+// color source if there is one and otherwise the point's own color, and
+// whether it has one
+func (v *Visualizer) pointColor(index int) (color.RGBA, bool) {
+	attr := v.space.Attribute(v.colorSource)
+	scale := v.attributeScales.color
+	switch {
+	case attr != nil && attr.Kind == AttributeRGB:
+		if index < len(attr.Colors) && attr.Colors[index].A != 0 {
+			return attr.Colors[index], true
+		}
+	case scale == nil:
+		return v.space.Color(index)
+	case scale.Categorical():
+		if text := attr.Text(index); text != "" {
+			return scale.CategoryColor(text), true
+		}
+	default:
+		if value, ok := v.colorValue(index); ok {
+			return scale.Color(value), true
+		}
+	}
+	return color.RGBA{}, false
+}
+
+// pointSizeOf returns the radius of the point with the given index
+func (v *Visualizer) pointSizeOf(index int) float32 {
+	attr := v.space.Attribute(v.sizeAttribute)
+	if attr == nil {
+		return v.pointSize
+	}
+	value, ok := attr.Value(index)
+	if !ok {
+		return v.pointSize
+	}
+
+	scales := v.attributeScales
+	t := 0.5
+	if scales.sizeMax > scales.sizeMin {
+		t = (value - scales.sizeMin) / (scales.sizeMax - scales.sizeMin)
+	}
+	return v.pointSize * float32(0.5+1.5*t)
+}
+
+// ShowGaps turns the markers at samples where the function failed on or off
+func (v *Visualizer) ShowGaps(show bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.showGaps = show
+}
+
+// SetEvaluationReport records which samples of the function surface recorded
+// by SetFunction couldn't be evaluated
+func (v *Visualizer) SetEvaluationReport(report *EvaluationReport) {
+	if v.plot != nil {
+		v.plot.report = report
+	}
+}
+
+// SetFunction records the function surface being shown, so the function card
+// starts out with its settings and offers sliders for its parameters
+func (v *Visualizer) SetFunction(function string, params []Param, xMin, xMax, yMin, yMax, step float64) {
+	v.plot = newFunctionPlot(function, params, xMin, xMax, yMin, yMax, step)
+	v.plot.prepareGradient()
+}
+
+// newFunctionPlot returns a plot of function sampled on a uniform grid
+func newFunctionPlot(function string, params []Param, xMin, xMax, yMin, yMax, step float64) *functionPlot {
+	return &functionPlot{
+		function: function,
+		params:   params,
+		xMin:     xMin,
+		xMax:     xMax,
+		yMin:     yMin,
+		yMax:     yMax,
+		step:     step,
+	}
+}
+
+// SetAdaptive samples the function surface recorded by SetFunction adaptively
+func (v *Visualizer) SetAdaptive(tolerance float64, maxPoints int) {
+	if v.plot != nil {
+		v.plot.adaptive = true
+		v.plot.tolerance = tolerance
+		v.plot.maxPoints = maxPoints
+	}
+}
+
+// SetComplex plots the function recorded by SetFunction as a complex function
+// of z = x + iy
+func (v *Visualizer) SetComplex() {
+	if v.plot != nil {
+		v.plot.complex = true
+	}
+}
+
+// generate evaluates a fresh surface of the plot into a new space, recording
+// which samples failed in the plot's report. It reports its progress and
+// stops when ctx is cancelled. The shown space is left alone, so generation
+// can run in the background and the result be shown with setSpace.
+func (p *functionPlot) generate(ctx context.Context, progress ProgressFunc) (*Space3D, error) {
+	newSpace := NewSpace3D()
+
+	var report *EvaluationReport
+	var err error
+	switch {
+	case p.complex:
+		report, err = GeneratePointsFromComplexFunctionContext(ctx, newSpace, p.function, p.params, p.xMin, p.xMax, p.yMin, p.yMax, p.step, progress)
+	case p.adaptive:
+		report, err = GenerateAdaptivePointsFromFunctionContext(ctx, newSpace, p.function, p.params, p.xMin, p.xMax, p.yMin, p.yMax, p.step, p.tolerance, p.maxPoints, progress)
+	default:
+		report, err = GeneratePointsFromFunctionContext(ctx, newSpace, p.function, p.params, p.xMin, p.xMax, p.yMin, p.yMax, p.step, progress)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.report = report
+	p.prepareGradient()
+	return newSpace, nil
+}
+
+// prepareGradient builds the function and its gradient for the gradient
+// arrows, once for the plot rather than for every frame
+func (p *functionPlot) prepareGradient() {
+	p.eval, p.gradient = nil, nil
+	eval, err := NewFunctionEvaluator(p.function, p.params...)
+	if err != nil {
+		return
+	}
+	if gradient, err := NewGradient(p.function, p.params...); err == nil {
+		p.eval, p.gradient = eval, gradient
+	}
+}
+
+// setSpace shows space, along with the function plot it was generated from
+// or nil, replacing both in one step so the canvas never draws one with the
+// other's settings. A plot still being generated is cancelled, so it can't
+// replace them afterwards.
+func (v *Visualizer) setSpace(space *Space3D, plot *functionPlot) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stopGeneration()
+	v.space = space
+	v.plot = plot
+	v.scalesReady = false
+}
+
+// stopGeneration cancels the function plot being generated, if any. v.mu
+// must be held.
+func (v *Visualizer) stopGeneration() {
+	if v.cancelGeneration != nil {
+		v.cancelGeneration()
+		v.cancelGeneration = nil
+	}
+	v.generation++
+}
+
+// generate evaluates plot in the background, cancelling any plot still being
+// generated, and shows it with its points once done, unless it failed or
+// was cancelled, such as by a newer plot. finished, if not nil, is then
+// called from the background with the points or the error. The returned
+// function cancels the generation.
+func (v *Visualizer) generate(plot *functionPlot, progress ProgressFunc, finished func(*Space3D, error)) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	v.mu.Lock()
+	v.stopGeneration()
+	v.cancelGeneration = cancel
+	generation := v.generation
+	v.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		space, err := plot.generate(ctx, progress)
+
+		v.mu.Lock()
+		if generation != v.generation {
+			err = context.Canceled
+		} else {
+			v.cancelGeneration = nil
+			if err == nil {
+				v.space = space
+				v.plot = plot
+				v.scalesReady = false
+			}
+		}
+		v.mu.Unlock()
+
+		if err == nil {
+			v.canvasObj.Refresh()
+		}
+		if finished != nil {
+			finished(space, err)
+		}
+	}()
+	return cancel
+}
+
+// updateParamSliders rebuilds the parameter sliders for a function plot, or
+// removes them when plot is nil. Moving a slider re-evaluates the surface in
+// the background and redraws it.
+func (v *Visualizer) updateParamSliders(box *fyne.Container, paramsEntry *widget.Entry, plot *functionPlot) {
+	box.Objects = nil
+
+	if plot != nil {
+		// The values the sliders are at, shared by all of them
+		params := append([]Param(nil), plot.params...)
+		for i, param := range params {
+			i := i
+			label := widget.NewLabel(fmt.Sprintf("%s = %.2f", param.Name, param.Value))
+
+			// Give the slider room on both sides of the starting value
+			span := math.Max(5, 2*math.Abs(param.Value))
+			slider := widget.NewSlider(param.Value-span, param.Value+span)
+			slider.Step = span / 100
+			slider.Value = param.Value
+			slider.OnChanged = func(value float64) {
+				params[i].Value = value
+				label.SetText(fmt.Sprintf("%s = %.2f", params[i].Name, value))
+				paramsEntry.SetText(FormatParams(params))
+
+				// Keep showing the previous surface until this one is
+				// generated, which moving a slider again cancels
+				moved := *plot
+				moved.params = append([]Param(nil), params...)
+				v.generate(&moved, nil, nil)
+			}
+
+			box.Add(label)
+			box.Add(slider)
+		}
+	}
+
+	box.Refresh()
+}
+
+// resetView restores the default rotation, zoom and pan and redraws
+func (v *Visualizer) resetView() {
+	v.mu.Lock()
+	v.xRotation = 0
+	v.yRotation = 0
+	v.zRotation = 0
+	v.scale = 50
+	v.xOffset = 0
+	v.yOffset = 0
+	v.mu.Unlock()
+	v.canvasObj.Refresh()
+}
+
+// rotate3D applies the view rotation to a 3D point. The resulting y grows
+// down the screen and z away from the viewer.
+func (v *Visualizer) rotate3D(point Point3D) (float64, float64, float64) {
 	// Apply rotations (very simple rotation around axes)
 	// For a real application, you'd want to use a proper 3D matrix library
 	x, y, z := point.X, point.Y, point.Z
 
+	// Turn the up axis toward the top of the screen, which is negative y,
+	// keeping the axes right-handed
+	if v.upAxis == UpZ {
+		y, z = -z, y
+	} else {
+		y, z = -y, -z
+	}
+
 	// Apply rotations (just a simple example)
 	// X rotation
 	tempY := y*math.Cos(v.xRotation) - z*math.Sin(v.xRotation)
@@ -94,6 +636,13 @@ func (v *Visualizer) project3DTo2D(point Point3D) (float32, float32) {
 	tempY = x*math.Sin(v.zRotation) + y*math.Cos(v.zRotation)
 	x, y = tempX, tempY
 
+	return x, y, z
+}
+
+// project3DTo2D projects a 3D point onto a 2D plane with simple perspective
+func (v *Visualizer) project3DTo2D(point Point3D) (float32, float32) {
+	x, y, z := v.rotate3D(point)
+
 	// Scale, center, and add offset
 	// Add a simple perspective effect (farther objects appear smaller)
 	perspective := float64(600) / (float64(600) + z)
@@ -134,8 +683,10 @@ func (v *Visualizer) handleMouseUp() {
 // Custom MouseMoved event handler
 func (v *Visualizer) handleMouseMove(ev *desktop.MouseEvent) {
 	// Always update hover position
+	v.mu.Lock()
 	v.hoverX = float64(ev.Position.X)
 	v.hoverY = float64(ev.Position.Y)
+	v.mu.Unlock()
 	
 	// Refresh to update hover effects
 	v.canvasObj.Refresh()
@@ -153,6 +704,7 @@ func (v *Visualizer) handleMouseMove(ev *desktop.MouseEvent) {
 	v.lastMousePosY = float64(ev.Position.Y)
 	
 	// Handle based on mode or R key
+	v.mu.Lock()
 	if v.rKeyPressed || v.rotateMode {
 		// Rotation - adjust the rotation based on mouse movement
 		sensitivity := 0.01
@@ -163,6 +715,7 @@ func (v *Visualizer) handleMouseMove(ev *desktop.MouseEvent) {
 		v.xOffset += deltaX
 		v.yOffset += deltaY
 	}
+	v.mu.Unlock()
 
 	// Refresh the canvas
 	v.canvasObj.Refresh()
@@ -170,6 +723,8 @@ func (v *Visualizer) handleMouseMove(ev *desktop.MouseEvent) {
 
 // Custom scroll event handler for zooming or rotating
 func (v *Visualizer) handleScroll(ev *fyne.ScrollEvent) {
+	v.mu.Lock()
+
 	// Check if R key is pressed for rotation
 	if v.rKeyPressed {
 		// R key + scroll for rotation
@@ -203,6 +758,7 @@ func (v *Visualizer) handleScroll(ev *fyne.ScrollEvent) {
 			v.scale = 500
 		}
 	}
+	v.mu.Unlock()
 	
 	// Refresh the canvas
 	v.canvasObj.Refresh()
@@ -216,6 +772,9 @@ func (v *Visualizer) Run() {
 
 	// Create a canvas to draw on
 	v.canvasObj = canvas.NewRaster(func(w, h int) image.Image {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
 		img := image.NewRGBA(image.Rect(0, 0, w, h))
 
 		// Update width and height based on current canvas size
@@ -334,12 +893,89 @@ func (v *Visualizer) Run() {
 		drawString(img, "Y", int(yx)+5, int(yy)-5, color.RGBA{0, 255, 0, 255})
 		drawString(img, "Z", int(zx)+5, int(zy)-5, color.RGBA{0, 0, 255, 255})
 
-		// Draw points
-		for _, point := range v.space.Points {
+		if !v.scalesReady {
+			v.prepareAttributes()
+			v.scalesReady = true
+		}
+
+		// Points on faces are drawn as part of the faces, except in points mode
+		onFace := make([]bool, len(v.space.Points))
+		if v.renderMode != renderPoints {
+			for _, face := range v.space.Faces {
+				for _, index := range face {
+					onFace[index] = true
+				}
+			}
+		}
+
+		switch v.renderMode {
+		case renderWireframe:
+			// Draw mesh faces as a wireframe
+			wireColor := color.RGBA{70, 110, 160, 255}
+			for _, face := range v.space.Faces {
+				for i := range face {
+					x1, y1 := v.project3DTo2D(v.space.Points[face[i]])
+					x2, y2 := v.project3DTo2D(v.space.Points[face[(i+1)%len(face)]])
+
+					// Edges take the color of their first point, if it has one
+					clr := wireColor
+					if c, ok := v.pointColor(face[i]); ok {
+						clr = c
+					}
+
+					// Only draw if within screen bounds
+					if isVisible(int(x1), int(y1), w, h) && isVisible(int(x2), int(y2), w, h) {
+						drawLine(img, int(x1), int(y1), int(x2), int(y2), clr)
+					}
+				}
+			}
+
+		case renderShaded:
+			v.drawShadedFaces(img)
+		}
+
+		if v.showGradient {
+			v.drawGradientArrows(img)
+		}
+
+		v.drawVectorArrows(img)
+
+		// Mark the holes in the function's domain
+		if v.showGaps && v.plot != nil && v.plot.report != nil {
+			gapColor := color.RGBA{220, 40, 40, 255}
+			for _, gap := range v.plot.report.Gaps {
+				x, y := v.project3DTo2D(gap)
+				drawLine(img, int(x)-3, int(y)-3, int(x)+3, int(y)+3, gapColor)
+				drawLine(img, int(x)-3, int(y)+3, int(x)+3, int(y)-3, gapColor)
+			}
+		}
+
+		// Draw polylines connecting their points in order
+		lineColor := color.RGBA{30, 144, 255, 255}
+		for _, line := range v.space.Polylines {
+			for i := 1; i < len(line); i++ {
+				x1, y1 := v.project3DTo2D(v.space.Points[line[i-1]])
+				x2, y2 := v.project3DTo2D(v.space.Points[line[i]])
+
+				// Only draw if within screen bounds
+				if isVisible(int(x1), int(y1), w, h) && isVisible(int(x2), int(y2), w, h) {
+					drawThickLine(img, int(x1), int(y1), int(x2), int(y2), lineColor, 2)
+				}
+			}
+		}
+
+		// Draw points, except those drawn as vector field arrows
+		for i, point := range v.space.Points {
+			if onFace[i] {
+				continue
+			}
+			if _, ok := v.space.Vector(i); ok {
+				continue
+			}
 			screenX, screenY := v.project3DTo2D(point)
 
 			// Draw a point with border
-			size := int(v.pointSize)
+			size := int(v.pointSizeOf(i))
 
 			// Draw border (black outline)
 			borderSize := size + 2
@@ -354,13 +990,17 @@ func (v *Visualizer) Run() {
 				}
 			}
 
-			// Draw inner circle (blue)
+			// Draw inner circle (blue, unless the point has a color)
+			fill := color.RGBA{30, 144, 255, 255}
+			if c, ok := v.pointColor(i); ok {
+				fill = c
+			}
 			for y := -size; y <= size; y++ {
 				for x := -size; x <= size; x++ {
 					if x*x+y*y <= size*size {
 						px, py := int(screenX)+x, int(screenY)+y
 						if px >= 0 && px < w && py >= 0 && py < h {
-							img.Set(px, py, color.RGBA{30, 144, 255, 255})
+							img.Set(px, py, fill)
 						}
 					}
 				}
@@ -375,10 +1015,14 @@ func (v *Visualizer) Run() {
 			if mouseX >= pointX-boxSize && mouseX <= pointX+boxSize &&
 			   mouseY >= pointY-boxSize && mouseY <= pointY+boxSize {
 				coordStr := formatCoord(point)
+				if attr := v.space.Attribute(v.labelAttribute); attr != nil {
+					coordStr += " " + attr.Name + "=" + attr.Text(i)
+				}
 				drawString(img, coordStr, int(screenX)+size+5, int(screenY)-5, color.RGBA{50, 50, 50, 255})
 			}
 		}
 
+		v.drawColorLegend(img)
 
 		return img
 	})
@@ -389,17 +1033,134 @@ func (v *Visualizer) Run() {
 
 	// Reset button
 	resetBtn := widget.NewButton("Reset View", func() {
-		v.xRotation = 0
-		v.yRotation = 0
-		v.zRotation = 0
-		v.scale = 50
-		v.xOffset = 0
-		v.yOffset = 0
-		v.canvasObj.Refresh()
+		v.resetView()
 	})
 	
-	// Upload CSV button
-	uploadBtn := widget.NewButton("Upload CSV", func() {
+	// Free parameter inputs, plus a slider for each parameter once plotted
+	paramsEntry := widget.NewEntry()
+	paramsEntry.SetPlaceHolder("e.g., a=1,b=2")
+	paramSliders := container.New(layout.NewVBoxLayout())
+
+	// Partial derivatives of the plotted function
+	derivativesLabel := widget.NewLabel("")
+	derivativesLabel.Wrapping = fyne.TextWrapWord
+	showDerivatives := func(plot *functionPlot) {
+		if plot == nil || plot.complex {
+			derivativesLabel.SetText("")
+			return
+		}
+		gradient, err := NewGradient(plot.function, plot.params...)
+		if err != nil {
+			derivativesLabel.SetText(fmt.Sprintf("No derivatives: %v", err))
+			return
+		}
+		derivativesLabel.SetText(fmt.Sprintf("∂f/∂x = %s\n∂f/∂y = %s", gradient.DX.Expression(), gradient.DY.Expression()))
+	}
+
+	// Point attributes that color, size and label the points
+	const noAttribute = "None"
+	attributeSelect := func(set func(name string)) *widget.Select {
+		return widget.NewSelect([]string{noAttribute}, func(selected string) {
+			if selected == noAttribute {
+				selected = ""
+			}
+			set(selected)
+			v.canvasObj.Refresh()
+		})
+	}
+	colorBySelect := attributeSelect(v.ColorBy)
+	sizeBySelect := attributeSelect(v.SizeBy)
+	labelSelect := attributeSelect(v.LabelBy)
+	showAttributes := func(space *Space3D) {
+		all := []string{noAttribute}
+		numeric := []string{noAttribute}
+		colorSources := []string{noAttribute, ColorByHeight, ColorByDistance}
+		for _, attr := range space.Attributes {
+			all = append(all, attr.Name)
+			if attr.Numeric() {
+				numeric = append(numeric, attr.Name)
+			}
+			if attr.Name != ColorByHeight && attr.Name != ColorByDistance {
+				colorSources = append(colorSources, attr.Name)
+			}
+		}
+
+		// Keep the current choices where the new points have them
+		v.mu.Lock()
+		choices := []struct {
+			sel     *widget.Select
+			options []string
+			current string
+		}{
+			{colorBySelect, colorSources, v.colorSource},
+			{sizeBySelect, numeric, v.sizeAttribute},
+			{labelSelect, all, v.labelAttribute},
+		}
+		v.mu.Unlock()
+		for _, choice := range choices {
+			choice.sel.Options = choice.options
+			selected := noAttribute
+			for _, option := range choice.options {
+				if option == choice.current {
+					selected = option
+				}
+			}
+			choice.sel.SetSelected(selected)
+		}
+	}
+
+	// Colormap and range of the colors, with blank ends found from the points
+	colormapSelect := widget.NewSelect(ColormapNames(), func(selected string) {
+		if colormap, err := ColormapByName(selected); err == nil {
+			v.SetColormap(colormap)
+			v.canvasObj.Refresh()
+		}
+	})
+	colormapSelect.SetSelected(v.colormap.Name)
+	colorRangeEntry := func(placeholder string, value float64, set func(value float64)) *widget.Entry {
+		entry := widget.NewEntry()
+		entry.SetPlaceHolder(placeholder)
+		if !math.IsNaN(value) {
+			entry.SetText(strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		entry.OnChanged = func(text string) {
+			value := math.NaN()
+			if strings.TrimSpace(text) != "" {
+				var err error
+				if value, err = EvaluateConstant(text); err != nil {
+					return
+				}
+			}
+			set(value)
+			v.canvasObj.Refresh()
+		}
+		return entry
+	}
+	colorMinEntry := colorRangeEntry("Auto", v.colorMin, func(value float64) {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.colorMin = value
+		v.scalesReady = false
+	})
+	colorMaxEntry := colorRangeEntry("Auto", v.colorMax, func(value float64) {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.colorMax = value
+		v.scalesReady = false
+	})
+
+	// Columns of uploaded CSV files holding the coordinates
+	columnsEntry := widget.NewEntry()
+	columnsEntry.SetPlaceHolder("Columns, e.g., x=lon,y=lat,z=alt")
+
+	// Upload button for CSV files and PLY, OBJ and STL meshes
+	uploadBtn := widget.NewButton("Upload Points", func() {
+		mapping, err := ParseColumnMapping(columnsEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+
 		openDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
 			if err != nil {
 				dialog.ShowError(err, v.window)
@@ -410,47 +1171,53 @@ func (v *Visualizer) Run() {
 			}
 			defer reader.Close()
 			
-			// Create a temporary file
-			tempFile, err := os.CreateTemp("", "upload-*.csv")
-			if err != nil {
-				dialog.ShowError(err, v.window)
-				return
-			}
-			defer tempFile.Close()
-			
-			// Copy the uploaded file to the temp file
-			_, err = io.Copy(tempFile, reader)
-			if err != nil {
-				dialog.ShowError(err, v.window)
-				return
-			}
-			
-			// Create new space and load points
+			// Create new space and load points straight from the file,
+			// leaving out CSV rows that aren't points
 			newSpace := NewSpace3D()
-			err = newSpace.LoadPointsFromCSV(tempFile.Name())
+			data := bufio.NewReader(reader)
+			header, _ := data.Peek(formatMagicSize)
+			if format := DetectFileFormat(reader.URI().Name(), header); format.Name != "csv" {
+				err = format.Read(newSpace, data)
+			} else {
+				var report *CSVReport
+				report, err = newSpace.ReadPointsFromCSV(data, CSVOptions{Columns: mapping, BadRows: BadRowSkip})
+				if err == nil && len(report.Skipped) > 0 {
+					dialog.ShowInformation("Skipped Rows", fmt.Sprintf("Rows that aren't points were left out: %v", report), v.window)
+				}
+			}
 			if err != nil {
 				dialog.ShowError(err, v.window)
 				return
 			}
 			
 			// Update visualizer with new points
-			v.space = newSpace
+			v.setSpace(newSpace, nil)
+			v.updateParamSliders(paramSliders, paramsEntry, nil)
+			showDerivatives(nil)
+			showAttributes(newSpace)
 			
 			// Reset view for better visualization
-			v.xRotation = 0
-			v.yRotation = 0
-			v.zRotation = 0
-			v.scale = 50
-			v.xOffset = 0
-			v.yOffset = 0
-			v.canvasObj.Refresh()
+			v.resetView()
 		}, v.window)
 		
-		// Set filter for CSV files
-		openDialog.SetFilter(storage.NewExtensionFileFilter([]string{".csv"}))
+		// Set filter for the files of every format
+		openDialog.SetFilter(storage.NewExtensionFileFilter(FileExtensions()))
 		openDialog.Show()
 	})
 	
+	// Up axis selection. Generated plots switch to Z up, the usual convention for math.
+	upAxisRadio := widget.NewRadioGroup(upAxisNames, func(selected string) {
+		for i, name := range upAxisNames {
+			if name == selected {
+				v.SetUpAxis(UpAxis(i))
+			}
+		}
+		v.canvasObj.Refresh()
+	})
+	upAxisRadio.Horizontal = true
+	upAxisRadio.Required = true
+	upAxisRadio.SetSelected(upAxisNames[v.upAxis])
+
 	// Function input form
 	functionCard := widget.NewCard("", "Generate Function", nil)
 	
@@ -472,6 +1239,39 @@ func (v *Visualizer) Run() {
 	
 	stepEntry := widget.NewEntry()
 	stepEntry.SetText("0.1")
+
+	// Complex plotting of f(z) with z = x + iy
+	complexCheck := widget.NewCheck("Complex f(z), z = x + iy", nil)
+
+	// Adaptive sampling inputs
+	adaptiveCheck := widget.NewCheck("Adaptive", nil)
+	toleranceEntry := widget.NewEntry()
+	toleranceEntry.SetText("0.01")
+	maxPointsEntry := widget.NewEntry()
+	maxPointsEntry.SetText("20000")
+
+	// Start from the function given on the command line, if any
+	if v.plot != nil {
+		formatFloat := func(f float64) string {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		functionEntry.SetText(v.plot.function)
+		xMinEntry.SetText(formatFloat(v.plot.xMin))
+		xMaxEntry.SetText(formatFloat(v.plot.xMax))
+		yMinEntry.SetText(formatFloat(v.plot.yMin))
+		yMaxEntry.SetText(formatFloat(v.plot.yMax))
+		stepEntry.SetText(formatFloat(v.plot.step))
+		paramsEntry.SetText(FormatParams(v.plot.params))
+		complexCheck.SetChecked(v.plot.complex)
+		if v.plot.adaptive {
+			adaptiveCheck.SetChecked(true)
+			toleranceEntry.SetText(formatFloat(v.plot.tolerance))
+			maxPointsEntry.SetText(strconv.Itoa(v.plot.maxPoints))
+		}
+		v.updateParamSliders(paramSliders, paramsEntry, v.plot)
+		showDerivatives(v.plot)
+		showAttributes(v.space)
+	}
 	
 	// Function generate button
 	generateBtn := widget.NewButton("Generate Points", func() {
@@ -494,40 +1294,93 @@ func (v *Visualizer) Run() {
 		yMax := parseFloat(yMaxEntry.Text, 3)
 		step := parseFloat(stepEntry.Text, 0.1)
 		
-		if step <= 0 {
-			dialog.ShowError(fmt.Errorf("Step size must be positive"), v.window)
+		if err := checkGrid(xMin, xMax, yMin, yMax, step); err != nil {
+			dialog.ShowError(err, v.window)
 			return
 		}
-		
-		if xMin >= xMax || yMin >= yMax {
-			dialog.ShowError(fmt.Errorf("Min values must be less than max values"), v.window)
+
+		params, err := ParseParams(paramsEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, v.window)
 			return
 		}
-		
-		// Create new space
-		newSpace := NewSpace3D()
-		
-		// Generate points
-		err := GeneratePointsFromFunction(newSpace, functionStr, xMin, xMax, yMin, yMax, step)
-		if err != nil {
+
+		if complexCheck.Checked && adaptiveCheck.Checked {
+			dialog.ShowError(fmt.Errorf("Adaptive sampling isn't available for complex functions"), v.window)
+			return
+		}
+
+		// Parameters the function uses but that weren't given start at 1
+		vars := []string{"x", "y"}
+		if complexCheck.Checked {
+			vars = []string{"z", "i"}
+		}
+		free, err := FreeParams(functionStr, vars...)
+		if err != nil {
 			dialog.ShowError(fmt.Errorf("Error generating points: %v", err), v.window)
 			return
 		}
+		for _, name := range free {
+			declared := false
+			for _, p := range params {
+				declared = declared || p.Name == name
+			}
+			if !declared {
+				params = append(params, Param{Name: name, Value: 1})
+			}
+		}
 		
-		// Update visualizer with new points
-		v.space = newSpace
-		
-		// Reset view for better visualization
-		v.xRotation = 0
-		v.yRotation = 0
-		v.zRotation = 0
-		v.scale = 50
-		v.xOffset = 0
-		v.yOffset = 0
-		v.canvasObj.Refresh()
-		
-		// Show success message
-		dialog.ShowInformation("Success", fmt.Sprintf("Generated %d points", len(newSpace.Points)), v.window)
+		// Generate points
+		plot := newFunctionPlot(functionStr, params, xMin, xMax, yMin, yMax, step)
+		plot.complex = complexCheck.Checked
+		if adaptiveCheck.Checked {
+			maxPoints, err := strconv.Atoi(maxPointsEntry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("Invalid number of points: %s", maxPointsEntry.Text), v.window)
+				return
+			}
+			plot.adaptive = true
+			plot.tolerance = parseFloat(toleranceEntry.Text, 0.01)
+			plot.maxPoints = maxPoints
+		}
+
+		// Evaluate in the background, showing progress with a way to cancel.
+		// The progress goes through a binding, which may be set from any
+		// goroutine.
+		progress := binding.NewFloat()
+		progressDialog := dialog.NewCustom("Generating Points", "Cancel", widget.NewProgressBarWithData(progress), v.window)
+		progressDialog.Show()
+		cancel := v.generate(plot, func(done, total int) {
+			progress.Set(float64(done) / float64(total))
+		}, func(space *Space3D, err error) {
+			progressDialog.Hide()
+
+			// A cancelled plot just leaves the previous one in place
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("Error generating points: %v", err), v.window)
+				return
+			}
+
+			paramsEntry.SetText(FormatParams(params))
+			v.updateParamSliders(paramSliders, paramsEntry, plot)
+			showDerivatives(plot)
+			showAttributes(space)
+
+			// Show generated plots with Z up and reset view for better visualization
+			upAxisRadio.SetSelected(upAxisNames[UpZ])
+			v.resetView()
+
+			// Show success message, with any samples that failed
+			message := fmt.Sprintf("Generated %d points", len(space.Points))
+			if report := plot.report; report.Failed() > 0 {
+				message += "\n\n" + report.String()
+			}
+			dialog.ShowInformation("Success", message, v.window)
+		})
+		progressDialog.SetOnClosed(cancel)
 	})
 	
 	// Arrange function inputs in a form
@@ -538,17 +1391,530 @@ func (v *Visualizer) Run() {
 		widget.NewLabel("Y Min:"), yMinEntry,
 		widget.NewLabel("Y Max:"), yMaxEntry,
 		widget.NewLabel("Step:"), stepEntry,
+		widget.NewLabel("Parameters:"), paramsEntry,
+		complexCheck, layout.NewSpacer(),
+		adaptiveCheck, layout.NewSpacer(),
+		widget.NewLabel("Tolerance:"), toleranceEntry,
+		widget.NewLabel("Max Points:"), maxPointsEntry,
 	)
 	
-	functionCard.SetContent(container.New(layout.NewVBoxLayout(),
-		functionForm,
-		generateBtn,
+	// Parametric curve inputs
+	curveXEntry := widget.NewEntry()
+	curveXEntry.SetText("cos(t)")
+	curveYEntry := widget.NewEntry()
+	curveYEntry.SetText("sin(t)")
+	curveZEntry := widget.NewEntry()
+	curveZEntry.SetText("t/3")
+
+	tMinEntry := widget.NewEntry()
+	tMinEntry.SetText("0")
+	tMaxEntry := widget.NewEntry()
+	tMaxEntry.SetText("10")
+
+	samplesEntry := widget.NewEntry()
+	samplesEntry.SetText("200")
+
+	curveParamsEntry := widget.NewEntry()
+	curveParamsEntry.SetPlaceHolder("e.g., a=1,b=2")
+
+	// Curve generate button
+	generateCurveBtn := widget.NewButton("Generate Curve", func() {
+		tMin, err := strconv.ParseFloat(tMinEntry.Text, 64)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Invalid number: %s", tMinEntry.Text), v.window)
+			return
+		}
+		tMax, err := strconv.ParseFloat(tMaxEntry.Text, 64)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Invalid number: %s", tMaxEntry.Text), v.window)
+			return
+		}
+		samples, err := strconv.Atoi(samplesEntry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Invalid number of samples: %s", samplesEntry.Text), v.window)
+			return
+		}
+
+		if tMin >= tMax {
+			dialog.ShowError(fmt.Errorf("Min values must be less than max values"), v.window)
+			return
+		}
+
+		params, err := ParseParams(curveParamsEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+
+		// Generate points
+		newSpace := NewSpace3D()
+		report, err := GeneratePointsFromCurve(newSpace, curveXEntry.Text, curveYEntry.Text, curveZEntry.Text, params, tMin, tMax, samples)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Error generating curve: %v", err), v.window)
+			return
+		}
+
+		// Update visualizer with new points
+		v.setSpace(newSpace, nil)
+		v.updateParamSliders(paramSliders, paramsEntry, nil)
+		showDerivatives(nil)
+		showAttributes(newSpace)
+
+		// Show generated plots with Z up and reset view for better visualization
+		upAxisRadio.SetSelected(upAxisNames[UpZ])
+		v.resetView()
+
+		// Show success message
+		message := fmt.Sprintf("Generated %d points", len(newSpace.Points))
+		if report.Failed() > 0 {
+			message += "\n\n" + report.String()
+		}
+		dialog.ShowInformation("Success", message, v.window)
+	})
+
+	// Arrange curve inputs in a form
+	curveForm := container.New(layout.NewFormLayout(),
+		widget.NewLabel("x(t):"), curveXEntry,
+		widget.NewLabel("y(t):"), curveYEntry,
+		widget.NewLabel("z(t):"), curveZEntry,
+		widget.NewLabel("T Min:"), tMinEntry,
+		widget.NewLabel("T Max:"), tMaxEntry,
+		widget.NewLabel("Samples:"), samplesEntry,
+		widget.NewLabel("Parameters:"), curveParamsEntry,
+	)
+
+	// ODE system inputs, starting with the Lorenz system
+	odeXEntry := widget.NewEntry()
+	odeXEntry.SetText("s*(y - x)")
+	odeYEntry := widget.NewEntry()
+	odeYEntry.SetText("x*(r - z) - y")
+	odeZEntry := widget.NewEntry()
+	odeZEntry.SetText("x*y - b*z")
+
+	initEntry := widget.NewEntry()
+	initEntry.SetText("1, 1, 1")
+
+	odeTMinEntry := widget.NewEntry()
+	odeTMinEntry.SetText("0")
+	odeTMaxEntry := widget.NewEntry()
+	odeTMaxEntry.SetText("40")
+
+	dtEntry := widget.NewEntry()
+	dtEntry.SetText("0.01")
+
+	methodSelect := widget.NewSelect(odeMethodNames, nil)
+	methodSelect.SetSelected(ODERK45.String())
+
+	odeParamsEntry := widget.NewEntry()
+	odeParamsEntry.SetText("s=10,r=28,b=2.6667")
+
+	// ODE solve button
+	solveODEBtn := widget.NewButton("Solve", func() {
+		initial, err := ParsePoint(initEntry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Invalid initial state: %v", err), v.window)
+			return
+		}
+		var times [3]float64
+		for i, entry := range []*widget.Entry{odeTMinEntry, odeTMaxEntry, dtEntry} {
+			value, err := EvaluateConstant(entry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("Invalid number: %s", entry.Text), v.window)
+				return
+			}
+			times[i] = value
+		}
+		t0, t1, dt := times[0], times[1], times[2]
+		method, err := ParseODEMethod(methodSelect.Selected)
+		if err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+
+		params, err := ParseParams(odeParamsEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+
+		// Solve, keeping a solution that stops early up to where it stopped
+		newSpace := NewSpace3D()
+		err = GeneratePointsFromODE(newSpace, odeXEntry.Text, odeYEntry.Text, odeZEntry.Text, params, initial, method, t0, t1, dt, 1e-6)
+		if err != nil && len(newSpace.Points) == 0 {
+			dialog.ShowError(fmt.Errorf("Error solving ODE system: %v", err), v.window)
+			return
+		}
+
+		// Update visualizer with new points
+		v.setSpace(newSpace, nil)
+		v.updateParamSliders(paramSliders, paramsEntry, nil)
+		showDerivatives(nil)
+		showAttributes(newSpace)
+
+		// Show generated plots with Z up and reset view for better visualization
+		upAxisRadio.SetSelected(upAxisNames[UpZ])
+		v.resetView()
+
+		// Show success message, saying why the solution stopped early if it did
+		message := fmt.Sprintf("Generated %d points", len(newSpace.Points))
+		if err != nil {
+			message += fmt.Sprintf("\n\nSolution stopped early: %v", err)
+		}
+		dialog.ShowInformation("Success", message, v.window)
+	})
+
+	// Arrange ODE inputs in a form
+	odeForm := container.New(layout.NewFormLayout(),
+		widget.NewLabel("dx/dt:"), odeXEntry,
+		widget.NewLabel("dy/dt:"), odeYEntry,
+		widget.NewLabel("dz/dt:"), odeZEntry,
+		widget.NewLabel("x, y, z at T Min:"), initEntry,
+		widget.NewLabel("T Min:"), odeTMinEntry,
+		widget.NewLabel("T Max:"), odeTMaxEntry,
+		widget.NewLabel("Time step:"), dtEntry,
+		widget.NewLabel("Method:"), methodSelect,
+		widget.NewLabel("Parameters:"), odeParamsEntry,
+	)
+
+	// Parametric surface inputs
+	surfaceXEntry := widget.NewEntry()
+	surfaceXEntry.SetText("cos(u)*sin(v)")
+	surfaceYEntry := widget.NewEntry()
+	surfaceYEntry.SetText("sin(u)*sin(v)")
+	surfaceZEntry := widget.NewEntry()
+	surfaceZEntry.SetText("cos(v)")
+
+	uMinEntry := widget.NewEntry()
+	uMinEntry.SetText("0")
+	uMaxEntry := widget.NewEntry()
+	uMaxEntry.SetText("2*pi")
+	vMinEntry := widget.NewEntry()
+	vMinEntry.SetText("0")
+	vMaxEntry := widget.NewEntry()
+	vMaxEntry.SetText("pi")
+
+	uSamplesEntry := widget.NewEntry()
+	uSamplesEntry.SetText("40")
+	vSamplesEntry := widget.NewEntry()
+	vSamplesEntry.SetText("20")
+
+	surfaceParamsEntry := widget.NewEntry()
+	surfaceParamsEntry.SetPlaceHolder("e.g., a=1,b=2")
+
+	// Surface generate button
+	generateSurfaceBtn := widget.NewButton("Generate Surface", func() {
+		// Ranges may be written as constant expressions such as 2*pi
+		var bounds [4]float64
+		for i, entry := range []*widget.Entry{uMinEntry, uMaxEntry, vMinEntry, vMaxEntry} {
+			value, err := EvaluateConstant(entry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("Invalid number: %s", entry.Text), v.window)
+				return
+			}
+			bounds[i] = value
+		}
+		uMin, uMax, vMin, vMax := bounds[0], bounds[1], bounds[2], bounds[3]
+
+		uSamples, err := strconv.Atoi(uSamplesEntry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Invalid number of samples: %s", uSamplesEntry.Text), v.window)
+			return
+		}
+		vSamples, err := strconv.Atoi(vSamplesEntry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Invalid number of samples: %s", vSamplesEntry.Text), v.window)
+			return
+		}
+
+		if uMin >= uMax || vMin >= vMax {
+			dialog.ShowError(fmt.Errorf("Min values must be less than max values"), v.window)
+			return
+		}
+
+		params, err := ParseParams(surfaceParamsEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+
+		// Generate points
+		newSpace := NewSpace3D()
+		report, err := GeneratePointsFromSurface(newSpace, surfaceXEntry.Text, surfaceYEntry.Text, surfaceZEntry.Text, params, uMin, uMax, vMin, vMax, uSamples, vSamples)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Error generating surface: %v", err), v.window)
+			return
+		}
+
+		// Update visualizer with new points
+		v.setSpace(newSpace, nil)
+		v.updateParamSliders(paramSliders, paramsEntry, nil)
+		showDerivatives(nil)
+		showAttributes(newSpace)
+
+		// Show generated plots with Z up and reset view for better visualization
+		upAxisRadio.SetSelected(upAxisNames[UpZ])
+		v.resetView()
+
+		// Show success message
+		message := fmt.Sprintf("Generated %d points and %d faces", len(newSpace.Points), len(newSpace.Faces))
+		if report.Failed() > 0 {
+			message += "\n\n" + report.String()
+		}
+		dialog.ShowInformation("Success", message, v.window)
+	})
+
+	// Arrange surface inputs in a form
+	surfaceForm := container.New(layout.NewFormLayout(),
+		widget.NewLabel("x(u,v):"), surfaceXEntry,
+		widget.NewLabel("y(u,v):"), surfaceYEntry,
+		widget.NewLabel("z(u,v):"), surfaceZEntry,
+		widget.NewLabel("U Min:"), uMinEntry,
+		widget.NewLabel("U Max:"), uMaxEntry,
+		widget.NewLabel("V Min:"), vMinEntry,
+		widget.NewLabel("V Max:"), vMaxEntry,
+		widget.NewLabel("U Samples:"), uSamplesEntry,
+		widget.NewLabel("V Samples:"), vSamplesEntry,
+		widget.NewLabel("Parameters:"), surfaceParamsEntry,
+	)
+
+	// Implicit surface inputs
+	implicitEntry := widget.NewEntry()
+	implicitEntry.SetText("x^2 + y^2 + z^2 - 4")
+
+	implicitRangeEntry := widget.NewEntry()
+	implicitRangeEntry.SetText("3")
+
+	resolutionEntry := widget.NewEntry()
+	resolutionEntry.SetText("30")
+
+	isoEntry := widget.NewEntry()
+	isoEntry.SetText("0")
+
+	implicitParamsEntry := widget.NewEntry()
+	implicitParamsEntry.SetPlaceHolder("e.g., a=1,b=2")
+
+	// Implicit surface generate button
+	generateImplicitBtn := widget.NewButton("Generate Mesh", func() {
+		extent, err := strconv.ParseFloat(implicitRangeEntry.Text, 64)
+		if err != nil || extent <= 0 {
+			dialog.ShowError(fmt.Errorf("Range must be a positive number"), v.window)
+			return
+		}
+		resolution, err := strconv.Atoi(resolutionEntry.Text)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Invalid resolution: %s", resolutionEntry.Text), v.window)
+			return
+		}
+		iso, err := strconv.ParseFloat(isoEntry.Text, 64)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Invalid number: %s", isoEntry.Text), v.window)
+			return
+		}
+
+		params, err := ParseParams(implicitParamsEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+
+		// Generate mesh in the cube [-range, range]^3
+		newSpace := NewSpace3D()
+		err = GeneratePointsFromImplicit(newSpace, implicitEntry.Text, params, -extent, extent, -extent, extent, -extent, extent, resolution, iso)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Error generating mesh: %v", err), v.window)
+			return
+		}
+
+		// Update visualizer with new points
+		v.setSpace(newSpace, nil)
+		v.updateParamSliders(paramSliders, paramsEntry, nil)
+		showDerivatives(nil)
+		showAttributes(newSpace)
+
+		// Show generated plots with Z up and reset view for better visualization
+		upAxisRadio.SetSelected(upAxisNames[UpZ])
+		v.resetView()
+
+		// Show success message
+		dialog.ShowInformation("Success", fmt.Sprintf("Generated %d points and %d faces", len(newSpace.Points), len(newSpace.Faces)), v.window)
+	})
+
+	// Arrange implicit surface inputs in a form
+	implicitForm := container.New(layout.NewFormLayout(),
+		widget.NewLabel("f(x,y,z):"), implicitEntry,
+		widget.NewLabel("Iso-value:"), isoEntry,
+		widget.NewLabel("Range (±):"), implicitRangeEntry,
+		widget.NewLabel("Resolution:"), resolutionEntry,
+		widget.NewLabel("Parameters:"), implicitParamsEntry,
+	)
+
+	// Vector field inputs
+	vectorXEntry := widget.NewEntry()
+	vectorXEntry.SetText("-y")
+
+	vectorYEntry := widget.NewEntry()
+	vectorYEntry.SetText("x")
+
+	vectorZEntry := widget.NewEntry()
+	vectorZEntry.SetText("z/2")
+
+	vectorRangeEntry := widget.NewEntry()
+	vectorRangeEntry.SetText("2")
+
+	vectorStepEntry := widget.NewEntry()
+	vectorStepEntry.SetText("0.5")
+
+	vectorParamsEntry := widget.NewEntry()
+	vectorParamsEntry.SetPlaceHolder("e.g., a=1,b=2")
+
+	// Streamlines are seeded from the lattice instead of drawing its arrows
+	streamlinesCheck := widget.NewCheck("Streamlines from lattice", nil)
+
+	// Vector field generate button
+	generateVectorBtn := widget.NewButton("Generate Arrows", func() {
+		extent, err := strconv.ParseFloat(vectorRangeEntry.Text, 64)
+		if err != nil || extent <= 0 {
+			dialog.ShowError(fmt.Errorf("Range must be a positive number"), v.window)
+			return
+		}
+		step, err := strconv.ParseFloat(vectorStepEntry.Text, 64)
+		if err != nil || step <= 0 {
+			dialog.ShowError(fmt.Errorf("Step size must be positive"), v.window)
+			return
+		}
+
+		params, err := ParseParams(vectorParamsEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, v.window)
+			return
+		}
+
+		// Sample the field on a lattice in the cube [-range, range]^3, or
+		// trace streamlines from it, integrating in tenths of a step
+		newSpace := NewSpace3D()
+		var report *EvaluationReport
+		if streamlinesCheck.Checked {
+			seeds := GridSeeds(-extent, extent, -extent, extent, -extent, extent, step)
+			err = GenerateStreamlines(newSpace, vectorXEntry.Text, vectorYEntry.Text, vectorZEntry.Text, params, seeds, -extent, extent, -extent, extent, -extent, extent, step/10, step*1e-4, 8*extent)
+		} else {
+			report, err = GenerateVectorField(newSpace, vectorXEntry.Text, vectorYEntry.Text, vectorZEntry.Text, params, -extent, extent, -extent, extent, -extent, extent, step)
+		}
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("Error generating vector field: %v", err), v.window)
+			return
+		}
+
+		// Update visualizer with new points
+		v.setSpace(newSpace, nil)
+		v.SetArrowLength(step)
+		v.updateParamSliders(paramSliders, paramsEntry, nil)
+		showDerivatives(nil)
+		showAttributes(newSpace)
+
+		// Show generated plots with Z up and reset view for better visualization
+		upAxisRadio.SetSelected(upAxisNames[UpZ])
+		v.resetView()
+
+		// Show success message
+		message := fmt.Sprintf("Generated %d arrows", len(newSpace.Points))
+		if streamlinesCheck.Checked {
+			message = fmt.Sprintf("Generated %d streamlines with %d points", len(newSpace.Polylines), len(newSpace.Points))
+		}
+		if report.Failed() > 0 {
+			message += "\n\n" + report.String()
+		}
+		dialog.ShowInformation("Success", message, v.window)
+	})
+
+	// Arrange vector field inputs in a form
+	vectorForm := container.New(layout.NewFormLayout(),
+		widget.NewLabel("Fx(x,y,z):"), vectorXEntry,
+		widget.NewLabel("Fy(x,y,z):"), vectorYEntry,
+		widget.NewLabel("Fz(x,y,z):"), vectorZEntry,
+		widget.NewLabel("Range (±):"), vectorRangeEntry,
+		widget.NewLabel("Step:"), vectorStepEntry,
+		widget.NewLabel("Parameters:"), vectorParamsEntry,
+	)
+
+	functionCard.SetContent(container.NewAppTabs(
+		container.NewTabItem("Function", container.New(layout.NewVBoxLayout(),
+			functionForm,
+			generateBtn,
+			derivativesLabel,
+			paramSliders,
+		)),
+		container.NewTabItem("Curve", container.New(layout.NewVBoxLayout(),
+			curveForm,
+			generateCurveBtn,
+		)),
+		container.NewTabItem("ODE", container.New(layout.NewVBoxLayout(),
+			odeForm,
+			solveODEBtn,
+		)),
+		container.NewTabItem("Surface", container.New(layout.NewVBoxLayout(),
+			surfaceForm,
+			generateSurfaceBtn,
+		)),
+		container.NewTabItem("Implicit", container.New(layout.NewVBoxLayout(),
+			implicitForm,
+			generateImplicitBtn,
+		)),
+		container.NewTabItem("Vector", container.New(layout.NewVBoxLayout(),
+			vectorForm,
+			streamlinesCheck,
+			generateVectorBtn,
+		)),
 	))
 
+	// Render mode selection
+	renderModeRadio := widget.NewRadioGroup(renderModeNames, func(selected string) {
+		for i, name := range renderModeNames {
+			if name == selected {
+				v.mu.Lock()
+				v.renderMode = renderMode(i)
+				v.mu.Unlock()
+			}
+		}
+		v.canvasObj.Refresh()
+	})
+	renderModeRadio.Horizontal = true
+	renderModeRadio.Required = true
+	renderModeRadio.SetSelected(renderModeNames[v.renderMode])
+
+	// Gradient arrows over the function surface
+	gradientCheck := widget.NewCheck("Gradient arrows", func(checked bool) {
+		v.ShowGradient(checked)
+		v.canvasObj.Refresh()
+	})
+	gradientCheck.SetChecked(v.showGradient)
+
+	// Markers where the function couldn't be evaluated
+	gapsCheck := widget.NewCheck("Mark gaps", func(checked bool) {
+		v.ShowGaps(checked)
+		v.canvasObj.Refresh()
+	})
+	gapsCheck.SetChecked(v.showGaps)
+
+	// Attributes of the points, such as extra CSV columns
+	showAttributes(v.space)
+	attributesForm := container.New(layout.NewFormLayout(),
+		widget.NewLabel("Color by:"), colorBySelect,
+		widget.NewLabel("Colormap:"), colormapSelect,
+		widget.NewLabel("Color range:"), container.NewGridWithColumns(2, colorMinEntry, colorMaxEntry),
+		widget.NewLabel("Size by:"), sizeBySelect,
+		widget.NewLabel("Label:"), labelSelect,
+	)
+
 	// Layout
 	controls := container.New(layout.NewVBoxLayout(),
 		functionCard,
 		instructionsCard,
+		renderModeRadio,
+		gradientCheck,
+		gapsCheck,
+		attributesForm,
+		upAxisRadio,
+		columnsEntry,
 		uploadBtn,
 		resetBtn,
 	)
@@ -639,6 +2005,282 @@ var _ desktop.Mouseable = (*canvasWrapper)(nil)
 var _ desktop.Hoverable = (*canvasWrapper)(nil)
 var _ fyne.Scrollable = (*canvasWrapper)(nil)
 
+// drawShadedFaces fills the faces of the space back to front, so nearer faces
+// cover farther ones. Faces are lit from the viewer, and both sides of a face
+// are lit alike.
+func (v *Visualizer) drawShadedFaces(img *image.RGBA) {
+	type shadedFace struct {
+		face      Face
+		depth     float64
+		intensity float64
+		base      color.RGBA
+	}
+
+	faces := make([]shadedFace, 0, len(v.space.Faces))
+	for _, face := range v.space.Faces {
+		if len(face) < 3 {
+			continue
+		}
+
+		// Average depth and Newell normal of the rotated face
+		var depth, nx, ny, nz float64
+		for i := range face {
+			x1, y1, z1 := v.rotate3D(v.space.Points[face[i]])
+			x2, y2, z2 := v.rotate3D(v.space.Points[face[(i+1)%len(face)]])
+			depth += z1
+			nx += (y1 - y2) * (z1 + z2)
+			ny += (z1 - z2) * (x1 + x2)
+			nz += (x1 - x2) * (y1 + y2)
+		}
+		depth /= float64(len(face))
+
+		// Prefer the average of the exact point normals, when all are known
+		var normal Point3D
+		exact := true
+		for _, index := range face {
+			n, ok := v.space.Normal(index)
+			if !ok {
+				exact = false
+				break
+			}
+			normal = NewPoint3D(normal.X+n.X, normal.Y+n.Y, normal.Z+n.Z)
+		}
+		if exact {
+			nx, ny, nz = v.rotate3D(normal)
+		}
+
+		intensity := 0.0
+		if length := math.Sqrt(nx*nx + ny*ny + nz*nz); length > 0 {
+			intensity = math.Abs(nz) / length
+		}
+
+		// Faces take the average color of their points, when all have one
+		base := color.RGBA{30, 144, 255, 255}
+		var r, g, b int
+		colored := true
+		for _, index := range face {
+			c, ok := v.pointColor(index)
+			if !ok {
+				colored = false
+				break
+			}
+			r, g, b = r+int(c.R), g+int(c.G), b+int(c.B)
+		}
+		if colored {
+			n := len(face)
+			base = color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255}
+		}
+
+		faces = append(faces, shadedFace{face: face, depth: depth, intensity: intensity, base: base})
+	}
+
+	sort.Slice(faces, func(i, j int) bool {
+		return faces[i].depth > faces[j].depth
+	})
+
+	for _, f := range faces {
+		light := 0.25 + 0.75*f.intensity
+		clr := color.RGBA{uint8(float64(f.base.R) * light), uint8(float64(f.base.G) * light), uint8(float64(f.base.B) * light), 255}
+
+		// Fill the face as a fan of triangles
+		x0, y0 := v.project3DTo2D(v.space.Points[f.face[0]])
+		for i := 1; i+1 < len(f.face); i++ {
+			x1, y1 := v.project3DTo2D(v.space.Points[f.face[i]])
+			x2, y2 := v.project3DTo2D(v.space.Points[f.face[i+1]])
+			fillTriangle(img, x0, y0, x1, y1, x2, y2, clr)
+		}
+	}
+}
+
+// drawGradientArrows draws arrows over the function surface on a regular
+// lattice. Each arrow lies in the tangent plane and points uphill, with its
+// length proportional to the gradient magnitude.
+func (v *Visualizer) drawGradientArrows(img *image.RGBA) {
+	p := v.plot
+	if p == nil || p.complex || p.gradient == nil {
+		return
+	}
+	eval, gradient := p.eval, p.gradient
+
+	type arrow struct {
+		base   Point3D
+		dx, dy float64
+	}
+
+	const arrowsPerSide = 12
+	cellX := (p.xMax - p.xMin) / arrowsPerSide
+	cellY := (p.yMax - p.yMin) / arrowsPerSide
+
+	var arrows []arrow
+	largest := 0.0
+	for i := 0; i < arrowsPerSide; i++ {
+		x := p.xMin + (float64(i)+0.5)*cellX
+		for j := 0; j < arrowsPerSide; j++ {
+			y := p.yMin + (float64(j)+0.5)*cellY
+
+			z, err := eval.Evaluate(x, y)
+			if err != nil {
+				continue
+			}
+			dx, dy, err := gradient.Evaluate(x, y)
+			if err != nil {
+				continue
+			}
+			arrows = append(arrows, arrow{base: NewPoint3D(x, y, z), dx: dx, dy: dy})
+			largest = math.Max(largest, math.Hypot(dx, dy))
+		}
+	}
+	if largest == 0 {
+		return
+	}
+
+	// The longest arrow nearly spans a lattice cell
+	scale := 0.8 * math.Min(cellX, cellY) / largest
+	arrowColor := color.RGBA{230, 110, 20, 255}
+	for _, a := range arrows {
+		tip := NewPoint3D(
+			a.base.X+a.dx*scale,
+			a.base.Y+a.dy*scale,
+			a.base.Z+(a.dx*a.dx+a.dy*a.dy)*scale,
+		)
+		x1, y1 := v.project3DTo2D(a.base)
+		x2, y2 := v.project3DTo2D(tip)
+		drawArrow(img, x1, y1, x2, y2, arrowColor)
+	}
+}
+
+// drawVectorArrows draws an arrow from each point that carries a field vector,
+// in the point's color. The longest arrow is arrowLength long.
+func (v *Visualizer) drawVectorArrows(img *image.RGBA) {
+	largest := 0.0
+	for _, vec := range v.space.Vectors {
+		largest = math.Max(largest, Magnitude(vec))
+	}
+	if largest == 0 {
+		return
+	}
+
+	scale := v.arrowLength / largest
+	for i, vec := range v.space.Vectors {
+		if vec == (Point3D{}) {
+			continue
+		}
+		base := v.space.Points[i]
+		tip := NewPoint3D(base.X+vec.X*scale, base.Y+vec.Y*scale, base.Z+vec.Z*scale)
+
+		clr := color.RGBA{30, 144, 255, 255}
+		if c, ok := v.pointColor(i); ok {
+			clr = c
+		}
+		x1, y1 := v.project3DTo2D(base)
+		x2, y2 := v.project3DTo2D(tip)
+		drawArrow(img, x1, y1, x2, y2, clr)
+	}
+}
+
+// legendMaxCategories bounds the number of categories listed in the color
+// legend, so long lists of labels don't run off the canvas
+const legendMaxCategories = 12
+
+// drawColorLegend draws a key to the colors of the points in the top right
+// corner of the canvas: a bar of the colormap marked with the numbers at its
+// ends and middle, or a swatch for each category
+func (v *Visualizer) drawColorLegend(img *image.RGBA) {
+	scale := v.attributeScales.color
+	if scale == nil || (!scale.Categorical() && math.IsNaN(scale.Min)) {
+		return
+	}
+
+	textColor := color.RGBA{50, 50, 50, 255}
+	textWidth := func(s string) int { return len([]rune(s)) * 6 }
+	fillRect := func(x, y, w, h int, clr color.RGBA) {
+		for py := y; py < y+h; py++ {
+			for px := x; px < x+w; px++ {
+				if isVisible(px, py, img.Bounds().Max.X, img.Bounds().Max.Y) {
+					img.Set(px, py, clr)
+				}
+			}
+		}
+	}
+
+	right := img.Bounds().Max.X - 10
+	top := 10
+	drawString(img, v.colorSource, right-textWidth(v.colorSource), top, textColor)
+	top += 14
+
+	if scale.Categorical() {
+		for k, name := range scale.Categories {
+			if k == legendMaxCategories {
+				more := fmt.Sprintf("+%d more", len(scale.Categories)-k)
+				drawString(img, more, right-textWidth(more), top, textColor)
+				break
+			}
+			fillRect(right-10, top-1, 10, 8, scale.CategoryColor(name))
+			drawString(img, name, right-18-textWidth(name), top, textColor)
+			top += 12
+		}
+		return
+	}
+
+	// The largest number is at the top of the bar
+	barHeight := 150
+	for dy := 0; dy < barHeight; dy++ {
+		fillRect(right-12, top+dy, 12, 1, scale.Colormap.At(1-float64(dy)/float64(barHeight-1)))
+	}
+	for _, t := range []float64{1, 0.5, 0} {
+		label := strconv.FormatFloat(scale.Min+t*(scale.Max-scale.Min), 'g', 4, 64)
+		y := top + int((1-t)*float64(barHeight-1)) - 3
+		drawString(img, label, right-20-textWidth(label), y, textColor)
+	}
+}
+
+// drawArrow draws a line from (x1, y1) to (x2, y2) with an arrowhead at (x2, y2)
+func drawArrow(img *image.RGBA, x1, y1, x2, y2 float32, clr color.RGBA) {
+	drawLine(img, int(x1), int(y1), int(x2), int(y2), clr)
+
+	dx, dy := float64(x2-x1), float64(y2-y1)
+	length := math.Hypot(dx, dy)
+	if length < 1 {
+		return
+	}
+
+	head := math.Min(8, length/3)
+	angle := math.Atan2(dy, dx)
+	for _, side := range []float64{-0.45, 0.45} {
+		hx := float64(x2) - head*math.Cos(angle+side)
+		hy := float64(y2) - head*math.Sin(angle+side)
+		drawLine(img, int(x2), int(y2), int(hx), int(hy), clr)
+	}
+}
+
+// fillTriangle fills the pixels whose centres lie inside a triangle
+func fillTriangle(img *image.RGBA, x1, y1, x2, y2, x3, y3 float32, clr color.RGBA) {
+	bounds := img.Bounds()
+	minX := int(math.Max(math.Floor(float64(min(x1, x2, x3))), float64(bounds.Min.X)))
+	maxX := int(math.Min(math.Ceil(float64(max(x1, x2, x3))), float64(bounds.Max.X-1)))
+	minY := int(math.Max(math.Floor(float64(min(y1, y2, y3))), float64(bounds.Min.Y)))
+	maxY := int(math.Min(math.Ceil(float64(max(y1, y2, y3))), float64(bounds.Max.Y-1)))
+
+	area := (x2-x1)*(y3-y1) - (x3-x1)*(y2-y1)
+	if area == 0 {
+		return
+	}
+
+	for py := minY; py <= maxY; py++ {
+		for px := minX; px <= maxX; px++ {
+			cx, cy := float32(px)+0.5, float32(py)+0.5
+
+			// Barycentric weights all share the sign of the area when inside
+			w1 := ((x2-cx)*(y3-cy) - (x3-cx)*(y2-cy)) / area
+			w2 := ((x3-cx)*(y1-cy) - (x1-cx)*(y3-cy)) / area
+			w3 := 1 - w1 - w2
+			if w1 >= 0 && w2 >= 0 && w3 >= 0 {
+				img.SetRGBA(px, py, clr)
+			}
+		}
+	}
+}
+
 // Helper function to check if a point is visible on screen
 func isVisible(x, y, width, height int) bool {
 	return x >= 0 && x < width && y >= 0 && y < height
@@ -853,6 +2495,238 @@ func drawString(img *image.RGBA, s string, x, y int, clr color.RGBA) {
 			"#####",
 			"     ",
 		},
+		'A': {
+			" ### ",
+			"#   #",
+			"#####",
+			"#   #",
+			"#   #",
+			"     ",
+		},
+		'B': {
+			"#### ",
+			"#   #",
+			"#### ",
+			"#   #",
+			"#### ",
+			"     ",
+		},
+		'C': {
+			" ####",
+			"#    ",
+			"#    ",
+			"#    ",
+			" ####",
+			"     ",
+		},
+		'D': {
+			"#### ",
+			"#   #",
+			"#   #",
+			"#   #",
+			"#### ",
+			"     ",
+		},
+		'E': {
+			"#####",
+			"#    ",
+			"#### ",
+			"#    ",
+			"#####",
+			"     ",
+		},
+		'F': {
+			"#####",
+			"#    ",
+			"#### ",
+			"#    ",
+			"#    ",
+			"     ",
+		},
+		'G': {
+			" ####",
+			"#    ",
+			"#  ##",
+			"#   #",
+			" ### ",
+			"     ",
+		},
+		'H': {
+			"#   #",
+			"#   #",
+			"#####",
+			"#   #",
+			"#   #",
+			"     ",
+		},
+		'I': {
+			" ### ",
+			"  #  ",
+			"  #  ",
+			"  #  ",
+			" ### ",
+			"     ",
+		},
+		'J': {
+			"  ###",
+			"   # ",
+			"   # ",
+			"#  # ",
+			" ##  ",
+			"     ",
+		},
+		'K': {
+			"#   #",
+			"#  # ",
+			"###  ",
+			"#  # ",
+			"#   #",
+			"     ",
+		},
+		'L': {
+			"#    ",
+			"#    ",
+			"#    ",
+			"#    ",
+			"#####",
+			"     ",
+		},
+		'M': {
+			"#   #",
+			"## ##",
+			"# # #",
+			"#   #",
+			"#   #",
+			"     ",
+		},
+		'N': {
+			"#   #",
+			"##  #",
+			"# # #",
+			"#  ##",
+			"#   #",
+			"     ",
+		},
+		'O': {
+			" ### ",
+			"#   #",
+			"#   #",
+			"#   #",
+			" ### ",
+			"     ",
+		},
+		'P': {
+			"#### ",
+			"#   #",
+			"#### ",
+			"#    ",
+			"#    ",
+			"     ",
+		},
+		'Q': {
+			" ### ",
+			"#   #",
+			"# # #",
+			"#  # ",
+			" ## #",
+			"     ",
+		},
+		'R': {
+			"#### ",
+			"#   #",
+			"#### ",
+			"#  # ",
+			"#   #",
+			"     ",
+		},
+		'S': {
+			" ####",
+			"#    ",
+			" ### ",
+			"    #",
+			"#### ",
+			"     ",
+		},
+		'T': {
+			"#####",
+			"  #  ",
+			"  #  ",
+			"  #  ",
+			"  #  ",
+			"     ",
+		},
+		'U': {
+			"#   #",
+			"#   #",
+			"#   #",
+			"#   #",
+			" ### ",
+			"     ",
+		},
+		'V': {
+			"#   #",
+			"#   #",
+			"#   #",
+			" # # ",
+			"  #  ",
+			"     ",
+		},
+		'W': {
+			"#   #",
+			"#   #",
+			"# # #",
+			"## ##",
+			"#   #",
+			"     ",
+		},
+		'=': {
+			"     ",
+			"#####",
+			"     ",
+			"#####",
+			"     ",
+			"     ",
+		},
+		':': {
+			"     ",
+			"  #  ",
+			"     ",
+			"  #  ",
+			"     ",
+			"     ",
+		},
+		'#': {
+			" # # ",
+			"#####",
+			" # # ",
+			"#####",
+			" # # ",
+			"     ",
+		},
+		'_': {
+			"     ",
+			"     ",
+			"     ",
+			"     ",
+			"#####",
+			"     ",
+		},
+		'/': {
+			"    #",
+			"   # ",
+			"  #  ",
+			" #   ",
+			"#    ",
+			"     ",
+		},
+		'+': {
+			"     ",
+			"  #  ",
+			" ### ",
+			"  #  ",
+			"     ",
+			"     ",
+		},
 	}
 
 	// Set default patterns for any undefined characters
@@ -872,7 +2746,7 @@ func drawString(img *image.RGBA, s string, x, y int, clr color.RGBA) {
 
 	// Draw background for better readability
 	bgPadding := 2
-	bgWidth := len(s)*(charWidth+spacing) + bgPadding*2
+	bgWidth := len([]rune(s))*(charWidth+spacing) + bgPadding*2
 	// Calculate background dimensions
 
 	// Draw semi-transparent background
@@ -885,8 +2759,9 @@ func drawString(img *image.RGBA, s string, x, y int, clr color.RGBA) {
 	}
 
 	// Draw each character
-	for i, char := range s {
-		pattern, ok := fontMap[char]
+	for i, char := range []rune(s) {
+		// Letters are drawn as capitals
+		pattern, ok := fontMap[unicode.ToUpper(char)]
 		if !ok {
 			pattern = defaultPattern
 		}